@@ -0,0 +1,31 @@
+// Package cbor provides the CBOR codec, registered as "cbor"
+package cbor
+
+import (
+	cb "github.com/fxamacker/cbor/v2"
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+// ContentType identifies CBOR payloads
+const ContentType = "application/cbor"
+
+type codec struct{}
+
+// Codec is the CBOR codec
+var Codec mqhub.Codec = codec{}
+
+func (codec) ContentType() string {
+	return ContentType
+}
+
+func (codec) Encode(v interface{}) ([]byte, error) {
+	return cb.Marshal(v)
+}
+
+func (codec) Decode(data []byte, out interface{}) error {
+	return cb.Unmarshal(data, out)
+}
+
+func init() {
+	mqhub.RegisterCodec("cbor", Codec)
+}
@@ -0,0 +1,31 @@
+// Package msgpack provides the MessagePack codec, registered as "msgpack"
+package msgpack
+
+import (
+	"github.com/robotalks/mqhub.go/mqhub"
+	mp "github.com/vmihailenco/msgpack/v5"
+)
+
+// ContentType identifies MessagePack payloads
+const ContentType = "application/msgpack"
+
+type codec struct{}
+
+// Codec is the MessagePack codec
+var Codec mqhub.Codec = codec{}
+
+func (codec) ContentType() string {
+	return ContentType
+}
+
+func (codec) Encode(v interface{}) ([]byte, error) {
+	return mp.Marshal(v)
+}
+
+func (codec) Decode(data []byte, out interface{}) error {
+	return mp.Unmarshal(data, out)
+}
+
+func init() {
+	mqhub.RegisterCodec("msgpack", Codec)
+}
@@ -0,0 +1,42 @@
+// Package protobuf provides the Protocol Buffers codec, registered as
+// "protobuf", values must implement proto.Message
+package protobuf
+
+import (
+	"fmt"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentType identifies Protocol Buffers payloads
+const ContentType = "application/protobuf"
+
+type codec struct{}
+
+// Codec is the Protocol Buffers codec
+var Codec mqhub.Codec = codec{}
+
+func (codec) ContentType() string {
+	return ContentType
+}
+
+func (codec) Encode(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec unable to encode %T", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (codec) Decode(data []byte, out interface{}) error {
+	msg, ok := out.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec unable to decode into %T", out)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func init() {
+	mqhub.RegisterCodec("protobuf", Codec)
+}
@@ -0,0 +1,395 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+)
+
+var (
+	// ErrNotConnected is reported when the connector is not connected to hub
+	ErrNotConnected = fmt.Errorf("not connected")
+)
+
+// Options defines configuration for connector
+type Options struct {
+	// Hub is the name of the hub, connectors with the same hub
+	// name talk to each other
+	Hub       string
+	Namespace string
+	// Presence maintains the presence of published components
+	Presence bool
+	// ClearRetained clears the retained states of datapoints when
+	// unpublished
+	ClearRetained bool
+	// Codec encodes messages for endpoints not specifying one,
+	// JSON is used if not specified
+	Codec mqhub.Codec
+	// ErrorHandler is notified when a reactor fails handling a message
+	ErrorHandler mqhub.ErrorHandler
+	// ErrorEndpoint publishes the failures of reactors to the ErrorEndpoint
+	// of the components
+	ErrorEndpoint bool
+}
+
+// NewOptions creates options
+func NewOptions() *Options {
+	return &Options{}
+}
+
+// Connector connects to an in-memory Hub
+type Connector struct {
+	Hub *Hub
+
+	topicPrefix string
+	exports     []*Publication
+	lock        sync.RWMutex
+	handlers    *TopicHandlerMap
+	lifecycle   *mqhub.LifecycleStream
+	presence    bool
+	clearStates bool
+	codec       mqhub.Codec
+
+	connected bool
+	queue     []*delivery
+	queueCond *sync.Cond
+
+	errorHandler  mqhub.ErrorHandler
+	errorEndpoint bool
+}
+
+type delivery struct {
+	pkt      *packet
+	retained bool
+	target   *HandlerRef
+}
+
+// NewConnector creates a connector
+func NewConnector(options *Options) *Connector {
+	if options == nil {
+		options = NewOptions()
+	}
+	conn := &Connector{
+		Hub:         HubFor(options.Hub),
+		topicPrefix: options.Namespace,
+		handlers:    NewTopicHandlerMap(),
+		lifecycle:   mqhub.NewLifecycleStream(),
+		presence:    options.Presence,
+		clearStates: options.ClearRetained,
+		codec:       options.Codec,
+
+		errorHandler:  options.ErrorHandler,
+		errorEndpoint: options.ErrorEndpoint,
+	}
+	if conn.topicPrefix != "" && !strings.HasSuffix(conn.topicPrefix, "/") {
+		conn.topicPrefix += "/"
+	}
+	conn.handlers.prefix = conn.topicPrefix
+	conn.queueCond = sync.NewCond(&conn.lock)
+	return conn
+}
+
+// Watch implements Watchable
+func (c *Connector) Watch(sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	return watchTopic(c, c, "#", sink)
+}
+
+// Connect attaches to the hub
+func (c *Connector) Connect() mqhub.Future {
+	c.lock.Lock()
+	connecting := !c.connected
+	if connecting {
+		c.connected = true
+		go c.dispatch()
+		c.Hub.attach(c)
+	}
+	c.lock.Unlock()
+	if connecting {
+		c.lifecycle.Emit(mqhub.Connected, nil)
+	}
+	return &mqhub.ImmediateFuture{}
+}
+
+// ConnectContext implements Connector, attaching to the hub never blocks
+func (c *Connector) ConnectContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Connect().Wait()
+}
+
+// Close implements io.Closer
+func (c *Connector) Close() error {
+	if c.presence && c.isConnected() {
+		// nothing is lost in memory, components go offline immediately
+		c.lock.RLock()
+		exports := make([]*Publication, len(c.exports))
+		copy(exports, c.exports)
+		c.lock.RUnlock()
+		for _, pub := range exports {
+			pub.announce(false)
+		}
+	}
+	c.Hub.detach(c)
+	c.lock.Lock()
+	closing := c.connected
+	c.connected = false
+	c.queue = nil
+	c.queueCond.Broadcast()
+	c.lock.Unlock()
+	if closing {
+		c.lifecycle.Emit(mqhub.Closed, nil)
+	}
+	return nil
+}
+
+// Lifecycle implements Connector, in-memory connection never drops
+func (c *Connector) Lifecycle() mqhub.Watchable {
+	return c.lifecycle
+}
+
+// Publish implements Publisher
+func (c *Connector) Publish(comp mqhub.Component) (mqhub.Publication, error) {
+	return c.publish(comp, "")
+}
+
+// PublishShared is same as PublishContext, and the reactors not specifying
+// a share group are subscribed in the group, the same as the mqtt connector
+func (c *Connector) PublishShared(ctx context.Context, comp mqhub.Component, group string) (mqhub.Publication, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.publish(comp, group)
+}
+
+func (c *Connector) publish(comp mqhub.Component, group string) (mqhub.Publication, error) {
+	pub := newPublication(c, comp, group)
+	c.lock.Lock()
+	c.exports = append(c.exports, pub)
+	c.lock.Unlock()
+	if err := pub.export(); err != nil {
+		pub.unexport()
+		c.removePub(pub)
+		return nil, err
+	}
+	return pub, nil
+}
+
+// PublishContext implements Connector, publishing never blocks
+func (c *Connector) PublishContext(ctx context.Context, comp mqhub.Component) (mqhub.Publication, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Publish(comp)
+}
+
+// Describe creates a descriptor
+func (c *Connector) Describe(componentID string) mqhub.Descriptor {
+	return &Descriptor{
+		ComponentID: componentID,
+		SubTopic:    componentID,
+		conn:        c,
+	}
+}
+
+// Discover implements Connector, the advertisements are retained
+// in the hub, so they are all available immediately
+func (c *Connector) Discover(ctx context.Context) ([]mqhub.Descriptor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	filter := mqtt.NewTopicFilter(c.topicPrefix + mqtt.EndpointTopic("+", mqhub.AdvertisementEndpoint))
+	var descs []mqhub.Descriptor
+	for _, pkt := range c.Hub.retainedMatches(filter) {
+		var adv mqhub.Advertisement
+		if err := newMessage(c.topicPrefix, pkt, true).As(&adv); err != nil {
+			continue
+		}
+		id, _ := mqtt.ParseTopic(pkt.topic, c.topicPrefix)
+		descs = append(descs, &Descriptor{ComponentID: id, SubTopic: id, conn: c, adv: &adv})
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].ID() < descs[j].ID()
+	})
+	return descs, nil
+}
+
+func (c *Connector) isConnected() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.connected
+}
+
+func (c *Connector) sub(topics []string, handler *HandlerRef) mqhub.Future {
+	if !c.isConnected() {
+		return &mqhub.ImmediateFuture{Error: ErrNotConnected}
+	}
+	c.handlers.Add(topics, handler)
+	// retained messages are delivered to the new handler only,
+	// the existing handlers have already received them
+	for _, topic := range topics {
+		// retained messages are not sent to shared subscriptions
+		if group, _ := mqtt.SplitSharedFilter(topic); group != "" {
+			continue
+		}
+		for _, pkt := range c.Hub.retainedMatches(mqtt.NewTopicFilter(c.topicPrefix + topic)) {
+			c.deliver(pkt, true, handler)
+		}
+	}
+	return &mqhub.ImmediateFuture{}
+}
+
+func (c *Connector) unsub(topics []string, handler *HandlerRef) mqhub.Future {
+	c.handlers.Del(topics, handler)
+	return &mqhub.ImmediateFuture{}
+}
+
+func (c *Connector) pub(topic string, codec mqhub.Codec, msg mqhub.Message) mqhub.Future {
+	if !c.isConnected() {
+		return &mqhub.ImmediateFuture{Error: ErrNotConnected}
+	}
+	return &mqhub.ImmediateFuture{Error: c.Hub.publish(c.newPacket(topic, codec, msg))}
+}
+
+// reportsErrors tells whether the failures of reactors are reported
+func (c *Connector) reportsErrors() bool {
+	return c.errorHandler != nil || c.errorEndpoint
+}
+
+// reportError notifies the error handler, and publishes the failure to
+// the ErrorEndpoint of the component owning the endpoint if enabled
+func (c *Connector) reportError(topic string, err error) {
+	if c.errorHandler != nil {
+		c.errorHandler(topic, err)
+	}
+	if c.errorEndpoint {
+		compID, _ := mqtt.ParseTopicRel(topic)
+		c.pub(mqtt.EndpointTopic(compID, mqhub.ErrorEndpoint), mqhub.JSON, mqhub.MsgFrom(mqhub.ErrorReportOf(topic, err)))
+	}
+}
+
+// clearRetained removes the retained message on the topic
+func (c *Connector) clearRetained(topic string) mqhub.Future {
+	return c.pub(topic, mqhub.Raw, mqhub.StateFrom([]byte{}))
+}
+
+// newPacket creates a packet encoded by the codec selected the same
+// way as the mqtt connector
+func (c *Connector) newPacket(topic string, codec mqhub.Codec, msg mqhub.Message) *packet {
+	pkt := &packet{topic: c.topicPrefix + topic, retain: msg.IsState(), origin: msg}
+	switch {
+	case mqhub.MessageCodec(msg) != nil:
+		pkt.codec = mqhub.MessageCodec(msg)
+	case codec != nil:
+		pkt.codec = codec
+	case c.codec != nil:
+		pkt.codec = c.codec
+	default:
+		pkt.codec = mqhub.JSON
+	}
+	return pkt
+}
+
+func (c *Connector) removePub(pub *Publication) {
+	c.lock.Lock()
+	for i, x := range c.exports {
+		if x == pub {
+			c.exports = append(c.exports[:i], c.exports[i+1:]...)
+			break
+		}
+	}
+	c.lock.Unlock()
+}
+
+// deliver queues the packet for dispatching, if target is nil, the packet is
+// dispatched to all handlers with matching filters
+func (c *Connector) deliver(pkt *packet, retained bool, target *HandlerRef) {
+	if !strings.HasPrefix(pkt.topic, c.topicPrefix) {
+		return
+	}
+	if target == nil && !c.handlers.Matches(pkt.topic[len(c.topicPrefix):]) {
+		return
+	}
+	c.lock.Lock()
+	if c.connected {
+		c.queue = append(c.queue, &delivery{pkt: pkt, retained: retained, target: target})
+		c.queueCond.Signal()
+	}
+	c.lock.Unlock()
+}
+
+// dispatch runs in a separate goroutine delivering queued messages in order,
+// so publishers are never blocked by slow handlers, same as the mqtt connector
+func (c *Connector) dispatch() {
+	for {
+		c.lock.Lock()
+		for c.connected && len(c.queue) == 0 {
+			c.queueCond.Wait()
+		}
+		if !c.connected {
+			c.lock.Unlock()
+			return
+		}
+		d := c.queue[0]
+		c.queue = c.queue[1:]
+		c.lock.Unlock()
+
+		msg := newMessage(c.topicPrefix, d.pkt, d.retained)
+		if d.target != nil {
+			d.target.Handler(msg)
+		} else {
+			c.handlers.HandleMessage(d.pkt.topic[len(c.topicPrefix):], msg)
+		}
+	}
+}
+
+// ConnectorFactory implements mqhub.ConnectorFactory
+// the host part of URL is the name of the hub, and path is the namespace
+func ConnectorFactory(URL url.URL) (mqhub.Connector, error) {
+	opts := NewOptions()
+	opts.Hub = URL.Host
+	opts.Namespace = strings.Trim(URL.Path, "/")
+	if presence := URL.Query().Get(mqtt.OptPresence); presence != "" {
+		enabled, err := strconv.ParseBool(presence)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", mqtt.OptPresence, err)
+		}
+		opts.Presence = enabled
+	}
+	if clear := URL.Query().Get(mqtt.OptClearRetained); clear != "" {
+		enabled, err := strconv.ParseBool(clear)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", mqtt.OptClearRetained, err)
+		}
+		opts.ClearRetained = enabled
+	}
+	if report := URL.Query().Get(mqtt.OptErrorEndpoint); report != "" {
+		enabled, err := strconv.ParseBool(report)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", mqtt.OptErrorEndpoint, err)
+		}
+		opts.ErrorEndpoint = enabled
+	}
+	if name := URL.Query().Get(mqtt.OptCodec); name != "" {
+		if opts.Codec = mqhub.LookupCodec(name); opts.Codec == nil {
+			return nil, fmt.Errorf("unknown codec %s", name)
+		}
+	}
+	return NewConnector(opts), nil
+}
+
+const (
+	// Protocol is the name of protocol for connector
+	Protocol = "local"
+)
+
+func init() {
+	mqhub.RegisterConnectorFactory(Protocol, ConnectorFactory)
+}
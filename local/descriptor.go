@@ -0,0 +1,123 @@
+package local
+
+import (
+	"context"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+)
+
+// Descriptor implements mqhub.Descriptor
+type Descriptor struct {
+	ComponentID string
+	SubTopic    string
+
+	conn *Connector
+	// root is the topic of the published component
+	root string
+	// adv is present if discovered
+	adv *mqhub.Advertisement
+}
+
+// SubComponent implements Descriptor
+func (d *Descriptor) SubComponent(id ...string) mqhub.Descriptor {
+	if len(id) == 0 {
+		return d
+	}
+	sub := &Descriptor{
+		ComponentID: id[len(id)-1],
+		SubTopic:    mqtt.SubCompTopic(d.SubTopic, id...),
+		conn:        d.conn,
+		root:        d.rootTopic(),
+	}
+	if d.adv != nil {
+		sub.adv = d.adv.Find(id...)
+	}
+	return sub
+}
+
+// Endpoints implements Descriptor
+func (d *Descriptor) Endpoints() []mqhub.EndpointInfo {
+	if d.adv == nil {
+		return nil
+	}
+	return d.adv.Endpoints
+}
+
+// SubComponents implements Descriptor
+func (d *Descriptor) SubComponents() []mqhub.Descriptor {
+	if d.adv == nil {
+		return nil
+	}
+	subs := make([]mqhub.Descriptor, 0, len(d.adv.Components))
+	for _, comp := range d.adv.Components {
+		subs = append(subs, d.SubComponent(comp.ComponentID))
+	}
+	return subs
+}
+
+func (d *Descriptor) rootTopic() string {
+	if d.root != "" {
+		return d.root
+	}
+	return d.SubTopic
+}
+
+// Watch implements Descriptor
+func (d *Descriptor) Watch(sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	return watchTopic(d.conn, d, mqtt.SubCompTopic(d.SubTopic, "#"), sink)
+}
+
+// ID implements Descriptor
+func (d *Descriptor) ID() string {
+	return d.ComponentID
+}
+
+// Endpoint implements Descriptor
+func (d *Descriptor) Endpoint(name string) mqhub.EndpointRef {
+	return &EndpointRef{
+		conn:  d.conn,
+		topic: mqtt.EndpointTopic(d.SubTopic, name),
+	}
+}
+
+// Presence implements Descriptor, the presence of a sub-component
+// is the presence of the published component
+func (d *Descriptor) Presence() mqhub.Watchable {
+	return &presenceRef{conn: d.conn, topic: d.rootTopic()}
+}
+
+// EndpointRef implements mqhub.EndpointRef
+type EndpointRef struct {
+	conn  *Connector
+	topic string
+	codec mqhub.Codec
+}
+
+// Watch implements EndpointRef
+func (r *EndpointRef) Watch(sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	return watchTopic(r.conn, r, r.topic, sink)
+}
+
+// ConsumeMessage implements MessageSink
+func (r *EndpointRef) ConsumeMessage(msg mqhub.Message) mqhub.Future {
+	return r.conn.pub(r.topic, r.codec, msg)
+}
+
+// Call implements EndpointRef
+func (r *EndpointRef) Call(ctx context.Context, req interface{}, resp interface{}) error {
+	return r.conn.call(ctx, r.topic, r.codec, req, resp)
+}
+
+// WithQoS implements EndpointRef
+// messages routed in memory are always delivered exactly once
+func (r *EndpointRef) WithQoS(mqhub.QoS) mqhub.EndpointRef {
+	return r
+}
+
+// WithCodec implements EndpointRef
+func (r *EndpointRef) WithCodec(codec mqhub.Codec) mqhub.EndpointRef {
+	ref := *r
+	ref.codec = codec
+	return &ref
+}
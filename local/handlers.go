@@ -0,0 +1,156 @@
+package local
+
+import (
+	"sync"
+
+	"github.com/robotalks/mqhub.go/mqtt"
+)
+
+// MessageHandler handles a message delivered by the hub
+type MessageHandler func(*Message)
+
+// HandlerRef wraps over a handler
+type HandlerRef struct {
+	Handler MessageHandler
+}
+
+// MakeHandlerRef builds a HandlerRef
+func MakeHandlerRef(handler MessageHandler) *HandlerRef {
+	return &HandlerRef{Handler: handler}
+}
+
+// TopicHandlerMap maps topic filter to handlers
+type TopicHandlerMap struct {
+	prefix string
+	topics map[string]*handlerList
+	// trie indexes the handlers by the filters including the prefix
+	trie *mqtt.TopicTrie[*handlerList]
+	// shared indexes the handlers of shared subscriptions by the actual
+	// filters including the prefix, the Hub picks one of them
+	shared *mqtt.TopicTrie[[]*handlerList]
+	lock   sync.RWMutex
+}
+
+type handlerList struct {
+	// filter is the shared subscription including the prefix
+	filter   string
+	handlers []*HandlerRef
+}
+
+// NewTopicHandlerMap creates a new TopicHandlerMap
+func NewTopicHandlerMap() *TopicHandlerMap {
+	return &TopicHandlerMap{
+		topics: make(map[string]*handlerList),
+		trie:   mqtt.NewTopicTrie[*handlerList](),
+		shared: mqtt.NewTopicTrie[[]*handlerList](),
+	}
+}
+
+// Add inserts filters and corresponding handler
+func (m *TopicHandlerMap) Add(filters []string, handler *HandlerRef) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, filter := range filters {
+		handlers := m.topics[filter]
+		if handlers == nil {
+			handlers = &handlerList{}
+			m.topics[filter] = handlers
+			m.index(filter, handlers)
+		}
+		handlers.handlers = append(handlers.handlers, handler)
+	}
+}
+
+// Del removes filters/handler
+func (m *TopicHandlerMap) Del(filters []string, handler *HandlerRef) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, filter := range filters {
+		handlers := m.topics[filter]
+		if handlers != nil {
+			for i, h := range handlers.handlers {
+				if h == handler {
+					handlers.handlers = append(handlers.handlers[:i], handlers.handlers[i+1:]...)
+					if len(handlers.handlers) == 0 {
+						delete(m.topics, filter)
+						m.unindex(filter, handlers)
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+func (m *TopicHandlerMap) index(filter string, handlers *handlerList) {
+	group, topicFilter := mqtt.SplitSharedFilter(filter)
+	if group == "" {
+		m.trie.Put(m.prefix+filter, handlers)
+		return
+	}
+	handlers.filter = mqtt.SharedFilter(group, m.prefix+topicFilter)
+	lists, _ := m.shared.Get(m.prefix + topicFilter)
+	m.shared.Put(m.prefix+topicFilter, append(lists, handlers))
+}
+
+func (m *TopicHandlerMap) unindex(filter string, handlers *handlerList) {
+	group, topicFilter := mqtt.SplitSharedFilter(filter)
+	if group == "" {
+		m.trie.Delete(m.prefix + filter)
+		return
+	}
+	lists, _ := m.shared.Get(m.prefix + topicFilter)
+	for i, list := range lists {
+		if list == handlers {
+			lists = append(lists[:i:i], lists[i+1:]...)
+			break
+		}
+	}
+	if len(lists) == 0 {
+		m.shared.Delete(m.prefix + topicFilter)
+	} else {
+		m.shared.Put(m.prefix+topicFilter, lists)
+	}
+}
+
+// sharedMatches returns the handlers of shared subscriptions matching
+// the full topic by the shared subscriptions including the prefix
+func (m *TopicHandlerMap) sharedMatches(topic string) map[string][]*HandlerRef {
+	var matches map[string][]*HandlerRef
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	m.shared.Match(topic, func(lists []*handlerList) {
+		for _, list := range lists {
+			if matches == nil {
+				matches = make(map[string][]*HandlerRef)
+			}
+			matches[list.filter] = append(matches[list.filter], list.handlers...)
+		}
+	})
+	return matches
+}
+
+// Matches indicates any filter matches the topic
+func (m *TopicHandlerMap) Matches(topic string) bool {
+	matched := false
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	m.trie.Match(m.prefix+topic, func(*handlerList) {
+		matched = true
+	})
+	return matched
+}
+
+// HandleMessage dispatches the message to all handlers with matching filters,
+// except shared subscriptions which are dispatched by the Hub
+func (m *TopicHandlerMap) HandleMessage(topic string, msg *Message) {
+	var handlers []*HandlerRef
+	m.lock.RLock()
+	m.trie.Match(m.prefix+topic, func(list *handlerList) {
+		handlers = append(handlers, list.handlers...)
+	})
+	m.lock.RUnlock()
+	for _, handler := range handlers {
+		handler.Handler(msg)
+	}
+}
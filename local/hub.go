@@ -0,0 +1,163 @@
+package local
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+)
+
+// Hub is the in-memory message bus shared by local connectors
+// it plays the role of a broker: keeps retained messages and
+// forwards published messages to connectors with matching subscriptions,
+// a message matching shared subscriptions is forwarded to one of the
+// subscribers in each group
+type Hub struct {
+	name     string
+	retained map[string]*packet
+	// conns are the attached connectors by the order of attaching
+	conns    map[*Connector]uint64
+	attached uint64
+	lock     sync.RWMutex
+
+	// shares counts the messages delivered to shared subscriptions
+	// by the filters, to pick the subscribers in turn
+	shares map[string]uint64
+}
+
+var (
+	_hubs     = make(map[string]*Hub)
+	_hubsLock sync.Mutex
+)
+
+// HubFor returns the hub with specified name, the hub is created if not exist
+func HubFor(name string) *Hub {
+	_hubsLock.Lock()
+	defer _hubsLock.Unlock()
+	hub := _hubs[name]
+	if hub == nil {
+		hub = &Hub{
+			name:     name,
+			retained: make(map[string]*packet),
+			conns:    make(map[*Connector]uint64),
+			shares:   make(map[string]uint64),
+		}
+		_hubs[name] = hub
+	}
+	return hub
+}
+
+// Name returns the name of the hub
+func (h *Hub) Name() string {
+	return h.name
+}
+
+func (h *Hub) attach(conn *Connector) {
+	h.lock.Lock()
+	h.attached++
+	h.conns[conn] = h.attached
+	h.lock.Unlock()
+}
+
+func (h *Hub) detach(conn *Connector) {
+	h.lock.Lock()
+	delete(h.conns, conn)
+	h.lock.Unlock()
+}
+
+func (h *Hub) publish(pkt *packet) error {
+	h.lock.Lock()
+	if pkt.retain {
+		payload, err := pkt.payload()
+		if err != nil {
+			h.lock.Unlock()
+			return err
+		}
+		// zero-length retained message clears the retained state
+		if len(payload) == 0 {
+			delete(h.retained, pkt.topic)
+		} else {
+			h.retained[pkt.topic] = pkt
+		}
+	}
+	conns := make([]*Connector, 0, len(h.conns))
+	for conn := range h.conns {
+		conns = append(conns, conn)
+	}
+	// shared subscriptions are picked in turn by the same order
+	sort.Slice(conns, func(i, j int) bool {
+		return h.conns[conns[i]] < h.conns[conns[j]]
+	})
+	h.lock.Unlock()
+
+	shared := make(map[string][]sharedTarget)
+	for _, conn := range conns {
+		conn.deliver(pkt, false, nil)
+		for filter, handlers := range conn.handlers.sharedMatches(pkt.topic) {
+			for _, handler := range handlers {
+				shared[filter] = append(shared[filter], sharedTarget{conn: conn, handler: handler})
+			}
+		}
+	}
+	for filter, targets := range shared {
+		target := h.pickShared(filter, targets)
+		target.conn.deliver(pkt, false, target.handler)
+	}
+	return nil
+}
+
+// sharedTarget is a subscriber of a shared subscription
+type sharedTarget struct {
+	conn    *Connector
+	handler *HandlerRef
+}
+
+// pickShared selects one of the subscribers sharing the subscription in turn
+func (h *Hub) pickShared(filter string, targets []sharedTarget) sharedTarget {
+	h.lock.Lock()
+	n := h.shares[filter]
+	h.shares[filter] = n + 1
+	h.lock.Unlock()
+	return targets[n%uint64(len(targets))]
+}
+
+func (h *Hub) hasRetained(topic string) bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.retained[topic] != nil
+}
+
+func (h *Hub) retainedMatches(filter *mqtt.TopicFilter) (pkts []*packet) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for topic, pkt := range h.retained {
+		if filter.Matches(topic) {
+			pkts = append(pkts, pkt)
+		}
+	}
+	return
+}
+
+// packet is a published message travelling through the hub
+type packet struct {
+	topic  string
+	retain bool
+	origin mqhub.Message
+	codec  mqhub.Codec
+	// reply is present if the packet is a request of Call
+	reply chan *mqhub.Reply
+
+	encodeOnce sync.Once
+	encoded    []byte
+	encodeErr  error
+}
+
+// payload encodes the message the same way as the mqtt connector
+// it's only done once and shared by all receivers
+func (p *packet) payload() ([]byte, error) {
+	p.encodeOnce.Do(func() {
+		p.encoded, p.encodeErr = mqtt.EncodeWith(p.origin, p.codec)
+	})
+	return p.encoded, p.encodeErr
+}
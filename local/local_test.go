@@ -0,0 +1,894 @@
+package local_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/codec/msgpack"
+	"github.com/robotalks/mqhub.go/local"
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type Comp0 struct {
+	mqhub.ComponentBase
+	state0 mqhub.DataPoint
+	state1 mqhub.DataPoint
+	actor0 mqhub.Reactor
+}
+
+func NewComp0(id string) *Comp0 {
+	c := &Comp0{
+		state0: mqhub.DataPoint{Name: "state0", Retain: true},
+		state1: mqhub.DataPoint{Name: "state1"},
+		actor0: mqhub.Reactor{Name: "a"},
+	}
+	c.SetID(id)
+	c.actor0.Handler = mqhub.MessageSinkAs(c.actor)
+	return c
+}
+
+func (c *Comp0) Endpoints() []mqhub.Endpoint {
+	return []mqhub.Endpoint{&c.state0, &c.state1, &c.actor0}
+}
+
+func (c *Comp0) actor(state int) {
+	c.state0.Update(state)
+}
+
+type Pub0 struct {
+	mqhub.CompositeBase
+	Comp0 *Comp0
+}
+
+func NewPub0() *Pub0 {
+	p := &Pub0{Comp0: NewComp0("comp0")}
+	p.AddComponent(p.Comp0)
+	return p
+}
+
+func (p *Pub0) ID() string {
+	return "pub0"
+}
+
+func (p *Pub0) Endpoints() []mqhub.Endpoint {
+	return nil
+}
+
+type pubState struct {
+	state     int
+	component string
+	endpoint  string
+	retained  bool
+}
+
+func makeSinkFunc(t *testing.T, ch chan pubState) mqhub.MessageSink {
+	return mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var state pubState
+		assert.NoError(t, msg.As(&state.state))
+		state.component = msg.Component()
+		state.endpoint = msg.Endpoint()
+		state.retained = msg.IsState()
+		ch <- state
+		return &mqhub.ImmediateFuture{}
+	})
+}
+
+func recvState(t *testing.T, stateCh chan pubState) (state pubState) {
+	select {
+	case <-time.After(time.Second):
+		t.Error("timeout")
+	case state = <-stateCh:
+	}
+	return
+}
+
+func newConnectors(t *testing.T) (host, client mqhub.Connector) {
+	a := assert.New(t)
+	hub := "hub-" + utils.UniqueID()
+	host, err := mqhub.NewConnector("local://" + hub + "/ns")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		t.FailNow()
+	}
+	client, err = mqhub.NewConnector("local://" + hub + "/ns")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		t.FailNow()
+	}
+	return
+}
+
+func TestPublication(t *testing.T) {
+	a := assert.New(t)
+	host, client := newConnectors(t)
+	defer host.Close()
+	defer client.Close()
+
+	pub0 := NewPub0()
+	_, err := host.Publish(pub0)
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(pub0.Comp0.state0.Update(1).Wait())
+	a.NoError(pub0.Comp0.state0.Update(2).Wait())
+	a.NoError(pub0.Comp0.state1.Update(20).Wait())
+
+	stateCh := make(chan pubState, 4)
+	sinkFunc := makeSinkFunc(t, stateCh)
+
+	desc := client.Describe("pub0").SubComponent("comp0")
+	// every watcher receives the retained state
+	for i := 0; i < 2; i++ {
+		_, err = desc.Endpoint("state0").Watch(sinkFunc)
+		a.NoError(err)
+		state := recvState(t, stateCh)
+		a.Equal(2, state.state)
+		a.Equal("pub0/comp0", state.component)
+		a.Equal("state0", state.endpoint)
+		a.True(state.retained)
+	}
+
+	a.NoError(desc.Endpoint("a").ConsumeMessage(mqhub.MsgFrom(100)).Wait())
+	for i := 0; i < 2; i++ {
+		state := recvState(t, stateCh)
+		a.Equal(100, state.state)
+		a.Equal("pub0/comp0", state.component)
+		a.Equal("state0", state.endpoint)
+		a.False(state.retained)
+	}
+
+	// state1 is not retained
+	_, err = desc.Endpoint("state1").Watch(sinkFunc)
+	a.NoError(err)
+	a.NoError(pub0.Comp0.state1.Update(30).Wait())
+	state := recvState(t, stateCh)
+	a.Equal(30, state.state)
+	a.Equal("state1", state.endpoint)
+}
+
+func TestWildcardWatch(t *testing.T) {
+	a := assert.New(t)
+	host, client := newConnectors(t)
+	defer host.Close()
+	defer client.Close()
+
+	pub0 := NewPub0()
+	_, err := host.Publish(pub0)
+	if !a.NoError(err) {
+		return
+	}
+
+	stateCh := make(chan pubState, 4)
+	watcher, err := client.Watch(makeSinkFunc(t, stateCh))
+	if !a.NoError(err) {
+		return
+	}
+
+	a.NoError(client.Describe("pub0").SubComponent("comp0").Endpoint("a").ConsumeMessage(mqhub.MsgFrom(101)).Wait())
+	stateMap := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		state := recvState(t, stateCh)
+		a.Equal("pub0/comp0", state.component)
+		stateMap[state.endpoint] = state.state
+	}
+	a.Equal(101, stateMap["state0"])
+	a.Equal(101, stateMap["a"])
+
+	a.NoError(watcher.Close())
+	a.NoError(pub0.Comp0.state1.Update(201).Wait())
+	select {
+	case state := <-stateCh:
+		t.Errorf("unexpected message after watcher closed: %v", state)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	a := assert.New(t)
+	hub := "hub-" + utils.UniqueID()
+	host, err := mqhub.NewConnector("local://" + hub + "/ns1")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	client, err := mqhub.NewConnector("local://" + hub + "/ns2")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+
+	pub0 := NewPub0()
+	_, err = host.Publish(pub0)
+	a.NoError(err)
+	a.NoError(pub0.Comp0.state0.Update(1).Wait())
+
+	stateCh := make(chan pubState, 1)
+	_, err = client.Watch(makeSinkFunc(t, stateCh))
+	a.NoError(err)
+	select {
+	case state := <-stateCh:
+		t.Errorf("unexpected message from other namespace: %v", state)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	a := assert.New(t)
+	conn, err := mqhub.NewConnector("local://hub-" + utils.UniqueID())
+	if !a.NoError(err) {
+		return
+	}
+	states := make(chan mqhub.ConnectionState, 8)
+	watcher, err := conn.Lifecycle().Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var state mqhub.ConnectionState
+		a.NoError(msg.As(&state))
+		states <- state
+		return nil
+	}))
+	a.NoError(err)
+	a.NoError(conn.Connect().Wait())
+	a.NoError(conn.Close())
+	for _, expected := range []mqhub.ConnectionState{mqhub.Disconnected, mqhub.Connected, mqhub.Closed} {
+		select {
+		case state := <-states:
+			a.Equal(expected, state)
+		case <-time.After(3 * time.Second):
+			a.Fail("event not delivered")
+			return
+		}
+	}
+	watcher.Close()
+	a.NoError(conn.Connect().Wait())
+	conn.Close()
+	select {
+	case state := <-states:
+		a.Fail("event delivered after watcher closed", "%v", state)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPresence(t *testing.T) {
+	a := assert.New(t)
+	hubURL := "local://hub-" + utils.UniqueID() + "?presence=true"
+	host, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+
+	onlineCh := make(chan bool, 2)
+	_, err = client.Describe("pub0").Presence().Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var online bool
+		a.NoError(msg.As(&online))
+		onlineCh <- online
+		return nil
+	}))
+	a.NoError(err)
+	_, err = host.Publish(NewPub0())
+	a.NoError(err)
+	a.True(recvOnline(t, onlineCh))
+	host.Close()
+	a.False(recvOnline(t, onlineCh))
+}
+
+func recvOnline(t *testing.T, ch chan bool) (online bool) {
+	select {
+	case <-time.After(time.Second):
+		t.Error("timeout")
+	case online = <-ch:
+	}
+	return
+}
+
+func TestCall(t *testing.T) {
+	a := assert.New(t)
+	hubURL := "local://hub-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := &mqhub.ComponentBase{}
+	comp.SetID("calc")
+	_, err = host.Publish(&calcComp{
+		ComponentBase: comp,
+		square: mqhub.ReactorAs("square", func(n int) (int, error) {
+			if n < 0 {
+				return 0, fmt.Errorf("negative")
+			}
+			return n * n, nil
+		}),
+	})
+	a.NoError(err)
+
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var result int
+	ref := client.Describe("calc").Endpoint("square")
+	if a.NoError(ref.Call(ctx, 3, &result)) {
+		a.Equal(9, result)
+	}
+	a.Equal(&mqhub.RemoteError{Message: "negative"}, ref.Call(ctx, -1, &result))
+}
+
+type calcComp struct {
+	*mqhub.ComponentBase
+	square *mqhub.Reactor
+}
+
+func (c *calcComp) Endpoints() []mqhub.Endpoint {
+	return []mqhub.Endpoint{c.square}
+}
+
+func TestCodec(t *testing.T) {
+	a := assert.New(t)
+	hubURL := "local://hub-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(hubURL + "?codec=msgpack")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := NewComp0("comp0")
+	_, err = host.Publish(comp)
+	a.NoError(err)
+
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	msgCh := make(chan mqhub.Message, 2)
+	_, err = client.Describe("comp0").Endpoint("state0").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		msgCh <- msg
+		return nil
+	}))
+	a.NoError(err)
+
+	a.NoError(comp.state0.Update(100).Wait())
+	a.NoError(comp.state0.Update(mqhub.StateFrom(200).WithCodec(mqhub.JSON)).Wait())
+	for _, expected := range []int{100, 200} {
+		msg := <-msgCh
+		var val int
+		if a.NoError(msg.As(&val)) {
+			a.Equal(expected, val)
+		}
+		payload, err := msg.(mqhub.EncodedPayload).Payload()
+		a.NoError(err)
+		props := msg.(mqhub.PropertiesCarrier).Properties()
+		if expected == 100 {
+			a.Equal([]byte{0x64}, payload)
+			a.Equal(msgpack.ContentType, props.ContentType)
+		} else {
+			a.Equal("200", string(payload))
+			a.Equal(mqhub.JSON.ContentType(), props.ContentType)
+		}
+	}
+
+	_, err = mqhub.NewConnector(hubURL + "?codec=unknown")
+	a.Error(err)
+}
+
+type typedComp struct {
+	*mqhub.ComponentBase
+	pos   *mqhub.TypedDataPoint[position]
+	move  *mqhub.TypedReactor[position]
+	scale *mqhub.TypedReactor[int]
+}
+
+type position struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func (c *typedComp) Endpoints() []mqhub.Endpoint {
+	return []mqhub.Endpoint{c.pos, c.move, c.scale}
+}
+
+func TestTyped(t *testing.T) {
+	a := assert.New(t)
+	hubURL := "local://hub-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := &typedComp{
+		ComponentBase: &mqhub.ComponentBase{},
+		pos:           mqhub.NewRetainTypedDataPoint[position]("pos"),
+	}
+	comp.SetID("typed")
+	comp.move = mqhub.NewTypedReactor("move", func(p position) error {
+		return comp.pos.Update(p).Wait()
+	})
+	comp.scale = mqhub.NewTypedCallReactor("scale", func(n int) (position, error) {
+		p, _ := comp.pos.Value()
+		return position{X: p.X * n, Y: p.Y * n}, nil
+	})
+	_, err = host.Publish(comp)
+	a.NoError(err)
+
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("typed")
+	posCh := make(chan position, 1)
+	pos := mqhub.TypedRef[position](desc.Endpoint("pos"))
+	_, err = pos.WatchValue(func(p position) {
+		posCh <- p
+	})
+	a.NoError(err)
+	a.NoError(mqhub.TypedRef[position](desc.Endpoint("move")).Update(position{X: 1, Y: 2}).Wait())
+	select {
+	case p := <-posCh:
+		a.Equal(position{X: 1, Y: 2}, p)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	if p, ok := pos.Value(); a.True(ok) {
+		a.Equal(position{X: 1, Y: 2}, p)
+	}
+	if p, ok := comp.move.Value(); a.True(ok) {
+		a.Equal(position{X: 1, Y: 2}, p)
+	}
+
+	var scaled position
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if a.NoError(desc.Endpoint("scale").Call(ctx, 3, &scaled)) {
+		a.Equal(position{X: 3, Y: 6}, scaled)
+	}
+	a.Error(desc.Endpoint("scale").Call(ctx, "x", &scaled))
+}
+
+type robot struct {
+	mqhub.ComponentBase
+	Pos   *mqhub.TypedDataPoint[position] `mqhub:"pos,retain"`
+	Speed mqhub.DataPoint                 `mqhub:"speed,qos=1"`
+	Move  mqhub.Reactor                   `mqhub:"move,handler=DoMove"`
+	Turn  *mqhub.TypedReactor[int]        `mqhub:"turn,handler=DoTurn"`
+	Stop  func() error                    `mqhub:"stop"`
+	Arm   struct {
+		Angle *mqhub.DataPoint `mqhub:"angle,retain"`
+	} `mqhub:"arm"`
+}
+
+func (r *robot) DoMove(p position) error {
+	return r.Pos.Update(p).Wait()
+}
+
+func (r *robot) DoTurn(angle int) error {
+	return r.Arm.Angle.Update(angle).Wait()
+}
+
+// robotTurnMismatch binds a typed reactor to a method of another type
+type robotTurnMismatch struct {
+	Turn *mqhub.TypedReactor[int] `mqhub:"turn,handler=DoTurn"`
+}
+
+func (r *robotTurnMismatch) DoTurn(angle string) {}
+
+func TestComponentOf(t *testing.T) {
+	a := assert.New(t)
+	r := &robot{Stop: func() error { return nil }}
+	r.SetID("robot")
+	comp, err := mqhub.ComponentOf(r)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal("robot", comp.ID())
+	a.Len(comp.Endpoints(), 5)
+	a.True(r.Pos.Retain)
+	a.Equal(mqhub.AtLeastOnce, r.Speed.QoS)
+	subs := comp.(mqhub.Composite).Components()
+	if a.Len(subs, 1) {
+		a.Equal("arm", subs[0].ID())
+		a.Len(subs[0].Endpoints(), 1)
+	}
+
+	hubURL := "local://hub-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	_, err = host.Publish(comp)
+	a.NoError(err)
+	a.NoError(r.Arm.Angle.Update(30).Wait())
+
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("robot")
+	a.NoError(desc.Endpoint("move").ConsumeMessage(mqhub.MsgFrom(position{X: 1})).Wait())
+	angleCh := make(chan int, 1)
+	_, err = desc.SubComponent("arm").Endpoint("angle").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var angle int
+		a.NoError(msg.As(&angle))
+		angleCh <- angle
+		return nil
+	}))
+	a.NoError(err)
+	select {
+	case angle := <-angleCh:
+		a.Equal(30, angle)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	a.Eventually(func() bool {
+		p, _ := r.Pos.Value()
+		return p.X == 1
+	}, time.Second, 10*time.Millisecond)
+	// the typed reactor is bound to the method
+	a.NoError(desc.Endpoint("turn").ConsumeMessage(mqhub.MsgFrom(45)).Wait())
+	select {
+	case angle := <-angleCh:
+		a.Equal(45, angle)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	if angle, ok := r.Turn.Value(); a.True(ok) {
+		a.Equal(45, angle)
+	}
+
+	_, err = mqhub.ComponentOf(&struct {
+		Move mqhub.Reactor `mqhub:"move"`
+	}{})
+	a.Error(err)
+	_, err = mqhub.ComponentOf(&struct {
+		Turn *mqhub.TypedReactor[int] `mqhub:"turn"`
+	}{})
+	a.Error(err)
+	_, err = mqhub.ComponentOf(&robotTurnMismatch{})
+	a.Error(err)
+	_, err = mqhub.ComponentOf(&struct {
+		Speed mqhub.DataPoint `mqhub:"speed,qos=bad"`
+	}{})
+	a.Error(err)
+	_, err = mqhub.ComponentOf(robot{})
+	a.Error(err)
+}
+
+func TestDiscover(t *testing.T) {
+	a := assert.New(t)
+	r := &robot{Stop: func() error { return nil }}
+	r.SetID("robot")
+	comp, err := mqhub.ComponentOf(r)
+	if !a.NoError(err) {
+		return
+	}
+	hubURL := "local://hub-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	pub, err := host.Publish(comp)
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	descs, err := client.Discover(context.Background())
+	if !a.NoError(err) || !a.Len(descs, 1) {
+		return
+	}
+	a.Equal("robot", descs[0].ID())
+	endpoints := make(map[string]mqhub.EndpointInfo)
+	for _, info := range descs[0].Endpoints() {
+		endpoints[info.Name] = info
+	}
+	a.Len(endpoints, 5)
+	a.Equal(mqhub.DataPointKind, endpoints["pos"].Kind)
+	a.True(endpoints["pos"].Retain)
+	a.Equal("local_test.position", endpoints["pos"].Type)
+	a.Equal(mqhub.ReactorKind, endpoints["move"].Kind)
+	a.Equal("local_test.position", endpoints["move"].Type)
+	a.Equal(mqhub.ReactorKind, endpoints["stop"].Kind)
+	subs := descs[0].SubComponents()
+	if a.Len(subs, 1) {
+		a.Equal("arm", subs[0].ID())
+		if a.Len(subs[0].Endpoints(), 1) {
+			a.Equal("angle", subs[0].Endpoints()[0].Name)
+		}
+	}
+
+	a.NoError(pub.Close())
+	descs, err = client.Discover(context.Background())
+	a.NoError(err)
+	a.Empty(descs)
+}
+
+func TestDynamicComposition(t *testing.T) {
+	a := assert.New(t)
+	hubURL := "local://hub-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := &mqhub.DynamicBase{}
+	comp.SetID("dynamic")
+	_, err = host.Publish(comp)
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("dynamic")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	a.NoError(comp.AddEndpoint(mqhub.ReactorAs("double", func(n int) (int, error) { return n * 2, nil })).Wait())
+	var result int
+	if a.NoError(desc.Endpoint("double").Call(ctx, 3, &result)) {
+		a.Equal(6, result)
+	}
+
+	sub := &mqhub.DynamicBase{}
+	sub.SetID("sub")
+	a.NoError(comp.AttachComponent(sub).Wait())
+	a.NoError(sub.AddEndpoint(mqhub.ReactorAs("square", func(n int) (int, error) { return n * n, nil })).Wait())
+	if a.NoError(desc.SubComponent("sub").Endpoint("square").Call(ctx, 3, &result)) {
+		a.Equal(9, result)
+	}
+	descs, err := client.Discover(ctx)
+	if a.NoError(err) && a.Len(descs, 1) {
+		a.Len(descs[0].Endpoints(), 1)
+		if subs := descs[0].SubComponents(); a.Len(subs, 1) {
+			a.Len(subs[0].Endpoints(), 1)
+		}
+	}
+
+	a.NoError(comp.RemoveComponent("sub").Wait())
+	a.NoError(comp.RemoveEndpoint("double").Wait())
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	a.Error(desc.Endpoint("double").Call(shortCtx, 3, &result))
+	descs, err = client.Discover(ctx)
+	if a.NoError(err) && a.Len(descs, 1) {
+		a.Empty(descs[0].Endpoints())
+		a.Empty(descs[0].SubComponents())
+	}
+}
+
+// lateDynamic adds the endpoint right after its endpoints are enumerated
+// for the first time, as if added concurrently while being published
+type lateDynamic struct {
+	mqhub.DynamicBase
+	late  mqhub.Endpoint
+	added int32
+}
+
+func (c *lateDynamic) Endpoints() []mqhub.Endpoint {
+	endpoints := c.DynamicBase.Endpoints()
+	if atomic.CompareAndSwapInt32(&c.added, 0, 1) {
+		c.AddEndpoint(c.late)
+	}
+	return endpoints
+}
+
+func TestDynamicCompositionWhilePublishing(t *testing.T) {
+	a := assert.New(t)
+	conn, err := mqhub.NewConnector("local://hub-" + utils.UniqueID())
+	if !a.NoError(err) || !a.NoError(conn.Connect().Wait()) {
+		return
+	}
+	defer conn.Close()
+	comp := &lateDynamic{late: mqhub.ReactorAs("double", func(n int) (int, error) { return n * 2, nil })}
+	comp.SetID("dynamic")
+	if _, err = conn.Publish(comp); !a.NoError(err) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var result int
+	if a.NoError(conn.Describe("dynamic").Endpoint("double").Call(ctx, 3, &result)) {
+		a.Equal(6, result)
+	}
+}
+
+func TestDynamicCompositionPublishedTwice(t *testing.T) {
+	a := assert.New(t)
+	comp := &mqhub.DynamicBase{}
+	comp.SetID("dynamic")
+	var conns [2]mqhub.Connector
+	var pubs [2]mqhub.Publication
+	for i := range conns {
+		conn, err := mqhub.NewConnector("local://hub-" + utils.UniqueID())
+		if !a.NoError(err) || !a.NoError(conn.Connect().Wait()) {
+			return
+		}
+		defer conn.Close()
+		if pubs[i], err = conn.Publish(comp); !a.NoError(err) {
+			return
+		}
+		conns[i] = conn
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the changes are applied to both publications
+	a.NoError(comp.AddEndpoint(mqhub.ReactorAs("double", func(n int) (int, error) { return n * 2, nil })).Wait())
+	var result int
+	for _, conn := range conns {
+		if a.NoError(conn.Describe("dynamic").Endpoint("double").Call(ctx, 3, &result)) {
+			a.Equal(6, result)
+		}
+	}
+
+	// closing one publication doesn't stop the other observing
+	a.NoError(pubs[0].Close())
+	a.NoError(comp.AddEndpoint(mqhub.ReactorAs("square", func(n int) (int, error) { return n * n, nil })).Wait())
+	if a.NoError(conns[1].Describe("dynamic").Endpoint("square").Call(ctx, 3, &result)) {
+		a.Equal(9, result)
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	a.Error(conns[0].Describe("dynamic").Endpoint("square").Call(shortCtx, 3, &result))
+}
+
+func TestUnpublish(t *testing.T) {
+	a := assert.New(t)
+	hubURL := "local://hub-" + utils.UniqueID() + "?clear-retained=true"
+	host, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	pub0 := NewPub0()
+	pub, err := host.Publish(pub0)
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(pub0.Comp0.state0.Update(mqhub.StateFrom(1)).Wait())
+	a.NoError(pub.Close())
+
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	msgCh := make(chan mqhub.Message, 1)
+	desc := client.Describe("pub0").SubComponent("comp0")
+	_, err = desc.Endpoint("state0").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		msgCh <- msg
+		return nil
+	}))
+	a.NoError(err)
+	// no retained state is left, and the reactor updating state0 is unsubscribed
+	a.NoError(desc.Endpoint("a").ConsumeMessage(mqhub.MsgFrom(2)).Wait())
+	select {
+	case <-msgCh:
+		t.Error("unexpected state0")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestShared(t *testing.T) {
+	a := assert.New(t)
+	hubURL := "local://hub-" + utils.UniqueID()
+	var handled [2]int32
+	for i := range handled {
+		i := i
+		host, err := mqhub.NewConnector(hubURL)
+		if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+			return
+		}
+		defer host.Close()
+		comp := &mqhub.DynamicBase{}
+		comp.SetID("replica")
+		comp.AddEndpoint(mqhub.ReactorAs("work", func(int) { atomic.AddInt32(&handled[i], 1) }))
+		_, err = host.(*local.Connector).PublishShared(context.Background(), comp, "workers")
+		if !a.NoError(err) {
+			return
+		}
+	}
+
+	client, err := mqhub.NewConnector(hubURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	for i := 0; i < 4; i++ {
+		a.NoError(client.Describe("replica").Endpoint("work").ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	a.Eventually(func() bool {
+		return atomic.LoadInt32(&handled[0])+atomic.LoadInt32(&handled[1]) == 4
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	a.Equal(int32(2), atomic.LoadInt32(&handled[0]))
+	a.Equal(int32(2), atomic.LoadInt32(&handled[1]))
+}
+
+func TestErrorReport(t *testing.T) {
+	a := assert.New(t)
+	hubName := "hub-" + utils.UniqueID()
+	failures := make(chan string, 2)
+	host := local.NewConnector(&local.Options{
+		Hub:           hubName,
+		ErrorEndpoint: true,
+		ErrorHandler: func(topic string, err error) {
+			failures <- topic + ": " + err.Error()
+		},
+	})
+	if !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := &mqhub.DynamicBase{}
+	comp.SetID("checker")
+	comp.AddEndpoint(mqhub.ReactorAs("check", func(val int) error {
+		if val < 0 {
+			return fmt.Errorf("negative value")
+		}
+		return nil
+	}))
+	_, err := host.Publish(comp)
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector("local://" + hubName)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("checker")
+	reports := make(chan *mqhub.ErrorReport, 2)
+	_, err = desc.Endpoint(mqhub.ErrorEndpoint).Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var report mqhub.ErrorReport
+		a.NoError(msg.As(&report))
+		reports <- &report
+		return nil
+	}))
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(desc.Endpoint("check").ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+	a.NoError(desc.Endpoint("check").ConsumeMessage(mqhub.MsgFrom("abc")).Wait())
+	a.NoError(desc.Endpoint("check").ConsumeMessage(mqhub.MsgFrom(-1)).Wait())
+	received := make(map[bool]*mqhub.ErrorReport)
+	for i := 0; i < 2; i++ {
+		select {
+		case report := <-reports:
+			a.Equal("checker/check", report.Topic)
+			received[report.Decode] = report
+		case <-time.After(time.Second):
+			t.Fatal("error not reported")
+		}
+		select {
+		case failure := <-failures:
+			a.Contains(failure, "checker/check: ")
+		case <-time.After(time.Second):
+			t.Fatal("error handler not notified")
+		}
+	}
+	if a.Contains(received, false) {
+		a.Equal("negative value", received[false].Error)
+	}
+	a.Contains(received, true)
+}
@@ -0,0 +1,94 @@
+package local
+
+import (
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+)
+
+// Message implements mqhub.Message for messages routed in memory
+type Message struct {
+	ComponentID  string
+	EndpointName string
+
+	pkt      *packet
+	retained bool
+}
+
+func newMessage(prefix string, pkt *packet, retained bool) *Message {
+	m := &Message{pkt: pkt, retained: retained}
+	m.ComponentID, m.EndpointName = mqtt.ParseTopic(pkt.topic, prefix)
+	return m
+}
+
+// Topic returns the full topic the message is published to
+func (m *Message) Topic() string {
+	return m.pkt.topic
+}
+
+// Component implements Message
+func (m *Message) Component() string {
+	return m.ComponentID
+}
+
+// Endpoint implements Message
+func (m *Message) Endpoint() string {
+	return m.EndpointName
+}
+
+// Value implements Message
+func (m *Message) Value() (interface{}, bool) {
+	return m.pkt.origin.Value()
+}
+
+// IsState implements Message
+// same as MQTT, it's only true when delivered as retained message
+func (m *Message) IsState() bool {
+	return m.retained
+}
+
+// As implements Message
+func (m *Message) As(out interface{}) error {
+	data, err := m.pkt.payload()
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		return m.pkt.codec.Decode(data, out)
+	}
+	return nil
+}
+
+// Codec returns the codec which encoded the payload
+func (m *Message) Codec() mqhub.Codec {
+	return m.pkt.codec
+}
+
+// Payload implements EncodedPayload
+func (m *Message) Payload() ([]byte, error) {
+	return m.pkt.payload()
+}
+
+// Properties implements PropertiesCarrier, the content type is
+// the codec if not JSON, the same as MQTT v5
+func (m *Message) Properties() *mqhub.Properties {
+	var props *mqhub.Properties
+	if carrier, ok := m.pkt.origin.(mqhub.PropertiesCarrier); ok {
+		props = carrier.Properties()
+	}
+	if m.pkt.codec == mqhub.JSON {
+		return props
+	}
+	if props == nil {
+		props = &mqhub.Properties{}
+	} else {
+		copied := *props
+		props = &copied
+	}
+	props.ContentType = m.pkt.codec.ContentType()
+	return props
+}
+
+// Metadata implements MetadataCarrier
+func (m *Message) Metadata() *mqhub.Metadata {
+	return mqhub.MetadataOf(m.pkt.origin)
+}
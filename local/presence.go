@@ -0,0 +1,29 @@
+package local
+
+import (
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+)
+
+func presenceTopic(compTopic string) string {
+	return mqtt.EndpointTopic(compTopic, mqhub.PresenceEndpoint)
+}
+
+// presenceRef is the presence of a published component
+type presenceRef struct {
+	conn  *Connector
+	topic string
+}
+
+// Watch implements Watchable
+func (r *presenceRef) Watch(sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	return watchTopic(r.conn, r, presenceTopic(r.topic),
+		mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+			var presence mqhub.Presence
+			if err := msg.As(&presence); err != nil {
+				return &mqhub.ImmediateFuture{Error: err}
+			}
+			presence.ComponentID = r.topic
+			return sink.ConsumeMessage(&presence)
+		}))
+}
@@ -0,0 +1,367 @@
+package local
+
+import (
+	"path"
+	"sync"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+)
+
+// Publication implements mqhub.Publication
+type Publication struct {
+	endpointSet
+	conn    *Connector
+	comp    mqhub.Component
+	desc    Descriptor
+	handler *HandlerRef
+	lock    sync.RWMutex
+	// share is the share group of reactors not specifying one
+	share string
+	// exported is set once the endpoints are attached by export
+	exported bool
+}
+
+// endpointSet is the endpoints of a publication, or the endpoints
+// added to or removed from it, by topics
+type endpointSet struct {
+	emits    map[string]*DataEmitter
+	sinks    map[string]*DataSink
+	dynamics map[string]mqhub.DynamicComponent
+}
+
+func newEndpointSet() endpointSet {
+	return endpointSet{
+		emits:    make(map[string]*DataEmitter),
+		sinks:    make(map[string]*DataSink),
+		dynamics: make(map[string]mqhub.DynamicComponent),
+	}
+}
+
+// copy returns a set with the same endpoints
+func (s endpointSet) copy() endpointSet {
+	c := newEndpointSet()
+	for topic, emit := range s.emits {
+		c.emits[topic] = emit
+	}
+	for topic, sink := range s.sinks {
+		c.sinks[topic] = sink
+	}
+	for topic, dynamic := range s.dynamics {
+		c.dynamics[topic] = dynamic
+	}
+	return c
+}
+
+func (s endpointSet) sinkFilters() []string {
+	filters := make([]string, 0, len(s.sinks))
+	for _, sink := range s.sinks {
+		filters = append(filters, sink.filter)
+	}
+	return filters
+}
+
+// Component implements Publication
+func (p *Publication) Component() mqhub.Component {
+	return p.comp
+}
+
+// Close implements Publication, the same as the mqtt connector
+func (p *Publication) Close() error {
+	futures := mqhub.Futures{
+		p.unexport(),
+		p.conn.clearRetained(p.advTopic()),
+		p.announce(false),
+	}
+	p.conn.removePub(p)
+	return futures.Wait()
+}
+
+func newPublication(conn *Connector, comp mqhub.Component, share string) *Publication {
+	pub := &Publication{
+		endpointSet: newEndpointSet(),
+		conn:        conn,
+		comp:        comp,
+		desc: Descriptor{
+			ComponentID: comp.ID(),
+			SubTopic:    comp.ID(),
+			conn:        conn,
+		},
+		share: share,
+	}
+	pub.handler = MakeHandlerRef(pub.handleMessage)
+	pub.populate(&pub.endpointSet, pub.desc.SubTopic, comp, true)
+	return pub
+}
+
+// populate collects the endpoints of the component and sub-components, with
+// observe, dynamic components are observed before enumerated, so no change
+// is missed, and the changes already enumerated are skipped by add and remove
+func (p *Publication) populate(set *endpointSet, topic string, comp mqhub.Component, observe bool) {
+	if dynamic, ok := comp.(mqhub.DynamicComponent); ok {
+		set.dynamics[topic] = dynamic
+		if observe {
+			dynamic.ObserveComposition(compObserver{pub: p, topic: topic})
+		}
+	}
+	endpoints := comp.Endpoints()
+	for _, endpoint := range endpoints {
+		p.populateEndpoint(set, topic, endpoint)
+	}
+	if composite, ok := comp.(mqhub.Composite); ok {
+		components := composite.Components()
+		for _, c := range components {
+			p.populate(set, path.Join(topic, c.ID()), c, observe)
+		}
+	}
+}
+
+func (p *Publication) populateEndpoint(set *endpointSet, topic string, endpoint mqhub.Endpoint) {
+	if datapoint, ok := endpoint.(mqhub.MessageSource); ok {
+		endpointTopic := mqtt.EndpointTopic(topic, endpoint.ID())
+		set.emits[endpointTopic] = &DataEmitter{
+			pub:    p,
+			topic:  endpointTopic,
+			codec:  mqhub.EndpointCodec(endpoint),
+			source: datapoint,
+		}
+	}
+	if reactor, ok := endpoint.(mqhub.MessageSink); ok {
+		endpointTopic := mqtt.EndpointTopic(topic, endpoint.ID())
+		share := mqhub.EndpointShareGroup(endpoint)
+		if share == "" {
+			share = p.share
+		}
+		set.sinks[endpointTopic] = &DataSink{
+			pub:    p,
+			topic:  endpointTopic,
+			filter: mqtt.SharedFilter(share, endpointTopic),
+			sink:   reactor,
+		}
+	}
+}
+
+func (p *Publication) export() error {
+	p.lock.Lock()
+	p.exported = true
+	set := p.endpointSet.copy()
+	p.lock.Unlock()
+	err := p.attach(set).Wait()
+	if err == nil {
+		err = p.advertise().Wait()
+	}
+	if err == nil {
+		err = p.announce(true).Wait()
+	}
+	return err
+}
+
+// attach subscribes the reactors and binds the datapoints in the set
+func (p *Publication) attach(set endpointSet) mqhub.Future {
+	future := p.conn.sub(set.sinkFilters(), p.handler)
+	for _, emit := range set.emits {
+		emit.bind()
+	}
+	return future
+}
+
+// detach reverts attach, and clears the retained states if enabled
+func (p *Publication) detach(set endpointSet) mqhub.Future {
+	for topic, dynamic := range set.dynamics {
+		dynamic.UnobserveComposition(compObserver{pub: p, topic: topic})
+	}
+	var futures mqhub.Futures
+	for _, emit := range set.emits {
+		emit.unbind()
+		if p.conn.clearStates && p.conn.Hub.hasRetained(p.conn.topicPrefix+emit.topic) {
+			futures = append(futures, p.conn.clearRetained(emit.topic))
+		}
+	}
+	return append(futures, p.conn.unsub(set.sinkFilters(), p.handler))
+}
+
+// add applies the endpoints added after being observed, the ones already
+// in the publication are skipped, and before exported, they are attached
+// by export
+func (p *Publication) add(set endpointSet) mqhub.Future {
+	added := newEndpointSet()
+	p.lock.Lock()
+	for topic, emit := range set.emits {
+		if p.emits[topic] == nil {
+			p.emits[topic], added.emits[topic] = emit, emit
+		}
+	}
+	for topic, sink := range set.sinks {
+		if p.sinks[topic] == nil {
+			p.sinks[topic], added.sinks[topic] = sink, sink
+		}
+	}
+	for topic, dynamic := range set.dynamics {
+		if p.dynamics[topic] == nil {
+			p.dynamics[topic], added.dynamics[topic] = dynamic, dynamic
+		}
+	}
+	exported := p.exported
+	p.lock.Unlock()
+	if !exported {
+		return &mqhub.ImmediateFuture{}
+	}
+	return mqhub.Futures{p.attach(added), p.advertise()}
+}
+
+// remove applies the endpoints removed after being observed, the ones not
+// in the publication are skipped
+func (p *Publication) remove(set endpointSet) mqhub.Future {
+	removed := newEndpointSet()
+	p.lock.Lock()
+	for topic := range set.emits {
+		if emit := p.emits[topic]; emit != nil {
+			delete(p.emits, topic)
+			removed.emits[topic] = emit
+		}
+	}
+	for topic := range set.sinks {
+		if sink := p.sinks[topic]; sink != nil {
+			delete(p.sinks, topic)
+			removed.sinks[topic] = sink
+		}
+	}
+	for topic := range set.dynamics {
+		if dynamic := p.dynamics[topic]; dynamic != nil {
+			delete(p.dynamics, topic)
+			removed.dynamics[topic] = dynamic
+		}
+	}
+	exported := p.exported
+	p.lock.Unlock()
+	if !exported {
+		for topic, dynamic := range removed.dynamics {
+			dynamic.UnobserveComposition(compObserver{pub: p, topic: topic})
+		}
+		return &mqhub.ImmediateFuture{}
+	}
+	return mqhub.Futures{p.detach(removed), p.advertise()}
+}
+
+func (p *Publication) advTopic() string {
+	return mqtt.EndpointTopic(p.desc.SubTopic, mqhub.AdvertisementEndpoint)
+}
+
+// advertise publishes the Advertisement of the component
+func (p *Publication) advertise() mqhub.Future {
+	return p.conn.pub(p.advTopic(), mqhub.JSON, mqhub.StateFrom(mqhub.AdvertisementOf(p.comp)))
+}
+
+// announce publishes the presence of the component if presence is enabled
+func (p *Publication) announce(online bool) mqhub.Future {
+	if !p.conn.presence {
+		return &mqhub.ImmediateFuture{}
+	}
+	return p.conn.pub(presenceTopic(p.desc.SubTopic), mqhub.JSON, &mqhub.Presence{
+		ComponentID: p.comp.ID(),
+		Online:      online,
+	})
+}
+
+func (p *Publication) unexport() mqhub.Future {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.detach(p.endpointSet)
+}
+
+func (p *Publication) handleMessage(msg *Message) {
+	if msg.EndpointName != "" {
+		p.lock.RLock()
+		sink := p.sinks[mqtt.EndpointTopic(msg.ComponentID, msg.EndpointName)]
+		p.lock.RUnlock()
+		if sink != nil {
+			future := sink.ConsumeMessage(msg)
+			if msg.pkt.reply != nil || p.conn.reportsErrors() {
+				go p.complete(sink, msg, future)
+			}
+		}
+	}
+}
+
+// complete replies the call and reports the failure once the message
+// is handled
+func (p *Publication) complete(sink *DataSink, msg *Message, future mqhub.Future) {
+	if future == nil {
+		future = &mqhub.ImmediateFuture{}
+	}
+	if msg.pkt.reply != nil {
+		reply(msg.pkt.reply, future)
+	}
+	if err := future.Wait(); err != nil {
+		p.conn.reportError(sink.topic, err)
+	}
+}
+
+// compObserver applies the changes of a dynamic component to the publication,
+// it's compared by value to be removed
+type compObserver struct {
+	pub   *Publication
+	topic string
+}
+
+// EndpointAdded implements CompositionObserver
+func (o compObserver) EndpointAdded(endpoint mqhub.Endpoint) mqhub.Future {
+	set := newEndpointSet()
+	o.pub.populateEndpoint(&set, o.topic, endpoint)
+	return o.pub.add(set)
+}
+
+// EndpointRemoved implements CompositionObserver
+func (o compObserver) EndpointRemoved(endpoint mqhub.Endpoint) mqhub.Future {
+	set := newEndpointSet()
+	o.pub.populateEndpoint(&set, o.topic, endpoint)
+	return o.pub.remove(set)
+}
+
+// ComponentAdded implements CompositionObserver
+func (o compObserver) ComponentAdded(comp mqhub.Component) mqhub.Future {
+	set := newEndpointSet()
+	o.pub.populate(&set, path.Join(o.topic, comp.ID()), comp, true)
+	return o.pub.add(set)
+}
+
+// ComponentRemoved implements CompositionObserver
+func (o compObserver) ComponentRemoved(comp mqhub.Component) mqhub.Future {
+	set := newEndpointSet()
+	o.pub.populate(&set, path.Join(o.topic, comp.ID()), comp, false)
+	return o.pub.remove(set)
+}
+
+// DataEmitter is a consumer which publish the data to hub
+type DataEmitter struct {
+	pub    *Publication
+	topic  string
+	codec  mqhub.Codec
+	source mqhub.MessageSource
+}
+
+// ConsumeMessage emits the message
+func (e *DataEmitter) ConsumeMessage(msg mqhub.Message) mqhub.Future {
+	return e.pub.conn.pub(e.topic, e.codec, msg)
+}
+
+func (e *DataEmitter) bind() {
+	e.source.SinkMessage(e)
+}
+
+func (e *DataEmitter) unbind() {
+	e.source.SinkMessage(nil)
+}
+
+// DataSink is a consumer receives messages from hub
+type DataSink struct {
+	pub    *Publication
+	topic  string
+	filter string
+	sink   mqhub.MessageSink
+}
+
+// ConsumeMessage implements MessageSink
+func (s *DataSink) ConsumeMessage(msg mqhub.Message) mqhub.Future {
+	return s.sink.ConsumeMessage(msg)
+}
@@ -0,0 +1,53 @@
+package local
+
+import (
+	"context"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+func (c *Connector) call(ctx context.Context, topic string, codec mqhub.Codec, req interface{}, resp interface{}) error {
+	if !c.isConnected() {
+		return ErrNotConnected
+	}
+	msg, ok := req.(mqhub.Message)
+	if !ok {
+		msg = mqhub.MsgFrom(req)
+	}
+	pkt := c.newPacket(topic, codec, msg)
+	pkt.retain = false
+	pkt.reply = make(chan *mqhub.Reply, 1)
+	if err := c.Hub.publish(pkt); err != nil {
+		return err
+	}
+	select {
+	case reply := <-pkt.reply:
+		if reply.Error != nil {
+			return &mqhub.RemoteError{Message: reply.Error.Error()}
+		}
+		if resp == nil || reply.Value == nil {
+			return nil
+		}
+		// encoded the same way as the mqtt connector
+		data, err := pkt.codec.Encode(reply.Value)
+		if err != nil {
+			return err
+		}
+		return pkt.codec.Decode(data, resp)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reply waits for the result of the handler and sends it to the caller,
+// only the first reply is accepted
+func reply(replyCh chan *mqhub.Reply, future mqhub.Future) {
+	reply := &mqhub.Reply{Error: future.Wait()}
+	if r, ok := future.(*mqhub.Reply); ok && reply.Error == nil {
+		reply.Value = r.Value
+	}
+	select {
+	case replyCh <- reply:
+	default:
+	}
+}
@@ -0,0 +1,51 @@
+package local
+
+import (
+	"strings"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+type topicWatcher struct {
+	conn    *Connector
+	target  mqhub.Watchable
+	topic   string
+	sink    mqhub.MessageSink
+	handler *HandlerRef
+}
+
+func watchTopic(conn *Connector, target mqhub.Watchable,
+	topic string, sink mqhub.MessageSink) (*topicWatcher, error) {
+	w := &topicWatcher{
+		conn:   conn,
+		target: target,
+		topic:  topic,
+		sink:   sink,
+	}
+	w.handler = MakeHandlerRef(w.recvMessage)
+	return w, w.conn.sub([]string{topic}, w.handler).Wait()
+}
+
+// Close implements Watcher
+func (w *topicWatcher) Close() error {
+	w.conn.unsub([]string{w.topic}, w.handler)
+	return nil
+}
+
+// Watched implements Watcher
+func (w *topicWatcher) Watched() mqhub.Watchable {
+	return w.target
+}
+
+func (w *topicWatcher) recvMessage(msg *Message) {
+	if msg.EndpointName != "" && !w.skipReserved(msg.EndpointName) {
+		w.sink.ConsumeMessage(msg)
+	}
+}
+
+// skipReserved drops the messages of reserved endpoints unless watched
+// explicitly, like advertisements, presence and replies, they are used by
+// the hub rather than carry data
+func (w *topicWatcher) skipReserved(endpoint string) bool {
+	return mqhub.IsReservedEndpoint(endpoint) && strings.ContainsAny(w.topic, "+#")
+}
@@ -0,0 +1,249 @@
+// Package prometheus implements mqhub.Metrics exposing the measurements
+// in the Prometheus text format
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric kinds as the TYPE in the text format
+const (
+	counterKind   = "counter"
+	gaugeKind     = "gauge"
+	histogramKind = "histogram"
+)
+
+// family is a metric with all its time series by labels
+type family struct {
+	kind    string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series is a time series identified by the formatted labels
+type series struct {
+	labels string
+	value  float64
+	// counts are the observations in buckets (not cumulative) for
+	// histograms, and the last is +Inf
+	counts []uint64
+	count  uint64
+}
+
+// Registry collects the measurements, and serves them over HTTP
+type Registry struct {
+	families map[string]*family
+	buckets  map[string][]float64
+	lock     sync.Mutex
+}
+
+// NewRegistry creates a Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+		buckets:  make(map[string][]float64),
+	}
+}
+
+// SetBuckets sets the upper bounds of buckets of the histogram,
+// it must be called before the first observation
+func (r *Registry) SetBuckets(name string, buckets []float64) *Registry {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	r.lock.Lock()
+	r.buckets[name] = sorted
+	r.lock.Unlock()
+	return r
+}
+
+// Count implements mqhub.Metrics
+func (r *Registry) Count(name string, labels mqhub.Labels, delta float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s := r.series(name, counterKind, labels); s != nil {
+		s.value += delta
+	}
+}
+
+// Gauge implements mqhub.Metrics
+func (r *Registry) Gauge(name string, labels mqhub.Labels, value float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s := r.series(name, gaugeKind, labels); s != nil {
+		s.value = value
+	}
+}
+
+// Observe implements mqhub.Metrics
+func (r *Registry) Observe(name string, labels mqhub.Labels, value float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s := r.series(name, histogramKind, labels)
+	if s == nil {
+		return
+	}
+	buckets := r.families[name].buckets
+	s.counts[sort.SearchFloat64s(buckets, value)]++
+	s.count++
+	s.value += value
+}
+
+// series finds or creates the time series, nil if the metric is
+// already used as another kind, must be called with lock held
+func (r *Registry) series(name, kind string, labels mqhub.Labels) *series {
+	f := r.families[name]
+	if f == nil {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		if kind == histogramKind {
+			if f.buckets = r.buckets[name]; f.buckets == nil {
+				f.buckets = DefaultBuckets
+			}
+		}
+		r.families[name] = f
+	} else if f.kind != kind {
+		return nil
+	}
+	key := formatLabels(labels)
+	s := f.series[key]
+	if s == nil {
+		s = &series{labels: key}
+		if kind == histogramKind {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WriteTo writes all metrics in the text format, sorted by names and labels
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	buf := bufio.NewWriter(w)
+	cw := &countingWriter{w: buf}
+	r.lock.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.families[name].write(cw, name)
+	}
+	r.lock.Unlock()
+	if cw.err == nil {
+		cw.err = buf.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP implements http.Handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+func (f *family) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != histogramKind {
+			fmt.Fprintf(w, "%s%s %s\n", name, braced(s.labels), formatValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(f.buckets) {
+				le = f.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name,
+				braced(joinLabels(s.labels, `le="`+formatValue(le)+`"`)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, braced(s.labels), formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, braced(s.labels), s.count)
+	}
+}
+
+// formatLabels formats the labels sorted by names, without braces
+func formatLabels(labels mqhub.Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(labels[name]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the written bytes and keeps the first error
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
@@ -0,0 +1,47 @@
+package prometheus_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/robotalks/mqhub.go/metrics/prometheus"
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	a := assert.New(t)
+	r := prometheus.NewRegistry().SetBuckets("latency_seconds", []float64{1, 0.1})
+	r.Count("messages_total", mqhub.Labels{"topic": "comp/b"}, 1)
+	r.Count("messages_total", mqhub.Labels{"topic": "comp/a", "dir": "in"}, 2)
+	r.Count("messages_total", mqhub.Labels{"topic": "comp/a", "dir": "in"}, 3)
+	r.Count("messages_total", mqhub.Labels{"topic": `say "hi"\` + "\n"}, 1)
+	// the kind of a metric can't be changed
+	r.Gauge("messages_total", nil, 100)
+	r.Gauge("subscriptions", nil, 3)
+	r.Gauge("subscriptions", nil, 2)
+	r.Observe("latency_seconds", nil, 0.05)
+	r.Observe("latency_seconds", nil, 0.1)
+	r.Observe("latency_seconds", nil, 0.5)
+	r.Observe("latency_seconds", nil, 2)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	a.Equal(prometheus.ContentType, rec.Header().Get("Content-Type"))
+	body, err := ioutil.ReadAll(rec.Body)
+	a.NoError(err)
+	a.Equal(`# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 2.65
+latency_seconds_count 4
+# TYPE messages_total counter
+messages_total{dir="in",topic="comp/a"} 5
+messages_total{topic="comp/b"} 1
+messages_total{topic="say \"hi\"\\\n"} 1
+# TYPE subscriptions gauge
+subscriptions 2
+`, string(body))
+}
@@ -0,0 +1,169 @@
+package mqhub
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// AdvertisementEndpoint is the name of the endpoint carrying the
+// Advertisement of a published component
+const AdvertisementEndpoint = "$adv"
+
+// EndpointKind tells how an endpoint exchanges messages
+type EndpointKind string
+
+// Endpoint kinds
+const (
+	// DataPointKind emits messages
+	DataPointKind EndpointKind = "datapoint"
+	// ReactorKind consumes messages
+	ReactorKind EndpointKind = "reactor"
+)
+
+// EndpointInfo describes an endpoint in the Advertisement
+type EndpointInfo struct {
+	Name   string       `json:"name"`
+	Kind   EndpointKind `json:"kind"`
+	Retain bool         `json:"retain,omitempty"`
+	// Type is the Go type of the payload if known
+	Type string `json:"type,omitempty"`
+	// Schema is the JSON schema of the payload if known
+	Schema map[string]interface{} `json:"schema,omitempty"`
+}
+
+// Advertisement is the self-description of a published component
+type Advertisement struct {
+	ComponentID string           `json:"id"`
+	Endpoints   []EndpointInfo   `json:"endpoints"`
+	Components  []*Advertisement `json:"components,omitempty"`
+}
+
+// Find returns the advertisement of the sub-component, nil if not found
+func (a *Advertisement) Find(id ...string) *Advertisement {
+	if len(id) == 0 {
+		return a
+	}
+	for _, comp := range a.Components {
+		if comp.ComponentID == id[0] {
+			return comp.Find(id[1:]...)
+		}
+	}
+	return nil
+}
+
+// RetainEndpoint is an endpoint telling whether messages are retained
+type RetainEndpoint interface {
+	EndpointRetain() bool
+}
+
+// EndpointRetain returns whether messages from the endpoint are retained
+func EndpointRetain(endpoint Endpoint) bool {
+	if e, ok := endpoint.(RetainEndpoint); ok {
+		return e.EndpointRetain()
+	}
+	return false
+}
+
+// PayloadEndpoint is an endpoint knowing the type of its payload
+type PayloadEndpoint interface {
+	EndpointPayloadType() reflect.Type
+}
+
+// AdvertisementOf describes the component and its sub-components
+func AdvertisementOf(comp Component) *Advertisement {
+	adv := &Advertisement{ComponentID: comp.ID(), Endpoints: []EndpointInfo{}}
+	for _, endpoint := range comp.Endpoints() {
+		info := EndpointInfo{Name: endpoint.ID(), Kind: ReactorKind}
+		if _, ok := endpoint.(MessageSource); ok {
+			info.Kind = DataPointKind
+		}
+		info.Retain = EndpointRetain(endpoint)
+		if e, ok := endpoint.(PayloadEndpoint); ok {
+			if t := e.EndpointPayloadType(); t != nil {
+				info.Type = t.String()
+				info.Schema = SchemaOf(t)
+			}
+		}
+		adv.Endpoints = append(adv.Endpoints, info)
+	}
+	if composite, ok := comp.(Composite); ok {
+		for _, sub := range composite.Components() {
+			adv.Components = append(adv.Components, AdvertisementOf(sub))
+		}
+	}
+	return adv
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf generates the JSON schema of the type as encoded by JSON
+func SchemaOf(t reflect.Type) map[string]interface{} {
+	return schemaOf(t, make(map[reflect.Type]bool))
+}
+
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			// recursive type
+			return map[string]interface{}{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		props := make(map[string]interface{})
+		addStructProperties(t, props, visiting)
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
+
+func addStructProperties(t reflect.Type, props map[string]interface{}, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructProperties(ft, props, visiting)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		props[name] = schemaOf(field.Type, visiting)
+	}
+}
@@ -0,0 +1,116 @@
+package mqhub
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec encodes values of messages into payloads and decodes them back
+type Codec interface {
+	// ContentType identifies the codec on the wire, e.g. application/json
+	ContentType() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, out interface{}) error
+}
+
+// CodecEndpoint is an endpoint specifying the codec of its messages
+type CodecEndpoint interface {
+	EndpointCodec() Codec
+}
+
+var (
+	_codecs     = make(map[string]Codec)
+	_codecsLock sync.RWMutex
+)
+
+// RegisterCodec registers a codec by name and its content type
+func RegisterCodec(name string, codec Codec) {
+	_codecsLock.Lock()
+	defer _codecsLock.Unlock()
+	_codecs[name] = codec
+	_codecs[codec.ContentType()] = codec
+}
+
+// LookupCodec finds a codec by name or content type, nil if not registered
+func LookupCodec(name string) Codec {
+	_codecsLock.RLock()
+	defer _codecsLock.RUnlock()
+	return _codecs[name]
+}
+
+// EndpointCodec returns the codec specified by the endpoint, nil if
+// not specified
+func EndpointCodec(endpoint Endpoint) Codec {
+	if e, ok := endpoint.(CodecEndpoint); ok {
+		return e.EndpointCodec()
+	}
+	return nil
+}
+
+// MessageCodec returns the codec identified by the content type of
+// the message, nil if not specified or not registered
+func MessageCodec(msg Message) Codec {
+	if carrier, ok := msg.(PropertiesCarrier); ok {
+		if props := carrier.Properties(); props != nil && props.ContentType != "" {
+			return LookupCodec(props.ContentType)
+		}
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+// JSON is the default codec
+var JSON Codec = jsonCodec{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, out interface{}) error {
+	return json.Unmarshal(data, out)
+}
+
+type rawCodec struct{}
+
+// Raw is the codec passing bytes through, values must be
+// []byte or string, or implement EncodedPayload
+var Raw Codec = rawCodec{}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (rawCodec) Encode(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case []byte:
+		return val, nil
+	case string:
+		return []byte(val), nil
+	case EncodedPayload:
+		return val.Payload()
+	}
+	return nil, fmt.Errorf("raw codec unable to encode %T", v)
+}
+
+func (rawCodec) Decode(data []byte, out interface{}) error {
+	switch val := out.(type) {
+	case *[]byte:
+		*val = append([]byte(nil), data...)
+	case *string:
+		*val = string(data)
+	default:
+		return fmt.Errorf("raw codec unable to decode into %T", out)
+	}
+	return nil
+}
+
+func init() {
+	RegisterCodec("json", JSON)
+	RegisterCodec("raw", Raw)
+}
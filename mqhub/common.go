@@ -2,7 +2,10 @@ package mqhub
 
 import (
 	"context"
+	"errors"
 	"reflect"
+	"sync/atomic"
+	"time"
 )
 
 // MessageSinkFunc is func form of MessageSink
@@ -18,37 +21,70 @@ func (f MessageSinkFunc) ConsumeMessage(msg Message) Future {
 }
 
 // MessageSinkAs converts a func with arbitrary parameter to MessageSink
+// the func may return an error, or a value and an error,
+// e.g. func(Req) (Resp, error), which are carried back by Reply
 func MessageSinkAs(handler interface{}) MessageSink {
 	v := reflect.ValueOf(handler)
 	if v.Kind() != reflect.Func {
 		panic("handler must be a func")
 	}
 	t := v.Type()
-	switch t.NumIn() {
-	case 0:
+	if t.NumIn() > 1 {
+		panic("no more than 1 parameter is allowed")
+	}
+	results := makeReply(t)
+	if t.NumIn() == 0 {
 		return MessageSinkFunc(func(_ Message) Future {
-			v.Call(nil)
-			return &ImmediateFuture{}
+			return results(v.Call(nil))
 		})
-	case 1:
-		paramType := t.In(0)
-		return MessageSinkFunc(func(msg Message) Future {
-			val := reflect.New(paramType)
-			err := msg.As(val.Interface())
-			if err == nil {
-				v.Call([]reflect.Value{val.Elem()})
-			}
-			return &ImmediateFuture{Error: err}
-		})
-	default:
-		panic("no more than 1 parameter is allowed")
 	}
+	paramType := t.In(0)
+	return MessageSinkFunc(func(msg Message) Future {
+		val := reflect.New(paramType)
+		if err := msg.As(val.Interface()); err != nil {
+			return &ImmediateFuture{Error: &DecodeError{Err: err}}
+		}
+		return results(v.Call([]reflect.Value{val.Elem()}))
+	})
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// makeReply returns the converter from results of the func to Future
+func makeReply(t reflect.Type) func([]reflect.Value) Future {
+	switch {
+	case t.NumOut() == 1 && t.Out(0) == errorType:
+		return func(out []reflect.Value) Future {
+			return &Reply{Error: valueToError(out[0])}
+		}
+	case t.NumOut() == 2 && t.Out(1) == errorType:
+		return func(out []reflect.Value) Future {
+			return &Reply{Value: out[0].Interface(), Error: valueToError(out[1])}
+		}
+	}
+	// other results are ignored
+	return func([]reflect.Value) Future {
+		return &ImmediateFuture{}
+	}
+}
+
+func valueToError(v reflect.Value) error {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Interface().(error)
 }
 
 // DataPoint implements Endpoint for a data point
 type DataPoint struct {
+	// seq is the sequence number of the last update, first in the
+	// struct for 64-bit alignment of atomic operations
+	seq uint64
+
 	Name   string
 	Retain bool
+	QoS    QoS
+	Codec  Codec
 	Sink   MessageSink
 }
 
@@ -72,12 +108,40 @@ func (p *DataPoint) ID() string {
 	return p.Name
 }
 
+// EndpointQoS implements QoSEndpoint
+func (p *DataPoint) EndpointQoS() QoS {
+	return p.QoS
+}
+
+// WithQoS sets the delivery guarantee
+func (p *DataPoint) WithQoS(qos QoS) *DataPoint {
+	p.QoS = qos
+	return p
+}
+
+// EndpointRetain implements RetainEndpoint
+func (p *DataPoint) EndpointRetain() bool {
+	return p.Retain
+}
+
+// EndpointCodec implements CodecEndpoint
+func (p *DataPoint) EndpointCodec() Codec {
+	return p.Codec
+}
+
+// WithCodec sets the codec encoding the updates
+func (p *DataPoint) WithCodec(codec Codec) *DataPoint {
+	p.Codec = codec
+	return p
+}
+
 // SinkMessage implements MessageSource
 func (p *DataPoint) SinkMessage(sink MessageSink) {
 	p.Sink = sink
 }
 
-// Update updates the state
+// Update updates the state, the OriginMsg without metadata is sent as a
+// copy stamped with the time and the next sequence number of the datapoint
 func (p *DataPoint) Update(state interface{}) Future {
 	sink := p.Sink
 	if sink == nil {
@@ -87,13 +151,30 @@ func (p *DataPoint) Update(state interface{}) Future {
 	if !ok {
 		msg = MakeMsg(state, p.Retain)
 	}
-	return p.Sink.ConsumeMessage(msg)
+	if origin, ok := msg.(*OriginMsg); ok && origin.Meta == nil {
+		stamped := *origin
+		stamped.Meta = &Metadata{
+			Timestamp: time.Now(),
+			Sequence:  atomic.AddUint64(&p.seq, 1),
+		}
+		msg = &stamped
+	}
+	return sink.ConsumeMessage(msg)
 }
 
 // Reactor implements Endpoint for a reactor to an update
 type Reactor struct {
-	Name    string
+	Name string
+	QoS  QoS
+	// Codec decodes the messages whose codec is not identified on the wire,
+	// e.g. sent by clients not using mqhub
+	Codec   Codec
 	Handler MessageSink
+	// ShareGroup subscribes the reactor in the share group if not empty
+	ShareGroup string
+
+	// payloadType is the parameter type of the handler from ReactorAs
+	payloadType reflect.Type
 }
 
 // ReactorFunc creates a Reactor from MessageSinkFunc
@@ -101,9 +182,14 @@ func ReactorFunc(name string, handler MessageSinkFunc) *Reactor {
 	return &Reactor{Name: name, Handler: handler}
 }
 
-// ReactorAs accepts a func with arbitrary parameter
+// ReactorAs accepts a func with arbitrary parameter, a func returning
+// values, like func(Req) (Resp, error), can be invoked using EndpointRef.Call
 func ReactorAs(name string, handler interface{}) *Reactor {
-	return &Reactor{Name: name, Handler: MessageSinkAs(handler)}
+	r := &Reactor{Name: name, Handler: MessageSinkAs(handler)}
+	if t := reflect.TypeOf(handler); t != nil && t.Kind() == reflect.Func && t.NumIn() > 0 {
+		r.payloadType = t.In(0)
+	}
+	return r
 }
 
 // ID implements Endpoint
@@ -111,6 +197,44 @@ func (a *Reactor) ID() string {
 	return a.Name
 }
 
+// EndpointQoS implements QoSEndpoint
+func (a *Reactor) EndpointQoS() QoS {
+	return a.QoS
+}
+
+// EndpointPayloadType implements PayloadEndpoint
+func (a *Reactor) EndpointPayloadType() reflect.Type {
+	return a.payloadType
+}
+
+// EndpointCodec implements CodecEndpoint
+func (a *Reactor) EndpointCodec() Codec {
+	return a.Codec
+}
+
+// EndpointShareGroup implements SharedEndpoint
+func (a *Reactor) EndpointShareGroup() string {
+	return a.ShareGroup
+}
+
+// WithQoS sets the delivery guarantee
+func (a *Reactor) WithQoS(qos QoS) *Reactor {
+	a.QoS = qos
+	return a
+}
+
+// WithCodec sets the codec decoding the messages
+func (a *Reactor) WithCodec(codec Codec) *Reactor {
+	a.Codec = codec
+	return a
+}
+
+// WithShareGroup subscribes the reactor in the share group
+func (a *Reactor) WithShareGroup(group string) *Reactor {
+	a.ShareGroup = group
+	return a
+}
+
 // ConsumeMessage implements MessageSink
 func (a *Reactor) ConsumeMessage(msg Message) Future {
 	return a.Handler.ConsumeMessage(msg)
@@ -142,3 +266,62 @@ type ImmediateFuture struct {
 func (f *ImmediateFuture) Wait() error {
 	return f.Error
 }
+
+// WaitContext implements ContextFuture
+func (f *ImmediateFuture) WaitContext(context.Context) error {
+	return f.Error
+}
+
+// Futures completes when all the futures complete, the errors are joined
+type Futures []Future
+
+// Wait implements Future
+func (f Futures) Wait() error {
+	var errs []error
+	for _, future := range f {
+		if err := future.Wait(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WaitContext implements ContextFuture
+func (f Futures) WaitContext(ctx context.Context) error {
+	var errs []error
+	for _, future := range f {
+		if err := WaitContext(ctx, future); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reply is the result of a handler returning values,
+// it's sent back to the caller of EndpointRef.Call
+type Reply struct {
+	Value interface{}
+	Error error
+}
+
+// Wait implements Future
+func (r *Reply) Wait() error {
+	return r.Error
+}
+
+// WaitContext implements ContextFuture
+func (r *Reply) WaitContext(context.Context) error {
+	return r.Error
+}
+
+// RemoteError is the error returned by the remote handler of a Call
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
@@ -11,6 +11,7 @@ import (
 //   - mqhub+mqtt+ws://server:port/topic
 //   - mqtt://server:port/topic
 //   - mqtt+ws://server:port/topic
+//   - mqtts://server:port/topic
 const Protocol = "mqhub"
 
 // ConnectorFactory creates a connector from a URL
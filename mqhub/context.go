@@ -0,0 +1,55 @@
+package mqhub
+
+import "context"
+
+// WaitContext waits for the future until ctx is done,
+// a Future not implementing ContextFuture is waited in background
+func WaitContext(ctx context.Context, future Future) error {
+	if f, ok := future.(ContextFuture); ok {
+		return f.WaitContext(ctx)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- future.Wait()
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WatchContext watches until ctx is done, for a Watchable not
+// implementing ContextWatchable, the watcher established after
+// ctx is done is closed
+func WatchContext(ctx context.Context, watchable Watchable, sink MessageSink) (Watcher, error) {
+	if w, ok := watchable.(ContextWatchable); ok {
+		return w.WatchContext(ctx, sink)
+	}
+	type result struct {
+		watcher Watcher
+		err     error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		w, err := watchable.Watch(sink)
+		resultCh <- result{watcher: w, err: err}
+	}()
+	select {
+	case r := <-resultCh:
+		return r.watcher, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-resultCh; r.err == nil {
+				r.watcher.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// ConsumeContext sends the message to sink and waits until ctx is done
+func ConsumeContext(ctx context.Context, sink MessageSink, msg Message) error {
+	return WaitContext(ctx, sink.ConsumeMessage(msg))
+}
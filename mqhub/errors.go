@@ -1,9 +1,52 @@
 package mqhub
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrNoMessageSink is reported by DataPoint when message sink is not yet
 	// connected
 	ErrNoMessageSink = fmt.Errorf("message sink unavailable")
 )
+
+// ErrorEndpoint is the name of the endpoint where a published component
+// reports the failures of its reactors
+const ErrorEndpoint = "$error"
+
+// DecodeError is reported when a message can't be decoded into the
+// parameter of a handler
+type DecodeError struct {
+	Err error
+}
+
+// Error implements error
+func (e *DecodeError) Error() string {
+	return "decode: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorReport is published to ErrorEndpoint when a reactor fails
+type ErrorReport struct {
+	// Topic is the endpoint topic of the message relative to the namespace
+	Topic string `json:"topic"`
+	Error string `json:"error"`
+	// Decode tells the message can't be decoded
+	Decode bool `json:"decode,omitempty"`
+}
+
+// ErrorReportOf creates the report of the failure handling the message
+// on the topic
+func ErrorReportOf(topic string, err error) *ErrorReport {
+	var decodeErr *DecodeError
+	return &ErrorReport{Topic: topic, Error: err.Error(), Decode: errors.As(err, &decodeErr)}
+}
+
+// ErrorHandler is notified when a reactor fails handling the message
+// on the endpoint topic
+type ErrorHandler func(topic string, err error)
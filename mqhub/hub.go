@@ -1,6 +1,11 @@
 package mqhub
 
-import "io"
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
 
 // Message is the abstraction of data entity passing through the hub
 type Message interface {
@@ -21,11 +26,34 @@ type EncodedPayload interface {
 	Payload() ([]byte, error)
 }
 
+// Properties are optional attributes of a message, they're carried along
+// with the payload only if supported by the connector (e.g. MQTT v5)
+type Properties struct {
+	// ContentType describes the payload, e.g. a MIME type
+	ContentType string
+	// Expiry is the lifetime of the message, zero means never expire
+	Expiry time.Duration
+	// User contains application defined metadata
+	User map[string]string
+}
+
+// PropertiesCarrier is implemented by messages with Properties
+type PropertiesCarrier interface {
+	// Properties returns nil if the message has no properties
+	Properties() *Properties
+}
+
 // Future represent an async operation
 type Future interface {
 	Wait() error
 }
 
+// ContextFuture is a Future which stops waiting when the context is done
+type ContextFuture interface {
+	Future
+	WaitContext(context.Context) error
+}
+
 // MessageSink defines the consumer of a message
 type MessageSink interface {
 	ConsumeMessage(Message) Future
@@ -41,6 +69,13 @@ type Watchable interface {
 	Watch(MessageSink) (Watcher, error)
 }
 
+// ContextWatchable is a Watchable which stops establishing the watching
+// state when the context is done
+type ContextWatchable interface {
+	Watchable
+	WatchContext(context.Context, MessageSink) (Watcher, error)
+}
+
 // Watcher is an established watching state
 type Watcher interface {
 	io.Closer
@@ -65,6 +100,12 @@ type Endpoint interface {
 	Identity
 }
 
+// IsReservedEndpoint returns whether the endpoint is reserved by the hub,
+// like AdvertisementEndpoint and PresenceEndpoint, the names start with $
+func IsReservedEndpoint(name string) bool {
+	return strings.HasPrefix(name, "$")
+}
+
 // Composite is a collection of components
 type Composite interface {
 	Components() []Component
@@ -75,13 +116,41 @@ type Composer interface {
 	AddComponent(Component)
 }
 
+// CompositionObserver is notified of the changes of a published component,
+// the returned Future completes when the change is applied to the hub
+type CompositionObserver interface {
+	EndpointAdded(Endpoint) Future
+	EndpointRemoved(Endpoint) Future
+	ComponentAdded(Component) Future
+	ComponentRemoved(Component) Future
+}
+
+// DynamicComponent is a component changing its endpoints or sub-components
+// after being published, each publication observes the changes, and stops
+// observing by removing its observer, observers are compared by ==
+type DynamicComponent interface {
+	ObserveComposition(CompositionObserver)
+	UnobserveComposition(CompositionObserver)
+}
+
 // Connector defines a general connector to message source/bus
 type Connector interface {
 	io.Closer
 	Watchable
 	Connect() Future
+	// ConnectContext connects and waits until connected or ctx is done
+	ConnectContext(context.Context) error
 	Publish(Component) (Publication, error)
+	// PublishContext publishes the component, and gives up
+	// (with the publication reverted) when ctx is done
+	PublishContext(context.Context, Component) (Publication, error)
 	Describe(componentID string) Descriptor
+	// Discover enumerates the published components in the namespace by
+	// their advertisements, it returns when no more advertisements arrive
+	// shortly or ctx is done
+	Discover(ctx context.Context) ([]Descriptor, error)
+	// Lifecycle streams ConnectionEvent when the connection state changes
+	Lifecycle() Watchable
 }
 
 // Publisher exposes components to hub
@@ -101,10 +170,26 @@ type Descriptor interface {
 	ID() string
 	SubComponent(id ...string) Descriptor
 	Endpoint(name string) EndpointRef
+	// Presence streams Presence of the published component
+	Presence() Watchable
+	// Endpoints describes the endpoints from the advertisement, nil if the
+	// descriptor is not from Connector.Discover
+	Endpoints() []EndpointInfo
+	// SubComponents enumerates the sub-components from the advertisement
+	SubComponents() []Descriptor
 }
 
 // EndpointRef references remote endpoints
 type EndpointRef interface {
 	Watchable
 	MessageSink
+	// WithQoS returns a reference to the same endpoint using the
+	// delivery guarantee for publishing and watching
+	WithQoS(QoS) EndpointRef
+	// WithCodec returns a reference to the same endpoint encoding
+	// messages using the codec
+	WithCodec(Codec) EndpointRef
+	// Call sends req to a reactor and waits for the reply decoded into
+	// resp (can be nil), errors returned by the reactor are RemoteError
+	Call(ctx context.Context, req interface{}, resp interface{}) error
 }
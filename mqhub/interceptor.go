@@ -0,0 +1,70 @@
+package mqhub
+
+import "fmt"
+
+// SinkKind tells which kind of MessageSink is intercepted
+type SinkKind string
+
+// Sink kinds
+const (
+	// EmitterSink publishes the messages from a datapoint
+	EmitterSink SinkKind = "emitter"
+	// ReactorSink is the handler of a reactor
+	ReactorSink SinkKind = "reactor"
+	// WatcherSink receives the messages of a watched topic
+	WatcherSink SinkKind = "watcher"
+)
+
+// SinkInfo describes the intercepted MessageSink
+type SinkInfo struct {
+	Kind SinkKind
+	// Topic is the endpoint topic, or the watched topic filter,
+	// relative to the namespace
+	Topic string
+}
+
+// Interceptor is invoked in place of the MessageSink next, e.g. for
+// logging, validation, it decides whether and how to pass msg to next
+type Interceptor func(msg Message, info *SinkInfo, next MessageSink) Future
+
+// ChainInterceptors composes the interceptors into one, the first is the
+// outermost, nil interceptors are skipped, and nil is returned if none
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	var chain []Interceptor
+	for _, interceptor := range interceptors {
+		if interceptor != nil {
+			chain = append(chain, interceptor)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return func(msg Message, info *SinkInfo, next MessageSink) Future {
+		return chain[0](msg, info, Intercept(next, info, chain[1:]...))
+	}
+}
+
+// Intercept wraps the sink with the interceptors, the first is the outermost
+func Intercept(sink MessageSink, info *SinkInfo, interceptors ...Interceptor) MessageSink {
+	interceptor := ChainInterceptors(interceptors...)
+	if interceptor == nil {
+		return sink
+	}
+	return MessageSinkFunc(func(msg Message) Future {
+		return interceptor(msg, info, sink)
+	})
+}
+
+// Recover is an Interceptor reporting a panic of the sink as the error
+// of the Future
+func Recover(msg Message, info *SinkInfo, next MessageSink) (future Future) {
+	defer func() {
+		if r := recover(); r != nil {
+			future = &ImmediateFuture{Error: fmt.Errorf("%s %s: panic: %v", info.Kind, info.Topic, r)}
+		}
+	}()
+	return next.ConsumeMessage(msg)
+}
@@ -0,0 +1,44 @@
+package mqhub_test
+
+import (
+	"testing"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/stretchr/testify/assert"
+)
+
+func tracer(name string, trace *[]string) mqhub.Interceptor {
+	return func(msg mqhub.Message, info *mqhub.SinkInfo, next mqhub.MessageSink) mqhub.Future {
+		*trace = append(*trace, name+">"+info.Topic)
+		future := next.ConsumeMessage(msg)
+		*trace = append(*trace, name+"<")
+		return future
+	}
+}
+
+func TestChainInterceptors(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(mqhub.ChainInterceptors())
+	a.Nil(mqhub.ChainInterceptors(nil, nil))
+
+	var trace []string
+	sink := mqhub.MessageSinkFunc(func(mqhub.Message) mqhub.Future {
+		trace = append(trace, "sink")
+		return nil
+	})
+	info := &mqhub.SinkInfo{Kind: mqhub.ReactorSink, Topic: "comp/a"}
+	chain := mqhub.ChainInterceptors(tracer("1", &trace), nil, tracer("2", &trace))
+	wrapped := mqhub.Intercept(sink, info, chain, tracer("3", &trace))
+	a.NoError(wrapped.ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+	a.Equal([]string{"1>comp/a", "2>comp/a", "3>comp/a", "sink", "3<", "2<", "1<"}, trace)
+}
+
+func TestRecover(t *testing.T) {
+	a := assert.New(t)
+	sink := mqhub.MessageSinkFunc(func(mqhub.Message) mqhub.Future {
+		panic("boom")
+	})
+	info := &mqhub.SinkInfo{Kind: mqhub.ReactorSink, Topic: "comp/a"}
+	err := mqhub.Intercept(sink, info, mqhub.Recover).ConsumeMessage(mqhub.MsgFrom(1)).Wait()
+	a.EqualError(err, "reactor comp/a: panic: boom")
+}
@@ -0,0 +1,187 @@
+package mqhub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ConnectionState is the state of a Connector
+type ConnectionState int
+
+// Connection states
+const (
+	// Disconnected is the initial state before connected
+	Disconnected ConnectionState = iota
+	// Connected indicates the connection is established
+	Connected
+	// ConnectionLost indicates the connection dropped unexpectedly
+	ConnectionLost
+	// Reconnecting indicates an attempt to reconnect is scheduled
+	Reconnecting
+	// Closed indicates the connector is closed
+	Closed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connected:
+		return "connected"
+	case ConnectionLost:
+		return "connection-lost"
+	case Reconnecting:
+		return "reconnecting"
+	case Closed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// ConnectionEvent is emitted when the state of a Connector changes,
+// it's a Message and always a state
+type ConnectionEvent struct {
+	State ConnectionState
+	// Error is the cause of ConnectionLost, or the failure of the
+	// previous attempt for Reconnecting
+	Error error
+	Time  time.Time
+}
+
+// Component implements Message
+func (e *ConnectionEvent) Component() string {
+	return ""
+}
+
+// Endpoint implements Message
+func (e *ConnectionEvent) Endpoint() string {
+	return ""
+}
+
+// Value implements Message
+func (e *ConnectionEvent) Value() (interface{}, bool) {
+	return e, true
+}
+
+// IsState implements Message
+func (e *ConnectionEvent) IsState() bool {
+	return true
+}
+
+// As implements Message, supports *ConnectionEvent and *ConnectionState
+func (e *ConnectionEvent) As(out interface{}) error {
+	switch v := out.(type) {
+	case *ConnectionEvent:
+		*v = *e
+	case *ConnectionState:
+		*v = e.State
+	default:
+		return fmt.Errorf("unable to convert ConnectionEvent to %T", out)
+	}
+	return nil
+}
+
+// LifecycleStream is a Watchable of ConnectionEvent, a new watcher
+// receives the current state first.
+// The events are queued for each watcher and delivered in order on a
+// goroutine of the watcher, sinks are never invoked by Watch or Emit, so a
+// blocking sink only holds back its own events
+type LifecycleStream struct {
+	current  *ConnectionEvent
+	watchers []*lifecycleWatcher
+	lock     sync.Mutex
+}
+
+type lifecycleWatcher struct {
+	stream  *LifecycleStream
+	sink    MessageSink
+	pending []*ConnectionEvent
+	// running is set while a goroutine is delivering the pending events
+	running bool
+	closed  bool
+}
+
+// NewLifecycleStream creates a LifecycleStream in Disconnected state
+func NewLifecycleStream() *LifecycleStream {
+	return &LifecycleStream{
+		current: &ConnectionEvent{State: Disconnected, Time: time.Now()},
+	}
+}
+
+// State returns the current state
+func (s *LifecycleStream) State() ConnectionState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.current.State
+}
+
+// Watch implements Watchable
+func (s *LifecycleStream) Watch(sink MessageSink) (Watcher, error) {
+	w := &lifecycleWatcher{stream: s, sink: sink}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.watchers = append(s.watchers, w)
+	w.queue(s.current)
+	return w, nil
+}
+
+// Emit changes the current state and queues the event for all watchers,
+// it can be invoked with the locks of the caller held to order the events
+func (s *LifecycleStream) Emit(state ConnectionState, err error) {
+	event := &ConnectionEvent{State: state, Error: err, Time: time.Now()}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current = event
+	for _, w := range s.watchers {
+		w.queue(event)
+	}
+}
+
+// queue appends the event and starts delivering if not yet, must be
+// invoked with the lock of the stream held
+func (w *lifecycleWatcher) queue(event *ConnectionEvent) {
+	w.pending = append(w.pending, event)
+	if !w.running {
+		w.running = true
+		go w.deliver()
+	}
+}
+
+// deliver consumes the pending events until none is left or closed
+func (w *lifecycleWatcher) deliver() {
+	s := w.stream
+	for {
+		s.lock.Lock()
+		if w.closed || len(w.pending) == 0 {
+			w.pending = nil
+			w.running = false
+			s.lock.Unlock()
+			return
+		}
+		event := w.pending[0]
+		w.pending = w.pending[1:]
+		s.lock.Unlock()
+		w.sink.ConsumeMessage(event)
+	}
+}
+
+// Close implements Watcher
+func (w *lifecycleWatcher) Close() error {
+	s := w.stream
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	w.closed = true
+	for i, x := range s.watchers {
+		if x == w {
+			s.watchers = append(s.watchers[:i], s.watchers[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Watched implements Watcher
+func (w *lifecycleWatcher) Watched() Watchable {
+	return w.stream
+}
@@ -0,0 +1,77 @@
+package mqhub_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycleBlockingSink(t *testing.T) {
+	a := assert.New(t)
+	stream := mqhub.NewLifecycleStream()
+	blocked, release := make(chan struct{}), make(chan struct{})
+	states := make(chan mqhub.ConnectionState, 4)
+	_, err := stream.Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var state mqhub.ConnectionState
+		a.NoError(msg.As(&state))
+		if state == mqhub.Connected {
+			close(blocked)
+			<-release
+		}
+		states <- state
+		return nil
+	}))
+	a.NoError(err)
+	a.Equal(mqhub.Disconnected, <-states)
+
+	go stream.Emit(mqhub.Connected, nil)
+	<-blocked
+	// the sink blocks the delivery, but neither the emitters nor new watchers
+	done := make(chan struct{})
+	go func() {
+		stream.Emit(mqhub.ConnectionLost, nil)
+		stream.Emit(mqhub.Closed, nil)
+		_, err := stream.Watch(mqhub.MessageSinkFunc(func(mqhub.Message) mqhub.Future { return nil }))
+		a.NoError(err)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		a.Fail("blocked by the sink")
+	}
+	close(release)
+	for _, expected := range []mqhub.ConnectionState{mqhub.Connected, mqhub.ConnectionLost, mqhub.Closed} {
+		select {
+		case state := <-states:
+			a.Equal(expected, state)
+		case <-time.After(3 * time.Second):
+			a.Fail("event not delivered")
+			return
+		}
+	}
+}
+
+func TestLifecycleChanMsgSink(t *testing.T) {
+	a := assert.New(t)
+	stream := mqhub.NewLifecycleStream()
+	sink := mqhub.NewChanMsgSink()
+	// the sink blocks until received, Watch and Emit must not wait for it
+	watcher, err := stream.Watch(sink)
+	a.NoError(err)
+	defer watcher.Close()
+	stream.Emit(mqhub.Connected, nil)
+	for _, expected := range []mqhub.ConnectionState{mqhub.Disconnected, mqhub.Connected} {
+		select {
+		case msg := <-sink.C:
+			var state mqhub.ConnectionState
+			a.NoError(msg.As(&state))
+			a.Equal(expected, state)
+		case <-time.After(3 * time.Second):
+			a.Fail("event not delivered")
+			return
+		}
+	}
+}
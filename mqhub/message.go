@@ -1,11 +1,19 @@
 package mqhub
 
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
 // OriginMsg wraps existing value
 type OriginMsg struct {
 	ComponentID  string
 	EndpointName string
 	V            interface{}
 	State        bool
+	Props        *Properties
+	Meta         *Metadata
 }
 
 // Component implements Message
@@ -28,8 +36,123 @@ func (m *OriginMsg) IsState() bool {
 	return m.State
 }
 
-// As implements Message
-func (m *OriginMsg) As(interface{}) error {
+// As implements Message, the value is assigned directly if the type is
+// compatible, otherwise converted by a round-trip through the codec
+// identified by the content type, or JSON
+func (m *OriginMsg) As(out interface{}) error {
+	codec := MessageCodec(m)
+	if codec == nil {
+		codec = JSON
+	}
+	return ConvertValue(m.V, out, codec)
+}
+
+// Properties implements PropertiesCarrier
+func (m *OriginMsg) Properties() *Properties {
+	return m.Props
+}
+
+// Metadata implements MetadataCarrier
+func (m *OriginMsg) Metadata() *Metadata {
+	return m.Meta
+}
+
+func (m *OriginMsg) props() *Properties {
+	if m.Props == nil {
+		m.Props = &Properties{}
+	}
+	return m.Props
+}
+
+// WithContentType sets the content type property
+func (m *OriginMsg) WithContentType(contentType string) *OriginMsg {
+	m.props().ContentType = contentType
+	return m
+}
+
+// WithCodec selects the codec by setting the content type property
+func (m *OriginMsg) WithCodec(codec Codec) *OriginMsg {
+	return m.WithContentType(codec.ContentType())
+}
+
+// WithExpiry sets the lifetime of the message
+func (m *OriginMsg) WithExpiry(expiry time.Duration) *OriginMsg {
+	m.props().Expiry = expiry
+	return m
+}
+
+// WithMetadata sets the metadata
+func (m *OriginMsg) WithMetadata(meta *Metadata) *OriginMsg {
+	m.Meta = meta
+	return m
+}
+
+// WithProperty adds a user defined property
+func (m *OriginMsg) WithProperty(key, value string) *OriginMsg {
+	props := m.props()
+	if props.User == nil {
+		props.User = make(map[string]string)
+	}
+	props.User[key] = value
+	return m
+}
+
+// TypeMismatchError is reported when a value can't be converted
+type TypeMismatchError struct {
+	From reflect.Type
+	To   reflect.Type
+	// Err is the error from the codec, if any
+	Err error
+}
+
+// Error implements error
+func (e *TypeMismatchError) Error() string {
+	msg := fmt.Sprintf("unable to convert %v to %v", e.From, e.To)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the error from the codec
+func (e *TypeMismatchError) Unwrap() error {
+	return e.Err
+}
+
+// ConvertValue stores v into the value pointed to by out, directly if
+// the types are compatible, otherwise by encoding v and decoding into out
+// using codec, a nil v leaves out untouched
+func ConvertValue(v interface{}, out interface{}, codec Codec) error {
+	target := reflect.ValueOf(out)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("non-nil pointer required, got %T", out)
+	}
+	if v == nil {
+		return nil
+	}
+	target = target.Elem()
+	val := reflect.ValueOf(v)
+	if val.Type().AssignableTo(target.Type()) {
+		target.Set(val)
+		return nil
+	}
+	if val.Kind() == reflect.Ptr && !val.IsNil() && val.Elem().Type().AssignableTo(target.Type()) {
+		target.Set(val.Elem())
+		return nil
+	}
+	var data []byte
+	var err error
+	if p, ok := v.(EncodedPayload); ok {
+		data, err = p.Payload()
+	} else {
+		data, err = codec.Encode(v)
+	}
+	if err == nil {
+		err = codec.Decode(data, out)
+	}
+	if err != nil {
+		return &TypeMismatchError{From: val.Type(), To: target.Type(), Err: err}
+	}
 	return nil
 }
 
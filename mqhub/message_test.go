@@ -0,0 +1,81 @@
+package mqhub_test
+
+import (
+	"testing"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type coord struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+func TestOriginMsgAs(t *testing.T) {
+	a := assert.New(t)
+
+	// direct assignment
+	var p point
+	if a.NoError(mqhub.MsgFrom(point{X: 1, Y: 2}).As(&p)) {
+		a.Equal(point{X: 1, Y: 2}, p)
+	}
+	p = point{}
+	if a.NoError(mqhub.MsgFrom(&point{X: 3}).As(&p)) {
+		a.Equal(point{X: 3}, p)
+	}
+	var v interface{}
+	if a.NoError(mqhub.MsgFrom(1).As(&v)) {
+		a.Equal(1, v)
+	}
+
+	// round-trip through codec
+	var c coord
+	if a.NoError(mqhub.MsgFrom(point{X: 1, Y: 2}).As(&c)) {
+		a.Equal(coord{X: 1, Y: 2}, c)
+	}
+	var n float64
+	if a.NoError(mqhub.MsgFrom(5).As(&n)) {
+		a.Equal(5.0, n)
+	}
+	var b []byte
+	if a.NoError(mqhub.MsgFrom("raw").WithCodec(mqhub.Raw).As(&b)) {
+		a.Equal([]byte("raw"), b)
+	}
+
+	// mismatch
+	err := mqhub.MsgFrom("str").As(&n)
+	if a.IsType(&mqhub.TypeMismatchError{}, err) {
+		a.Contains(err.Error(), "unable to convert string to float64")
+	}
+	a.Error(mqhub.MsgFrom(1).As(n))
+}
+
+func TestReactorAsLocal(t *testing.T) {
+	a := assert.New(t)
+	var received point
+	reactor := mqhub.ReactorAs("move", func(p point) {
+		received = p
+	})
+	dp := mqhub.NewDataPoint("pos")
+	dp.SinkMessage(reactor)
+	a.NoError(dp.Update(point{X: 4, Y: 5}).Wait())
+	a.Equal(point{X: 4, Y: 5}, received)
+
+	reply, ok := mqhub.ReactorAs("double", func(n int) (int, error) {
+		return n * 2, nil
+	}).ConsumeMessage(mqhub.MsgFrom(int64(21))).(*mqhub.Reply)
+	if a.True(ok) && a.NoError(reply.Wait()) {
+		a.Equal(42, reply.Value)
+	}
+	// a single value is not a reply
+	_, ok = mqhub.ReactorAs("double", func(n int) int {
+		return n * 2
+	}).ConsumeMessage(mqhub.MsgFrom(int64(21))).(*mqhub.Reply)
+	a.False(ok)
+}
@@ -0,0 +1,29 @@
+package mqhub
+
+import "time"
+
+// Metadata describes the origin of a message, consumers can detect gaps
+// and reordering using Sequence
+type Metadata struct {
+	// Timestamp is when the message is produced
+	Timestamp time.Time `json:"ts"`
+	// Sequence is monotonic per endpoint of the producer starting from 1,
+	// zero means not sequenced
+	Sequence uint64 `json:"seq,omitempty"`
+	// Source is the client ID of the producer
+	Source string `json:"src,omitempty"`
+}
+
+// MetadataCarrier is implemented by messages with Metadata
+type MetadataCarrier interface {
+	// Metadata returns nil if the message has no metadata
+	Metadata() *Metadata
+}
+
+// MetadataOf returns the metadata of the message, nil if not present
+func MetadataOf(msg Message) *Metadata {
+	if carrier, ok := msg.(MetadataCarrier); ok {
+		return carrier.Metadata()
+	}
+	return nil
+}
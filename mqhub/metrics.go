@@ -0,0 +1,15 @@
+package mqhub
+
+// Labels identifies a time series of a metric
+type Labels map[string]string
+
+// Metrics receives the measurements of a connector, the implementation
+// exports them to a monitoring system, and must be safe for concurrent use
+type Metrics interface {
+	// Count adds delta to the counter
+	Count(name string, labels Labels, delta float64)
+	// Observe records the value in the histogram
+	Observe(name string, labels Labels, value float64)
+	// Gauge sets the current value of the gauge
+	Gauge(name string, labels Labels, value float64)
+}
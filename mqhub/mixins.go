@@ -1,5 +1,7 @@
 package mqhub
 
+import "sync"
+
 // IdentityImpl implements Identity
 type IdentityImpl struct {
 	id string
@@ -20,24 +22,154 @@ type ComponentBase struct {
 	IdentityImpl
 }
 
-// CompositeBase implements Composite + Composer
+// CompositeBase implements Composite + Composer, and DynamicComponent
+// so components added or removed after being published are applied
 type CompositeBase struct {
 	components []Component
+	observers  []CompositionObserver
+	lock       sync.RWMutex
 }
 
 // Components implements Composite
 func (c *CompositeBase) Components() []Component {
-	return c.components
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]Component(nil), c.components...)
 }
 
 // AddComponent implements Composer
 func (c *CompositeBase) AddComponent(comp Component) {
-	c.components = append(c.components, comp)
+	c.AttachComponent(comp)
 }
 
 // AddComponents add components
 func (c *CompositeBase) AddComponents(comps ...Component) {
-	c.components = append(c.components, comps...)
+	for _, comp := range comps {
+		c.AttachComponent(comp)
+	}
+}
+
+// AttachComponent adds the component, the Future completes when it's
+// published if the composite is published
+func (c *CompositeBase) AttachComponent(comp Component) Future {
+	c.lock.Lock()
+	c.components = append(c.components, comp)
+	observers := c.observers
+	c.lock.Unlock()
+	return notifyObservers(observers, func(observer CompositionObserver) Future {
+		return observer.ComponentAdded(comp)
+	})
+}
+
+// RemoveComponent removes the component by ID, the Future completes when
+// it's unpublished if the composite is published
+func (c *CompositeBase) RemoveComponent(id string) Future {
+	c.lock.Lock()
+	var removed Component
+	for i, comp := range c.components {
+		if comp.ID() == id {
+			removed = comp
+			c.components = append(c.components[:i], c.components[i+1:]...)
+			break
+		}
+	}
+	observers := c.observers
+	c.lock.Unlock()
+	if removed == nil {
+		return &ImmediateFuture{}
+	}
+	return notifyObservers(observers, func(observer CompositionObserver) Future {
+		return observer.ComponentRemoved(removed)
+	})
+}
+
+// ObserveComposition implements DynamicComponent
+func (c *CompositeBase) ObserveComposition(observer CompositionObserver) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, o := range c.observers {
+		if o == observer {
+			return
+		}
+	}
+	// copied on write, so the observers are notified without the lock
+	c.observers = append(c.observers[:len(c.observers):len(c.observers)], observer)
+}
+
+// UnobserveComposition implements DynamicComponent
+func (c *CompositeBase) UnobserveComposition(observer CompositionObserver) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, o := range c.observers {
+		if o == observer {
+			c.observers = append(c.observers[:i:i], c.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// notifyObservers notifies all the observers, the Future completes when
+// all of them complete
+func notifyObservers(observers []CompositionObserver, notify func(CompositionObserver) Future) Future {
+	if len(observers) == 0 {
+		return &ImmediateFuture{}
+	}
+	futures := make(Futures, 0, len(observers))
+	for _, observer := range observers {
+		if future := notify(observer); future != nil {
+			futures = append(futures, future)
+		}
+	}
+	return futures
+}
+
+// DynamicBase implements a Component with endpoints and sub-components
+// which can be added or removed after being published
+type DynamicBase struct {
+	ComponentBase
+	CompositeBase
+	endpoints []Endpoint
+}
+
+// Endpoints implements Component
+func (c *DynamicBase) Endpoints() []Endpoint {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]Endpoint(nil), c.endpoints...)
+}
+
+// AddEndpoint adds the endpoint, the Future completes when it's
+// published if the component is published
+func (c *DynamicBase) AddEndpoint(endpoint Endpoint) Future {
+	c.lock.Lock()
+	c.endpoints = append(c.endpoints, endpoint)
+	observers := c.observers
+	c.lock.Unlock()
+	return notifyObservers(observers, func(observer CompositionObserver) Future {
+		return observer.EndpointAdded(endpoint)
+	})
+}
+
+// RemoveEndpoint removes the endpoint by ID, the Future completes when
+// it's unpublished if the component is published
+func (c *DynamicBase) RemoveEndpoint(id string) Future {
+	c.lock.Lock()
+	var removed Endpoint
+	for i, endpoint := range c.endpoints {
+		if endpoint.ID() == id {
+			removed = endpoint
+			c.endpoints = append(c.endpoints[:i], c.endpoints[i+1:]...)
+			break
+		}
+	}
+	observers := c.observers
+	c.lock.Unlock()
+	if removed == nil {
+		return &ImmediateFuture{}
+	}
+	return notifyObservers(observers, func(observer CompositionObserver) Future {
+		return observer.EndpointRemoved(removed)
+	})
 }
 
 // ChanMsgSink is a MessageSink and emits message to a chan
@@ -0,0 +1,50 @@
+package mqhub
+
+import "fmt"
+
+// PresenceEndpoint is the name of the endpoint reporting the presence
+// of a published component
+const PresenceEndpoint = "$online"
+
+// Presence is the online state of a published component
+type Presence struct {
+	// ComponentID is the component the presence refers to
+	ComponentID string `json:"-"`
+	Online      bool   `json:"online"`
+	// Host identifies the connector publishing the component, the
+	// component is offline if the host goes offline
+	Host string `json:"host,omitempty"`
+}
+
+// Component implements Message
+func (p *Presence) Component() string {
+	return p.ComponentID
+}
+
+// Endpoint implements Message
+func (p *Presence) Endpoint() string {
+	return PresenceEndpoint
+}
+
+// Value implements Message
+func (p *Presence) Value() (interface{}, bool) {
+	return p, true
+}
+
+// IsState implements Message
+func (p *Presence) IsState() bool {
+	return true
+}
+
+// As implements Message, supports *Presence and *bool
+func (p *Presence) As(out interface{}) error {
+	switch v := out.(type) {
+	case *Presence:
+		*v = *p
+	case *bool:
+		*v = p.Online
+	default:
+		return fmt.Errorf("unable to convert Presence to %T", out)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package mqhub
+
+import "fmt"
+
+// QoS defines the delivery guarantee of messages
+type QoS int
+
+// Delivery guarantees
+const (
+	// DefaultQoS uses the default delivery guarantee of the connector
+	DefaultQoS QoS = iota
+	// AtMostOnce delivers message without acknowledgement
+	AtMostOnce
+	// AtLeastOnce delivers message until acknowledged, duplicates are possible
+	AtLeastOnce
+	// ExactlyOnce delivers message exactly once
+	ExactlyOnce
+)
+
+var qosNames = map[QoS]string{
+	DefaultQoS:  "default",
+	AtMostOnce:  "at-most-once",
+	AtLeastOnce: "at-least-once",
+	ExactlyOnce: "exactly-once",
+}
+
+// String returns the name of QoS
+func (q QoS) String() string {
+	if name, ok := qosNames[q]; ok {
+		return name
+	}
+	return fmt.Sprintf("QoS(%d)", int(q))
+}
+
+// ParseQoS parses QoS from a name (e.g. at-least-once) or MQTT QoS level (0, 1, 2)
+func ParseQoS(str string) (QoS, error) {
+	switch str {
+	case "0":
+		return AtMostOnce, nil
+	case "1":
+		return AtLeastOnce, nil
+	case "2":
+		return ExactlyOnce, nil
+	}
+	for qos, name := range qosNames {
+		if name == str {
+			return qos, nil
+		}
+	}
+	return DefaultQoS, fmt.Errorf("invalid QoS: %s", str)
+}
+
+// QoSEndpoint is an Endpoint specifying delivery guarantee
+type QoSEndpoint interface {
+	EndpointQoS() QoS
+}
+
+// EndpointQoS returns the delivery guarantee of the endpoint,
+// DefaultQoS if not specified
+func EndpointQoS(endpoint Endpoint) QoS {
+	if e, ok := endpoint.(QoSEndpoint); ok {
+		return e.EndpointQoS()
+	}
+	return DefaultQoS
+}
@@ -0,0 +1,239 @@
+package mqhub
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TagName is the struct tag recognized by ComponentOf
+const TagName = "mqhub"
+
+// endpointTag is the parsed struct tag of a field
+//
+//	mqhub:"name[,retain][,qos=<qos>][,codec=<codec>][,handler=<method>][,share=<group>]"
+type endpointTag struct {
+	name    string
+	retain  bool
+	qos     QoS
+	codec   Codec
+	handler string
+	share   string
+}
+
+func parseEndpointTag(field reflect.StructField) (*endpointTag, error) {
+	tag, ok := field.Tag.Lookup(TagName)
+	if !ok || tag == "-" {
+		return nil, nil
+	}
+	opts := strings.Split(tag, ",")
+	t := &endpointTag{name: opts[0]}
+	if t.name == "" {
+		t.name = field.Name
+	}
+	for _, opt := range opts[1:] {
+		key, val := opt, ""
+		if pos := strings.Index(opt, "="); pos >= 0 {
+			key, val = opt[:pos], opt[pos+1:]
+		}
+		switch key {
+		case "retain":
+			t.retain = true
+		case "qos":
+			qos, err := ParseQoS(val)
+			if err != nil {
+				return nil, err
+			}
+			t.qos = qos
+		case "codec":
+			if t.codec = LookupCodec(val); t.codec == nil {
+				return nil, fmt.Errorf("unknown codec %s", val)
+			}
+		case "handler":
+			t.handler = val
+		case "share":
+			t.share = val
+		default:
+			return nil, fmt.Errorf("unknown option %s", key)
+		}
+	}
+	return t, nil
+}
+
+// taggedEndpoint is an endpoint configurable by struct tag
+type taggedEndpoint interface {
+	applyTag(*endpointTag)
+}
+
+// reactorEndpoint is an endpoint embedding Reactor, whose handler can be
+// bound to a method by struct tag
+type reactorEndpoint interface {
+	reactor() *Reactor
+	bindHandler(method reflect.Value) error
+}
+
+func (a *Reactor) reactor() *Reactor {
+	return a
+}
+
+func (a *Reactor) bindHandler(method reflect.Value) error {
+	a.Handler = MessageSinkAs(method.Interface())
+	if method.Type().NumIn() > 0 {
+		a.payloadType = method.Type().In(0)
+	}
+	return nil
+}
+
+func (p *DataPoint) applyTag(t *endpointTag) {
+	if p.Name == "" {
+		p.Name = t.name
+	}
+	p.Retain = p.Retain || t.retain
+	if t.qos != DefaultQoS {
+		p.QoS = t.qos
+	}
+	if t.codec != nil {
+		p.Codec = t.codec
+	}
+}
+
+func (a *Reactor) applyTag(t *endpointTag) {
+	if a.Name == "" {
+		a.Name = t.name
+	}
+	if t.qos != DefaultQoS {
+		a.QoS = t.qos
+	}
+	if t.codec != nil {
+		a.Codec = t.codec
+	}
+	if t.share != "" {
+		a.ShareGroup = t.share
+	}
+}
+
+// reflectedComponent is the Component built by ComponentOf
+type reflectedComponent struct {
+	id         string
+	endpoints  []Endpoint
+	components []Component
+}
+
+// ID implements Component
+func (c *reflectedComponent) ID() string {
+	return c.id
+}
+
+// Endpoints implements Component
+func (c *reflectedComponent) Endpoints() []Endpoint {
+	return c.endpoints
+}
+
+// Components implements Composite
+func (c *reflectedComponent) Components() []Component {
+	return c.components
+}
+
+var (
+	endpointType  = reflect.TypeOf((*Endpoint)(nil)).Elem()
+	componentType = reflect.TypeOf((*Component)(nil)).Elem()
+)
+
+// ComponentOf builds a Component from the struct pointed to by ptr, the
+// ID is from Identity if implemented, otherwise the name of the struct.
+// Only the fields with tag mqhub:"name,options..." are used:
+//
+//   - endpoints (e.g. DataPoint, Reactor, TypedDataPoint, either values or
+//     pointers) are configured by the options, nil pointers are allocated,
+//     options are retain, qos=<qos>, codec=<codec> and share=<group>, and
+//     handler=<method> binds a method of ptr as the handler of a Reactor
+//     using MessageSinkAs, or a TypedReactor if the method accepts the type
+//   - funcs are reactors using MessageSinkAs
+//   - structs or pointers to structs are sub-components with the tag name
+//     as ID, walked in the same way unless they implement Component
+func ComponentOf(ptr interface{}) (Component, error) {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pointer to struct required, got %T", ptr)
+	}
+	id := v.Elem().Type().Name()
+	if identity, ok := ptr.(Identity); ok && identity.ID() != "" {
+		id = identity.ID()
+	}
+	return componentOf(id, v)
+}
+
+func componentOf(id string, v reflect.Value) (*reflectedComponent, error) {
+	comp := &reflectedComponent{id: id}
+	st := v.Elem().Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		tag, err := parseEndpointTag(field)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", st.Name(), field.Name, err)
+		}
+		if tag == nil {
+			continue
+		}
+		if field.PkgPath != "" {
+			return nil, fmt.Errorf("%s.%s: unexported field", st.Name(), field.Name)
+		}
+		if err = comp.addField(v, v.Elem().Field(i), tag); err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", st.Name(), field.Name, err)
+		}
+	}
+	return comp, nil
+}
+
+func (c *reflectedComponent) addField(owner, fv reflect.Value, tag *endpointTag) error {
+	ptr := fv
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() && fv.Type().Elem().Kind() == reflect.Struct {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+	} else {
+		ptr = fv.Addr()
+	}
+
+	switch {
+	case ptr.Type().Implements(endpointType):
+		endpoint := ptr.Interface().(Endpoint)
+		if tagged, ok := endpoint.(taggedEndpoint); ok {
+			tagged.applyTag(tag)
+		}
+		reactor, isReactor := endpoint.(reactorEndpoint)
+		if tag.handler != "" {
+			if !isReactor {
+				return fmt.Errorf("handler is only supported by reactors")
+			}
+			method := owner.MethodByName(tag.handler)
+			if !method.IsValid() {
+				return fmt.Errorf("method %s not found", tag.handler)
+			}
+			if err := reactor.bindHandler(method); err != nil {
+				return fmt.Errorf("handler %s: %v", tag.handler, err)
+			}
+		}
+		if isReactor && reactor.reactor().Handler == nil {
+			return fmt.Errorf("reactor without handler")
+		}
+		c.endpoints = append(c.endpoints, endpoint)
+	case fv.Kind() == reflect.Func:
+		if fv.IsNil() {
+			return fmt.Errorf("nil func")
+		}
+		reactor := ReactorAs(tag.name, fv.Interface()).WithQoS(tag.qos).WithCodec(tag.codec).WithShareGroup(tag.share)
+		c.endpoints = append(c.endpoints, reactor)
+	case ptr.Type().Implements(componentType):
+		c.components = append(c.components, ptr.Interface().(Component))
+	case ptr.Type().Elem().Kind() == reflect.Struct:
+		sub, err := componentOf(tag.name, ptr)
+		if err != nil {
+			return err
+		}
+		c.components = append(c.components, sub)
+	default:
+		return fmt.Errorf("unsupported type %v", fv.Type())
+	}
+	return nil
+}
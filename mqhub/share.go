@@ -0,0 +1,16 @@
+package mqhub
+
+// SharedEndpoint is a reactor subscribed in a share group, each message
+// is handled by only one of the replicas subscribed in the same group
+type SharedEndpoint interface {
+	EndpointShareGroup() string
+}
+
+// EndpointShareGroup returns the share group of the endpoint, empty if
+// not shared
+func EndpointShareGroup(endpoint Endpoint) string {
+	if e, ok := endpoint.(SharedEndpoint); ok {
+		return e.EndpointShareGroup()
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package mqhub
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// typedValue keeps the current value of type T
+type typedValue[T any] struct {
+	value T
+	valid bool
+	lock  sync.RWMutex
+}
+
+func (v *typedValue[T]) set(value T) {
+	v.lock.Lock()
+	v.value, v.valid = value, true
+	v.lock.Unlock()
+}
+
+// Value returns the current value, false if there's none yet
+func (v *typedValue[T]) Value() (T, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.value, v.valid
+}
+
+func (v *typedValue[T]) valueType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// TypedDataPoint is a DataPoint with updates of type T
+type TypedDataPoint[T any] struct {
+	DataPoint
+	typedValue[T]
+}
+
+// NewTypedDataPoint creates a new typed datapoint
+func NewTypedDataPoint[T any](name string) *TypedDataPoint[T] {
+	return &TypedDataPoint[T]{DataPoint: DataPoint{Name: name}}
+}
+
+// NewRetainTypedDataPoint creates a new typed retain datapoint
+func NewRetainTypedDataPoint[T any](name string) *TypedDataPoint[T] {
+	return &TypedDataPoint[T]{DataPoint: DataPoint{Name: name, Retain: true}}
+}
+
+// WithQoS sets the delivery guarantee
+func (p *TypedDataPoint[T]) WithQoS(qos QoS) *TypedDataPoint[T] {
+	p.DataPoint.WithQoS(qos)
+	return p
+}
+
+// WithCodec sets the codec encoding the updates
+func (p *TypedDataPoint[T]) WithCodec(codec Codec) *TypedDataPoint[T] {
+	p.DataPoint.WithCodec(codec)
+	return p
+}
+
+// EndpointPayloadType implements PayloadEndpoint
+func (p *TypedDataPoint[T]) EndpointPayloadType() reflect.Type {
+	return p.valueType()
+}
+
+// Update keeps the value as current and emits it
+func (p *TypedDataPoint[T]) Update(value T) Future {
+	p.set(value)
+	return p.DataPoint.Update(MakeMsg(value, p.Retain))
+}
+
+// TypedReactor is a Reactor handling messages of type T, the last
+// handled value is kept as current
+type TypedReactor[T any] struct {
+	Reactor
+	typedValue[T]
+}
+
+// NewTypedReactor creates a typed reactor
+func NewTypedReactor[T any](name string, handler func(T) error) *TypedReactor[T] {
+	return newTypedReactor(name, func(value T) Future {
+		return &ImmediateFuture{Error: handler(value)}
+	})
+}
+
+// NewTypedCallReactor creates a typed reactor replying results of
+// type R, which can be invoked using EndpointRef.Call
+func NewTypedCallReactor[T, R any](name string, handler func(T) (R, error)) *TypedReactor[T] {
+	return newTypedReactor(name, func(value T) Future {
+		result, err := handler(value)
+		return &Reply{Value: result, Error: err}
+	})
+}
+
+func newTypedReactor[T any](name string, handler func(T) Future) *TypedReactor[T] {
+	r := &TypedReactor[T]{Reactor: Reactor{Name: name}}
+	r.handle(handler)
+	return r
+}
+
+// handle sets the handler of decoded values
+func (r *TypedReactor[T]) handle(handler func(T) Future) {
+	r.Handler = MessageSinkFunc(func(msg Message) Future {
+		var value T
+		if err := msg.As(&value); err != nil {
+			return &ImmediateFuture{Error: &DecodeError{Err: err}}
+		}
+		r.set(value)
+		return handler(value)
+	})
+}
+
+// bindHandler binds the method accepting T, the results are converted
+// as MessageSinkAs does
+func (r *TypedReactor[T]) bindHandler(method reflect.Value) error {
+	t := method.Type()
+	if t.NumIn() != 1 || t.In(0) != r.valueType() {
+		return fmt.Errorf("method must accept %v", r.valueType())
+	}
+	results := makeReply(t)
+	r.handle(func(value T) Future {
+		return results(method.Call([]reflect.Value{reflect.ValueOf(&value).Elem()}))
+	})
+	return nil
+}
+
+// EndpointPayloadType implements PayloadEndpoint
+func (r *TypedReactor[T]) EndpointPayloadType() reflect.Type {
+	return r.valueType()
+}
+
+// WithQoS sets the delivery guarantee
+func (r *TypedReactor[T]) WithQoS(qos QoS) *TypedReactor[T] {
+	r.Reactor.WithQoS(qos)
+	return r
+}
+
+// WithCodec sets the codec decoding the messages
+func (r *TypedReactor[T]) WithCodec(codec Codec) *TypedReactor[T] {
+	r.Reactor.WithCodec(codec)
+	return r
+}
+
+// WithShareGroup subscribes the reactor in the share group
+func (r *TypedReactor[T]) WithShareGroup(group string) *TypedReactor[T] {
+	r.Reactor.WithShareGroup(group)
+	return r
+}
+
+// TypedEndpointRef is an EndpointRef exchanging values of type T,
+// the last watched value is kept as current
+type TypedEndpointRef[T any] struct {
+	EndpointRef
+	typedValue[T]
+}
+
+// TypedRef creates a typed reference to the endpoint
+func TypedRef[T any](ref EndpointRef) *TypedEndpointRef[T] {
+	return &TypedEndpointRef[T]{EndpointRef: ref}
+}
+
+// Update sends the value to the endpoint
+func (r *TypedEndpointRef[T]) Update(value T) Future {
+	return r.ConsumeMessage(MsgFrom(value))
+}
+
+// WatchValue watches the endpoint, messages not decoded as T are
+// dropped
+func (r *TypedEndpointRef[T]) WatchValue(handler func(T)) (Watcher, error) {
+	return r.Watch(MessageSinkFunc(func(msg Message) Future {
+		var value T
+		if err := msg.As(&value); err != nil {
+			return &ImmediateFuture{Error: &DecodeError{Err: err}}
+		}
+		r.set(value)
+		if handler != nil {
+			handler(value)
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,342 @@
+// Package broker implements a lightweight embedded MQTT 3.1/3.1.1/5 broker
+// It supports retained messages, wildcard and shared subscriptions, QoS 0/1 delivery
+// (QoS 2 publishes are accepted, subscriptions are granted at most QoS 1),
+// persistent sessions and will messages.
+// For MQTT v5 clients, properties are forwarded, message expiry, topic aliases
+// from clients, subscription options and identifiers are supported, session expiry is
+// only used to decide whether the session persists after disconnection.
+// It's mainly used for testing and single-host deployments.
+package broker
+
+import (
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+	"github.com/robotalks/mqhub.go/mqtt/topics"
+	"github.com/robotalks/mqhub.go/utils"
+)
+
+// MaxQoS is the maximum QoS granted to subscriptions
+const MaxQoS byte = 1
+
+// TopicAliasMaximum is the number of topic aliases accepted from
+// each MQTT v5 client
+const TopicAliasMaximum uint16 = 64
+
+// ConnectTimeout is the time allowed between accepting a connection and
+// receiving CONNECT
+var ConnectTimeout = 10 * time.Second
+
+// Broker is an embedded MQTT broker
+type Broker struct {
+	listener net.Listener
+	tls      bool
+	sessions map[string]*session
+	retained map[string]*message
+	conns    map[*clientConn]struct{}
+	lock     sync.RWMutex
+	wg       sync.WaitGroup
+
+	// shares counts the messages delivered to shared subscriptions
+	// by the filters, to pick the sessions in turn
+	shares map[string]uint64
+}
+
+// New creates a broker
+func New() *Broker {
+	return &Broker{
+		sessions: make(map[string]*session),
+		retained: make(map[string]*message),
+		conns:    make(map[*clientConn]struct{}),
+		shares:   make(map[string]uint64),
+	}
+}
+
+// Listen starts listening on the TCP address and serving in background
+// use "127.0.0.1:0" to pick a random loopback port
+func (b *Broker) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	b.serveListener(l, false)
+	return nil
+}
+
+// ListenTLS is same as Listen but accepts TLS connections
+func (b *Broker) ListenTLS(addr string, config *tls.Config) error {
+	l, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	b.serveListener(l, true)
+	return nil
+}
+
+func (b *Broker) serveListener(l net.Listener, useTLS bool) {
+	b.lock.Lock()
+	b.listener = l
+	b.tls = useTLS
+	b.lock.Unlock()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.Serve(l)
+	}()
+}
+
+// Serve accepts connections on the listener until it's closed
+func (b *Broker) Serve(l net.Listener) error {
+	b.lock.Lock()
+	b.listener = l
+	b.lock.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serveConn(conn)
+		}()
+	}
+}
+
+// Addr returns the listening address, nil if not listening
+func (b *Broker) Addr() net.Addr {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if b.listener == nil {
+		return nil
+	}
+	return b.listener.Addr()
+}
+
+// URL returns the URL can be used by clients to connect, empty if not listening
+func (b *Broker) URL() string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if b.listener == nil {
+		return ""
+	}
+	if b.tls {
+		return "ssl://" + b.listener.Addr().String()
+	}
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Close stops listening and disconnects all clients
+func (b *Broker) Close() error {
+	b.lock.Lock()
+	l := b.listener
+	conns := make([]*clientConn, 0, len(b.conns))
+	for conn := range b.conns {
+		conns = append(conns, conn)
+	}
+	b.lock.Unlock()
+	var err error
+	if l != nil {
+		err = l.Close()
+	}
+	for _, conn := range conns {
+		conn.close()
+	}
+	b.wg.Wait()
+	return err
+}
+
+// DisconnectClient drops the connection of the client, without DISCONNECT,
+// so will message is published. It returns false if the client is not connected
+func (b *Broker) DisconnectClient(clientID string) bool {
+	b.lock.RLock()
+	s := b.sessions[clientID]
+	b.lock.RUnlock()
+	if s == nil {
+		return false
+	}
+	conn := s.connection()
+	if conn == nil {
+		return false
+	}
+	conn.close()
+	return true
+}
+
+// Retained returns the retained message on the topic, nil if not present
+// or already expired
+func (b *Broker) Retained(topic string) *packets.Publish {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if msg := b.retained[topic]; msg != nil && !msg.expired(time.Now()) {
+		return msg.pkt
+	}
+	return nil
+}
+
+func (b *Broker) serveConn(conn net.Conn) {
+	c := newClientConn(b, conn)
+	b.lock.Lock()
+	b.conns[c] = struct{}{}
+	b.lock.Unlock()
+	c.serve()
+	b.lock.Lock()
+	delete(b.conns, c)
+	b.lock.Unlock()
+}
+
+// attach binds the connection to a session, the existing connection of the
+// same client is taken over
+func (b *Broker) attach(c *clientConn, pkt *packets.Connect) (s *session, present bool) {
+	clientID := pkt.ClientID
+	if clientID == "" {
+		clientID = utils.UniqueID()
+	}
+	// in MQTT v5, CleanSession is Clean Start, and the session only
+	// persists after disconnection if session expiry interval is specified
+	clean := pkt.CleanSession
+	if pkt.ProtocolLevel >= packets.ProtocolV5 {
+		clean = pkt.Properties == nil || pkt.Properties.SessionExpiry == nil ||
+			*pkt.Properties.SessionExpiry == 0
+	}
+	b.lock.Lock()
+	prevSession := b.sessions[clientID]
+	if prevSession != nil && !pkt.CleanSession {
+		s, present = prevSession, true
+		s.clean = clean
+	} else {
+		s = newSession(b, clientID, clean)
+		b.sessions[clientID] = s
+	}
+	b.lock.Unlock()
+
+	if prevSession != nil && prevSession != s {
+		if prev := prevSession.connection(); prev != nil {
+			prev.close()
+		}
+	}
+	if prev := s.attach(c); prev != nil {
+		prev.close()
+	}
+	return
+}
+
+// detach unbinds the connection from session, and removes the session if it's clean
+func (b *Broker) detach(s *session, c *clientConn) {
+	if !s.detach(c) {
+		return
+	}
+	if s.clean {
+		b.lock.Lock()
+		if b.sessions[s.clientID] == s {
+			delete(b.sessions, s.clientID)
+		}
+		b.lock.Unlock()
+	}
+}
+
+func (b *Broker) subscribed(s *session, filter string) {
+	var matches []*message
+	now := time.Now()
+	b.lock.Lock()
+	for topic, msg := range b.retained {
+		if msg.expired(now) {
+			delete(b.retained, topic)
+		} else if topics.Matches(filter, topic) {
+			matches = append(matches, msg)
+		}
+	}
+	b.lock.Unlock()
+	for _, msg := range matches {
+		if qos, _, ids := s.subscriptionQoS(msg.pkt.Topic, nil); qos >= 0 {
+			s.deliver(msg, byte(qos), true, ids)
+		}
+	}
+}
+
+// publish forwards the message to matching subscriptions, from is the
+// session of the publisher, nil for will messages
+func (b *Broker) publish(pkt *packets.Publish, from *session) {
+	msg := newMessage(pkt)
+	b.lock.Lock()
+	if pkt.Retain {
+		if len(pkt.Payload) == 0 {
+			delete(b.retained, pkt.Topic)
+		} else {
+			b.retained[pkt.Topic] = msg
+		}
+	}
+	sessions := make([]*session, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.lock.Unlock()
+
+	shared := make(map[string][]sharedTarget)
+	for _, s := range sessions {
+		if qos, retainAsPublished, ids := s.subscriptionQoS(pkt.Topic, from); qos >= 0 {
+			s.deliver(msg, byte(qos), retainAsPublished && pkt.Retain, ids)
+		}
+		for filter, sub := range s.sharedSubscriptions(pkt.Topic) {
+			shared[filter] = append(shared[filter], sharedTarget{session: s, sub: sub})
+		}
+	}
+	for filter, targets := range shared {
+		target := b.pickShared(filter, targets)
+		var ids []int
+		if target.sub.id != 0 {
+			ids = []int{target.sub.id}
+		}
+		target.session.deliver(msg, target.sub.QoS, false, ids)
+	}
+}
+
+// sharedTarget is a session with a shared subscription matching a message
+type sharedTarget struct {
+	session *session
+	sub     subscription
+}
+
+// pickShared selects one of the sessions sharing the subscription in turn,
+// the connected sessions are preferred
+func (b *Broker) pickShared(filter string, targets []sharedTarget) sharedTarget {
+	connected := make([]sharedTarget, 0, len(targets))
+	for _, target := range targets {
+		if target.session.connection() != nil {
+			connected = append(connected, target)
+		}
+	}
+	if len(connected) > 0 {
+		targets = connected
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].session.clientID < targets[j].session.clientID
+	})
+	b.lock.Lock()
+	n := b.shares[filter]
+	b.shares[filter] = n + 1
+	b.lock.Unlock()
+	return targets[n%uint64(len(targets))]
+}
+
+// message is a published message with the time it expires
+type message struct {
+	pkt    *packets.Publish
+	expiry time.Time
+}
+
+func newMessage(pkt *packets.Publish) *message {
+	msg := &message{pkt: pkt}
+	if props := pkt.Properties; props != nil && props.MessageExpiry != nil {
+		msg.expiry = time.Now().Add(time.Duration(*props.MessageExpiry) * time.Second)
+	}
+	return msg
+}
+
+func (m *message) expired(now time.Time) bool {
+	return !m.expiry.IsZero() && !now.Before(m.expiry)
+}
@@ -0,0 +1,199 @@
+package broker_test
+
+import (
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+func startBroker(t *testing.T) *broker.Broker {
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func connect(t *testing.T, b *broker.Broker, id string, setup func(*paho.ClientOptions)) paho.Client {
+	opts := paho.NewClientOptions().AddBroker(b.URL()).SetClientID(id)
+	opts.SetAutoReconnect(false)
+	if setup != nil {
+		setup(opts)
+	}
+	client := paho.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	return client
+}
+
+func subscribe(t *testing.T, client paho.Client, filter string, qos byte) chan paho.Message {
+	ch := make(chan paho.Message, 16)
+	token := client.Subscribe(filter, qos, func(_ paho.Client, msg paho.Message) {
+		ch <- msg
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	return ch
+}
+
+func recv(t *testing.T, ch chan paho.Message) paho.Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+func expectNone(t *testing.T, ch chan paho.Message) {
+	select {
+	case msg := <-ch:
+		t.Errorf("unexpected message on %s", msg.Topic())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSharedSubscription(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+	pub := connect(t, b, "shared-pub", nil)
+	defer pub.Disconnect(0)
+	a.NoError(pub.Publish("shared/a", 1, true, "retained").Error())
+
+	sub1 := connect(t, b, "shared-sub1", nil)
+	defer sub1.Disconnect(0)
+	sub2 := connect(t, b, "shared-sub2", nil)
+	defer sub2.Disconnect(0)
+	ch1 := subscribe(t, sub1, "$share/g/shared/+", 1)
+	ch2 := subscribe(t, sub2, "$share/g/shared/+", 1)
+	// retained messages are not sent to shared subscriptions
+	expectNone(t, ch1)
+	expectNone(t, ch2)
+
+	for i := 0; i < 4; i++ {
+		token := pub.Publish("shared/a", 1, false, "msg")
+		token.Wait()
+		a.NoError(token.Error())
+	}
+	count1, count2 := 0, 0
+	for i := 0; i < 4; i++ {
+		select {
+		case msg := <-ch1:
+			a.Equal("shared/a", msg.Topic())
+			count1++
+		case msg := <-ch2:
+			a.Equal("shared/a", msg.Topic())
+			count2++
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	a.Equal(2, count1)
+	a.Equal(2, count2)
+	expectNone(t, ch1)
+	expectNone(t, ch2)
+}
+
+func TestRetained(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	pub := connect(t, b, "pub", nil)
+	defer pub.Disconnect(0)
+	a.NoError(waitToken(pub.Publish("r/a", 1, true, "1")))
+	a.NoError(waitToken(pub.Publish("r/b", 1, true, "2")))
+
+	sub := connect(t, b, "sub", nil)
+	defer sub.Disconnect(0)
+	ch := subscribe(t, sub, "r/+", 1)
+	received := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		msg := recv(t, ch)
+		a.True(msg.Retained())
+		received[msg.Topic()] = string(msg.Payload())
+	}
+	a.Equal(map[string]string{"r/a": "1", "r/b": "2"}, received)
+
+	// live messages are forwarded without retain flag
+	a.NoError(waitToken(pub.Publish("r/a", 1, true, "3")))
+	msg := recv(t, ch)
+	a.False(msg.Retained())
+	a.Equal("3", string(msg.Payload()))
+
+	// empty payload clears retained message
+	a.NoError(waitToken(pub.Publish("r/b", 1, true, "")))
+	recv(t, ch)
+	a.Nil(b.Retained("r/b"))
+	a.NotNil(b.Retained("r/a"))
+}
+
+func TestWill(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	sub := connect(t, b, "sub", nil)
+	defer sub.Disconnect(0)
+	ch := subscribe(t, sub, "will/#", 1)
+
+	connect(t, b, "graceful", func(opts *paho.ClientOptions) {
+		opts.SetWill("will/graceful", "gone", 1, true)
+	}).Disconnect(250)
+	expectNone(t, ch)
+
+	connect(t, b, "dying", func(opts *paho.ClientOptions) {
+		opts.SetWill("will/dying", "gone", 1, true)
+	})
+	a.True(b.DisconnectClient("dying"))
+	msg := recv(t, ch)
+	a.Equal("will/dying", msg.Topic())
+	a.Equal("gone", string(msg.Payload()))
+	a.NotNil(b.Retained("will/dying"))
+}
+
+func TestPersistentSession(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	pub := connect(t, b, "pub", nil)
+	defer pub.Disconnect(0)
+
+	persistent := func(opts *paho.ClientOptions) {
+		opts.SetCleanSession(false)
+	}
+	sub := connect(t, b, "sub", persistent)
+	subscribe(t, sub, "p/#", 1)
+	sub.Disconnect(0)
+
+	// QoS 0 messages are not queued for offline sessions,
+	// the PUBACK of the latter ensures both are processed
+	a.NoError(waitToken(pub.Publish("p/b", 0, false, "dropped")))
+	a.NoError(waitToken(pub.Publish("p/a", 1, false, "queued")))
+
+	ch := make(chan paho.Message, 4)
+	sub = connect(t, b, "sub", func(opts *paho.ClientOptions) {
+		persistent(opts)
+		opts.SetDefaultPublishHandler(func(_ paho.Client, msg paho.Message) {
+			ch <- msg
+		})
+	})
+	defer sub.Disconnect(0)
+	msg := recv(t, ch)
+	a.Equal("p/a", msg.Topic())
+	a.Equal("queued", string(msg.Payload()))
+	expectNone(t, ch)
+}
+
+func waitToken(token paho.Token) error {
+	token.Wait()
+	return token.Error()
+}
@@ -0,0 +1,495 @@
+package broker
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+	"github.com/robotalks/mqhub.go/mqtt/topics"
+)
+
+// session keeps the state of a client across connections
+type session struct {
+	broker   *Broker
+	clientID string
+	clean    bool
+
+	conn     *clientConn
+	subs     map[string]subscription
+	inflight []*packets.Publish
+	incoming map[uint16]bool
+	lastID   uint16
+	lock     sync.Mutex
+}
+
+// subscription is a subscription with the identifier from SUBSCRIBE
+// properties, 0 if not specified
+type subscription struct {
+	packets.Subscription
+	id int
+}
+
+func newSession(b *Broker, clientID string, clean bool) *session {
+	return &session{
+		broker:   b,
+		clientID: clientID,
+		clean:    clean,
+		subs:     make(map[string]subscription),
+		incoming: make(map[uint16]bool),
+	}
+}
+
+func (s *session) connection() *clientConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn
+}
+
+// attach binds the connection and resends unacknowledged messages,
+// the previously bound connection is returned
+func (s *session) attach(c *clientConn) (prev *clientConn) {
+	s.lock.Lock()
+	prev, s.conn = s.conn, c
+	for _, pkt := range s.inflight {
+		pkt.Dup = true
+		c.send(pkt)
+	}
+	s.lock.Unlock()
+	return
+}
+
+func (s *session) detach(c *clientConn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != c {
+		return false
+	}
+	s.conn = nil
+	return true
+}
+
+// subscribe adds or replaces the subscription, and returns the granted QoS
+// and whether retained messages should be sent according to retain handling
+func (s *session) subscribe(sub packets.Subscription, id int) (byte, bool) {
+	if sub.QoS > MaxQoS {
+		sub.QoS = MaxQoS
+	}
+	s.lock.Lock()
+	_, exists := s.subs[sub.Filter]
+	s.subs[sub.Filter] = subscription{Subscription: sub, id: id}
+	s.lock.Unlock()
+	sendRetained := sub.RetainHandling == 0 || (sub.RetainHandling == 1 && !exists)
+	return sub.QoS, sendRetained
+}
+
+// unsubscribe removes the subscription, returns false if not subscribed
+func (s *session) unsubscribe(filter string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, exists := s.subs[filter]
+	delete(s.subs, filter)
+	return exists
+}
+
+// subscriptionQoS returns the maximum QoS of all subscriptions matching
+// the topic, or -1 if no subscription matches, whether the retain flag
+// should be kept, and the identifiers of the matching subscriptions.
+// Subscriptions with NoLocal ignore messages published by the same session
+func (s *session) subscriptionQoS(topic string, from *session) (qos int, retainAsPublished bool, ids []int) {
+	qos = -1
+	s.lock.Lock()
+	for filter, sub := range s.subs {
+		if _, _, shared := topics.SplitShared(filter); shared {
+			continue
+		}
+		if sub.NoLocal && from == s || !topics.Matches(filter, topic) {
+			continue
+		}
+		if int(sub.QoS) > qos {
+			qos = int(sub.QoS)
+		}
+		retainAsPublished = retainAsPublished || sub.RetainAsPublished
+		if sub.id != 0 {
+			ids = append(ids, sub.id)
+		}
+	}
+	s.lock.Unlock()
+	return
+}
+
+// sharedSubscriptions returns the shared subscriptions matching the topic
+// by the filters
+func (s *session) sharedSubscriptions(topic string) map[string]subscription {
+	var matches map[string]subscription
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for filter, sub := range s.subs {
+		if _, _, shared := topics.SplitShared(filter); shared && topics.Matches(filter, topic) {
+			if matches == nil {
+				matches = make(map[string]subscription)
+			}
+			matches[filter] = sub
+		}
+	}
+	return matches
+}
+
+func (s *session) deliver(msg *message, qos byte, retained bool, ids []int) {
+	out := msg.pkt.Copy()
+	out.Dup = false
+	out.Retain = retained
+	if out.QoS > qos {
+		out.QoS = qos
+	}
+	if len(ids) > 0 {
+		if out.Properties == nil {
+			out.Properties = &packets.Properties{}
+		}
+		out.Properties.SubscriptionIdentifier = ids
+	}
+	// the expiry sent to the receiver is the remaining lifetime
+	if !msg.expiry.IsZero() {
+		remaining := time.Until(msg.expiry)
+		if remaining <= 0 {
+			return
+		}
+		out.Properties.MessageExpiry = packets.Uint32(uint32((remaining + time.Second - 1) / time.Second))
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if out.QoS > 0 {
+		out.PacketID = s.nextID()
+		s.inflight = append(s.inflight, out)
+	}
+	if s.conn != nil {
+		s.conn.send(out)
+	}
+}
+
+func (s *session) acked(id uint16) {
+	s.lock.Lock()
+	for i, pkt := range s.inflight {
+		if pkt.PacketID == id {
+			s.inflight = append(s.inflight[:i], s.inflight[i+1:]...)
+			break
+		}
+	}
+	s.lock.Unlock()
+}
+
+// received records the packet ID of an incoming QoS 2 PUBLISH,
+// returns false if it's already received
+func (s *session) received(id uint16) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.incoming[id] {
+		return false
+	}
+	s.incoming[id] = true
+	return true
+}
+
+func (s *session) released(id uint16) {
+	s.lock.Lock()
+	delete(s.incoming, id)
+	s.lock.Unlock()
+}
+
+func (s *session) nextID() uint16 {
+	for {
+		s.lastID++
+		if s.lastID == 0 {
+			continue
+		}
+		inuse := false
+		for _, pkt := range s.inflight {
+			if pkt.PacketID == s.lastID {
+				inuse = true
+				break
+			}
+		}
+		if !inuse {
+			return s.lastID
+		}
+	}
+}
+
+// clientConn is a network connection from a client
+type clientConn struct {
+	broker  *Broker
+	conn    net.Conn
+	will    *packets.Will
+	version byte
+	// aliases maps topic aliases to topic names, only used by MQTT v5
+	aliases map[uint16]string
+
+	outbox    []packets.Packet
+	closed    bool
+	lock      sync.Mutex
+	cond      *sync.Cond
+	closeOnce sync.Once
+}
+
+func newClientConn(b *Broker, conn net.Conn) *clientConn {
+	c := &clientConn{
+		broker:  b,
+		conn:    conn,
+		version: packets.ProtocolV311,
+		aliases: make(map[uint16]string),
+	}
+	c.cond = sync.NewCond(&c.lock)
+	return c
+}
+
+func (c *clientConn) v5() bool {
+	return c.version >= packets.ProtocolV5
+}
+
+func (c *clientConn) send(pkt packets.Packet) {
+	c.lock.Lock()
+	if !c.closed {
+		c.outbox = append(c.outbox, pkt)
+		c.cond.Signal()
+	}
+	c.lock.Unlock()
+}
+
+func (c *clientConn) close() {
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		c.closed = true
+		c.cond.Broadcast()
+		c.lock.Unlock()
+		c.conn.Close()
+	})
+}
+
+func (c *clientConn) writeLoop() {
+	for {
+		c.lock.Lock()
+		for !c.closed && len(c.outbox) == 0 {
+			c.cond.Wait()
+		}
+		if c.closed {
+			c.lock.Unlock()
+			return
+		}
+		pkts := c.outbox
+		c.outbox = nil
+		c.lock.Unlock()
+		for _, pkt := range pkts {
+			if err := packets.WritePacket(c.conn, pkt, c.version); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+func (c *clientConn) serve() {
+	defer c.close()
+	reader := packets.NewReader(c.conn)
+	c.conn.SetReadDeadline(time.Now().Add(ConnectTimeout))
+	pkt, err := reader.ReadPacket()
+	if err != nil {
+		return
+	}
+	connect, ok := pkt.(*packets.Connect)
+	if !ok {
+		return
+	}
+	c.version = connect.ProtocolLevel
+	if code := validateConnect(connect); code != packets.Accepted {
+		packets.WritePacket(c.conn, &packets.Connack{ReturnCode: code}, c.version)
+		return
+	}
+
+	c.will = connect.Will
+	s, present := c.broker.attach(c, connect)
+	connack := &packets.Connack{SessionPresent: present}
+	if c.v5() {
+		connack.Properties = &packets.Properties{
+			TopicAliasMaximum: packets.Uint16(TopicAliasMaximum),
+		}
+		if connect.ClientID == "" {
+			connack.Properties.AssignedClientID = s.clientID
+		}
+	}
+	// CONNACK must be the first packet sent, put it ahead of the resent messages
+	c.lock.Lock()
+	c.outbox = append([]packets.Packet{connack}, c.outbox...)
+	c.lock.Unlock()
+	go c.writeLoop()
+
+	var keepAlive time.Duration
+	if connect.KeepAlive > 0 {
+		keepAlive = time.Duration(connect.KeepAlive) * time.Second * 3 / 2
+	}
+	graceful := false
+	for {
+		if keepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(keepAlive))
+		} else {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		if pkt, err = reader.ReadPacket(); err != nil {
+			break
+		}
+		if disconnect, ok := pkt.(*packets.Disconnect); ok {
+			// MQTT v5 client may request will message to be published
+			graceful = disconnect.ReasonCode != packets.DisconnectWithWill
+			break
+		}
+		if !c.handlePacket(s, pkt) {
+			break
+		}
+	}
+
+	c.close()
+	c.broker.detach(s, c)
+	if !graceful && c.will != nil {
+		c.broker.publish(&packets.Publish{
+			Topic:      c.will.Topic,
+			QoS:        c.will.QoS,
+			Retain:     c.will.Retain,
+			Payload:    c.will.Payload,
+			Properties: willMessageProperties(c.will.Properties),
+		}, nil)
+	}
+}
+
+// willMessageProperties drops the properties only meaningful in CONNECT
+func willMessageProperties(props *packets.Properties) *packets.Properties {
+	if props = props.Copy(); props != nil {
+		props.WillDelay = nil
+	}
+	return props
+}
+
+func validateConnect(pkt *packets.Connect) byte {
+	v5 := pkt.ProtocolLevel == packets.ProtocolV5
+	switch {
+	case pkt.ProtocolName == "MQTT" && pkt.ProtocolLevel == packets.ProtocolV311:
+	case pkt.ProtocolName == "MQTT" && v5:
+	case pkt.ProtocolName == "MQIsdp" && pkt.ProtocolLevel == packets.ProtocolV31:
+	default:
+		return packets.RefusedProtocolVersion
+	}
+	// MQTT v5 allows the server to assign client ID regardless of clean start
+	if pkt.ClientID == "" && !pkt.CleanSession && !v5 {
+		return packets.RefusedIdentifierRejected
+	}
+	if pkt.Will != nil && !topics.ValidName(pkt.Will.Topic) {
+		if v5 {
+			return packets.TopicNameInvalid
+		}
+		return packets.RefusedIdentifierRejected
+	}
+	return packets.Accepted
+}
+
+// resolveTopic applies MQTT v5 topic alias in the PUBLISH, it returns false
+// if the topic alias is invalid
+func (c *clientConn) resolveTopic(p *packets.Publish) bool {
+	if p.Properties == nil || p.Properties.TopicAlias == nil {
+		return true
+	}
+	alias := *p.Properties.TopicAlias
+	if alias == 0 || alias > TopicAliasMaximum {
+		return false
+	}
+	if p.Topic == "" {
+		topic, ok := c.aliases[alias]
+		if !ok {
+			return false
+		}
+		p.Topic = topic
+	} else {
+		c.aliases[alias] = p.Topic
+	}
+	// alias is only meaningful on this connection
+	p.Properties.TopicAlias = nil
+	return true
+}
+
+func (c *clientConn) handlePacket(s *session, pkt packets.Packet) bool {
+	switch p := pkt.(type) {
+	case *packets.Publish:
+		if !c.resolveTopic(p) || !topics.ValidName(p.Topic) {
+			return false
+		}
+		switch p.QoS {
+		case 0:
+			c.broker.publish(p, s)
+		case 1:
+			c.broker.publish(p, s)
+			c.send(&packets.Ack{PacketType: packets.PUBACK, PacketID: p.PacketID})
+		case 2:
+			if s.received(p.PacketID) {
+				c.broker.publish(p, s)
+			}
+			c.send(&packets.Ack{PacketType: packets.PUBREC, PacketID: p.PacketID})
+		}
+	case *packets.Ack:
+		switch p.PacketType {
+		case packets.PUBACK:
+			s.acked(p.PacketID)
+		case packets.PUBREL:
+			s.released(p.PacketID)
+			c.send(&packets.Ack{PacketType: packets.PUBCOMP, PacketID: p.PacketID})
+		default:
+			return false
+		}
+	case *packets.Subscribe:
+		ack := &packets.Suback{PacketID: p.PacketID}
+		var filters []string
+		for _, sub := range p.Subscriptions {
+			if !topics.ValidFilter(sub.Filter) {
+				if c.v5() {
+					ack.ReturnCodes = append(ack.ReturnCodes, packets.TopicFilterInvalid)
+				} else {
+					ack.ReturnCodes = append(ack.ReturnCodes, packets.SubscribeFailure)
+				}
+				continue
+			}
+			qos, sendRetained := s.subscribe(sub, subscriptionID(p.Properties))
+			ack.ReturnCodes = append(ack.ReturnCodes, qos)
+			// retained messages are not sent to shared subscriptions
+			if _, _, shared := topics.SplitShared(sub.Filter); sendRetained && !shared {
+				filters = append(filters, sub.Filter)
+			}
+		}
+		c.send(ack)
+		for _, filter := range filters {
+			c.broker.subscribed(s, filter)
+		}
+	case *packets.Unsubscribe:
+		ack := &packets.Unsuback{PacketID: p.PacketID}
+		for _, filter := range p.Filters {
+			if s.unsubscribe(filter) {
+				ack.ReasonCodes = append(ack.ReasonCodes, packets.Success)
+			} else {
+				ack.ReasonCodes = append(ack.ReasonCodes, packets.NoSubscriptionExisted)
+			}
+		}
+		c.send(ack)
+	case *packets.Empty:
+		if p.PacketType != packets.PINGREQ {
+			return false
+		}
+		c.send(&packets.Empty{PacketType: packets.PINGRESP})
+	default:
+		return false
+	}
+	return true
+}
+
+// subscriptionID returns the subscription identifier in SUBSCRIBE
+// properties, 0 if not specified
+func subscriptionID(props *packets.Properties) int {
+	if props == nil || len(props.SubscriptionIdentifier) == 0 {
+		return 0
+	}
+	return props.SubscriptionIdentifier[0]
+}
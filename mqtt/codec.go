@@ -0,0 +1,154 @@
+package mqtt
+
+import (
+	"bytes"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+)
+
+// contentTypeMark starts the payload encoded by a codec other than JSON
+// for MQTT 3.x which has no content type property, if enabled by
+// Options.ContentTypeMark, the payload is
+//
+//	0x00 <content type> 0x00 <encoded value>
+//
+// a JSON payload never starts with 0x00, so it's kept unmarked for
+// compatibility, the mark is only understood by mqhub, so it's opt-in
+const contentTypeMark = 0
+
+// markContentType prepends the content type to the payload
+func markContentType(contentType string, payload []byte) []byte {
+	marked := make([]byte, 0, len(contentType)+len(payload)+2)
+	marked = append(marked, contentTypeMark)
+	marked = append(marked, contentType...)
+	marked = append(marked, contentTypeMark)
+	return append(marked, payload...)
+}
+
+// splitContentType extracts the content type from a marked payload
+func splitContentType(payload []byte) (string, []byte, bool) {
+	if len(payload) == 0 || payload[0] != contentTypeMark {
+		return "", payload, false
+	}
+	end := bytes.IndexByte(payload[1:], contentTypeMark)
+	if end < 0 {
+		return "", payload, false
+	}
+	return string(payload[1 : end+1]), payload[end+2:], true
+}
+
+// EncodeWith encodes the message using codec, nil codec uses the one
+// identified by the content type of the message, or JSON
+func EncodeWith(msg mqhub.Message, codec mqhub.Codec) ([]byte, error) {
+	if p, ok := msg.(mqhub.EncodedPayload); ok {
+		return p.Payload()
+	}
+	v, ok := msg.Value()
+	if !ok {
+		return nil, nil
+	}
+	if codec == nil {
+		if codec = mqhub.MessageCodec(msg); codec == nil {
+			codec = mqhub.JSON
+		}
+	}
+	return codec.Encode(v)
+}
+
+// decodePayload finds the codec from MQTT v5 content type, the content
+// type of the request, or the marked payload if marked is set, and returns
+// the payload without the mark, the codec is nil if not identified
+func decodePayload(props *packets.Properties, contentType string, payload []byte, marked bool) (mqhub.Codec, []byte) {
+	if props != nil && props.ContentType != "" {
+		contentType = props.ContentType
+	}
+	if contentType != "" {
+		if codec := mqhub.LookupCodec(contentType); codec != nil {
+			return codec, payload
+		}
+	}
+	if marked {
+		if contentType, data, ok := splitContentType(payload); ok {
+			if codec := mqhub.LookupCodec(contentType); codec != nil {
+				return codec, data
+			}
+		}
+	}
+	return nil, payload
+}
+
+// codecOf selects the codec for msg: the content type of the message
+// first, then the codec of the endpoint, and the default of the connector
+func (c *Connector) codecOf(msg mqhub.Message, codec mqhub.Codec) mqhub.Codec {
+	if msgCodec := mqhub.MessageCodec(msg); msgCodec != nil {
+		return msgCodec
+	}
+	if codec != nil {
+		return codec
+	}
+	if c.codec != nil {
+		return c.codec
+	}
+	return mqhub.JSON
+}
+
+// encode encodes the message into out, the codec is identified on the wire
+// unless it's JSON or the message is already encoded, and the metadata is
+// carried by user properties, or the envelope for MQTT 3.x if enabled
+func (c *Connector) encode(out *outboundMsg, msg mqhub.Message, codec mqhub.Codec) error {
+	contentType, err := c.encodeValue(out, msg, codec)
+	if err != nil {
+		return err
+	}
+	if contentType != "" && c.contentTypeMark {
+		out.Payload = markContentType(contentType, out.Payload)
+	}
+	return c.encodeMetadata(out, msg)
+}
+
+// encodeValue encodes the value of the message into out, for MQTT 3.x the
+// content type to be identified is returned, as there's no property
+func (c *Connector) encodeValue(out *outboundMsg, msg mqhub.Message, codec mqhub.Codec) (string, error) {
+	codec = c.codecOf(msg, codec)
+	payload, err := EncodeWith(msg, codec)
+	if err != nil {
+		return "", err
+	}
+	out.Payload = payload
+	_, v5 := c.Client.(propertiesPublisher)
+	if v5 {
+		out.Props = EncodeProperties(msg)
+	}
+	if _, encoded := msg.(mqhub.EncodedPayload); !encoded && codec != mqhub.JSON {
+		if v5 {
+			if out.Props == nil {
+				out.Props = &packets.Properties{}
+			}
+			out.Props.ContentType = codec.ContentType()
+		} else if payload != nil {
+			return codec.ContentType(), nil
+		}
+	}
+	return "", nil
+}
+
+// encodeMetadata adds the metadata of the message to out
+func (c *Connector) encodeMetadata(out *outboundMsg, msg mqhub.Message) error {
+	_, v5 := c.Client.(propertiesPublisher)
+	var err error
+	if meta := c.metadataOf(msg); meta != nil {
+		if v5 {
+			if out.Props == nil {
+				out.Props = &packets.Properties{}
+			}
+			addMetadata(out.Props, meta)
+		} else if c.metadataEnvelope && len(out.Payload) > 0 {
+			// an empty payload clears the retained state, never wrapped
+			if out.Payload, err = wrapMetadata(meta, out.Payload); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -1,16 +1,34 @@
 package mqtt
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/mqtt5"
+	"github.com/robotalks/mqhub.go/mqtt/packets"
 	"github.com/robotalks/mqhub.go/utils"
 )
 
+// Protocol versions
+const (
+	// ProtocolV31 is MQTT 3.1, it's used by default
+	ProtocolV31 uint = 3
+	// ProtocolV311 is MQTT 3.1.1
+	ProtocolV311 uint = 4
+	// ProtocolV5 is MQTT 5.0
+	ProtocolV5 uint = 5
+)
+
 // Options defines configuration for connection
 type Options struct {
 	Servers   []*url.URL
@@ -19,6 +37,61 @@ type Options struct {
 	Password  string
 	ClientID  string
 	Namespace string
+	// ProtocolVersion is one of ProtocolV31, ProtocolV311 and ProtocolV5,
+	// ProtocolV31 is used if not specified
+	ProtocolVersion uint
+	// TopicAliasMaximum is the number of topic aliases accepted from
+	// server, only used by MQTT v5
+	TopicAliasMaximum uint16
+	// QoS is the delivery guarantee for endpoints not specifying one,
+	// AtMostOnce is used if not specified
+	QoS mqhub.QoS
+	// DisableAutoReconnect stops reconnecting after the connection is lost,
+	// by default the connector reconnects, and restores subscriptions and
+	// published components
+	DisableAutoReconnect bool
+	// Backoff controls the delays between reconnect attempts,
+	// DefaultBackoff is used if not specified
+	Backoff Backoff
+	// RepublishStates republishes the last state of datapoints after
+	// reconnected, in case the retained states are lost on the server
+	RepublishStates bool
+	// ClearRetained clears the retained states of datapoints when
+	// unpublished, so they are not received by later subscribers
+	ClearRetained bool
+	// Presence maintains the presence of the connector and published
+	// components, the connector goes offline via Last Will if the
+	// connection drops unexpectedly
+	Presence bool
+	// Queue buffers outgoing messages while disconnected
+	Queue QueueOptions
+	// Codec encodes messages for endpoints not specifying one,
+	// JSON is used if not specified
+	Codec mqhub.Codec
+	// MetadataEnvelope wraps payloads in a JSON envelope carrying the
+	// metadata of messages, only used by MQTT 3.x, as MQTT v5 always
+	// carries metadata in user properties
+	MetadataEnvelope bool
+	// ContentTypeMark prepends the content type to payloads not encoded as
+	// JSON, only used by MQTT 3.x which has no content type property, the
+	// mark is only understood by mqhub, so subscribers not using mqhub need
+	// reactors specifying the codec instead
+	ContentTypeMark bool
+	// Dispatch delivers incoming messages asynchronously
+	Dispatch DispatchOptions
+	// ErrorHandler is notified when a reactor fails handling a message
+	ErrorHandler mqhub.ErrorHandler
+	// ErrorEndpoint publishes the failures of reactors to the ErrorEndpoint
+	// of the components
+	ErrorEndpoint bool
+	// Interceptors are applied to datapoints, reactors and watchers,
+	// the first is the outermost
+	Interceptors []mqhub.Interceptor
+	// Metrics receives the measurements of the connector
+	Metrics mqhub.Metrics
+	// MetricsTopicLabel labels the counters of messages by topics, which
+	// are unbounded if watching with wildcards
+	MetricsTopicLabel bool
 }
 
 // NewOptions creates options
@@ -49,27 +122,238 @@ func (o *Options) SetClientID(id string) *Options {
 	return o
 }
 
+// LoadCA loads PEM encoded CA certificates for verifying the server
+func (o *Options) LoadCA(caFile string) error {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	if o.TLS.RootCAs == nil {
+		o.TLS.RootCAs = x509.NewCertPool()
+	}
+	if !o.TLS.RootCAs.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return nil
+}
+
+// LoadClientCert loads PEM encoded client certificate and private key,
+// keyFile can be empty if the key is included in certFile
+func (o *Options) LoadClientCert(certFile, keyFile string) error {
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	o.TLS.Certificates = append(o.TLS.Certificates, cert)
+	return nil
+}
+
+// SetQoS sets the default delivery guarantee
+func (o *Options) SetQoS(qos mqhub.QoS) *Options {
+	o.QoS = qos
+	return o
+}
+
+// SetCodec sets the default codec
+func (o *Options) SetCodec(codec mqhub.Codec) *Options {
+	o.Codec = codec
+	return o
+}
+
+// SetMetadataEnvelope enables/disables the metadata envelope for MQTT 3.x
+func (o *Options) SetMetadataEnvelope(enabled bool) *Options {
+	o.MetadataEnvelope = enabled
+	return o
+}
+
+// SetContentTypeMark enables/disables the content type mark for MQTT 3.x
+func (o *Options) SetContentTypeMark(enabled bool) *Options {
+	o.ContentTypeMark = enabled
+	return o
+}
+
+// SetAutoReconnect enables/disables reconnecting automatically
+func (o *Options) SetAutoReconnect(enabled bool) *Options {
+	o.DisableAutoReconnect = !enabled
+	return o
+}
+
+// SetBackoff sets the delays between reconnect attempts
+func (o *Options) SetBackoff(backoff Backoff) *Options {
+	o.Backoff = backoff
+	return o
+}
+
+// SetRepublishStates enables/disables republishing states after reconnected
+func (o *Options) SetRepublishStates(enabled bool) *Options {
+	o.RepublishStates = enabled
+	return o
+}
+
+// SetClearRetained enables/disables clearing retained states when unpublished
+func (o *Options) SetClearRetained(enabled bool) *Options {
+	o.ClearRetained = enabled
+	return o
+}
+
+// SetErrorHandler sets the handler notified of the failures of reactors
+func (o *Options) SetErrorHandler(handler mqhub.ErrorHandler) *Options {
+	o.ErrorHandler = handler
+	return o
+}
+
+// SetErrorEndpoint enables/disables publishing the failures of reactors
+func (o *Options) SetErrorEndpoint(enabled bool) *Options {
+	o.ErrorEndpoint = enabled
+	return o
+}
+
+// AddInterceptor appends the interceptors applied to datapoints, reactors
+// and watchers
+func (o *Options) AddInterceptor(interceptors ...mqhub.Interceptor) *Options {
+	o.Interceptors = append(o.Interceptors, interceptors...)
+	return o
+}
+
+// SetMetrics sets the receiver of the measurements
+func (o *Options) SetMetrics(metrics mqhub.Metrics) *Options {
+	o.Metrics = metrics
+	return o
+}
+
+// SetMetricsTopicLabel sets whether counters of messages are labeled by topics
+func (o *Options) SetMetricsTopicLabel(enabled bool) *Options {
+	o.MetricsTopicLabel = enabled
+	return o
+}
+
+// SetPresence enables/disables maintaining presence
+func (o *Options) SetPresence(enabled bool) *Options {
+	o.Presence = enabled
+	return o
+}
+
+// SetQueue sets the size and policy of the offline queue
+func (o *Options) SetQueue(size int, policy QueuePolicy) *Options {
+	o.Queue.Size = size
+	o.Queue.Policy = policy
+	return o
+}
+
+// SetDispatch sets the number of workers delivering incoming messages,
+// and the size and policy of the queue for each handler on a topic
+func (o *Options) SetDispatch(workers, queueSize int, policy QueuePolicy) *Options {
+	o.Dispatch.Workers = workers
+	o.Dispatch.QueueSize = queueSize
+	o.Dispatch.Policy = policy
+	return o
+}
+
+// SetProtocolVersion sets MQTT protocol version
+func (o *Options) SetProtocolVersion(version uint) *Options {
+	o.ProtocolVersion = version
+	return o
+}
+
 func (o *Options) clientOptions() *paho.ClientOptions {
 	opts := paho.NewClientOptions()
 	opts.Servers = o.Servers
 	opts.ClientID = o.ClientID
 	opts.Username = o.Username
 	opts.Password = o.Password
-	opts.ProtocolVersion = 3
+	// TLS is only used for servers with ssl:// (or tls://, tcps://) scheme
+	opts.TLSConfig = &o.TLS
+	// reconnecting is handled by Connector to restore subscriptions
+	opts.AutoReconnect = false
+	opts.ProtocolVersion = ProtocolV31
+	if o.ProtocolVersion == ProtocolV311 {
+		opts.ProtocolVersion = o.ProtocolVersion
+	}
 	if opts.ClientID == "" {
 		opts.ClientID = utils.UniqueID()
 	}
+	if o.Presence {
+		offline, _ := Encode(&mqhub.Presence{})
+		opts.SetBinaryWill(o.topicPrefix()+presenceTopic(hostTopic(opts.ClientID)), offline, 1, true)
+	}
 	return opts
 }
 
+func (o *Options) topicPrefix() string {
+	if o.Namespace != "" && !strings.HasSuffix(o.Namespace, "/") {
+		return o.Namespace + "/"
+	}
+	return o.Namespace
+}
+
+func (o *Options) newClient(onConnLost paho.ConnectionLostHandler) paho.Client {
+	opts := o.clientOptions()
+	opts.OnConnectionLost = onConnLost
+	if o.ProtocolVersion == ProtocolV5 {
+		return mqtt5.NewClient(opts, mqtt5.Config{
+			TopicAliasMaximum: o.TopicAliasMaximum,
+		})
+	}
+	return paho.NewClient(opts)
+}
+
+// ParseProtocolVersion parses protocol version in forms like
+// "3.1.1", "4" (protocol level) and "5"
+func ParseProtocolVersion(version string) (uint, error) {
+	switch version {
+	case "3", "3.1":
+		return ProtocolV31, nil
+	case "4", "3.1.1":
+		return ProtocolV311, nil
+	case "5", "5.0":
+		return ProtocolV5, nil
+	}
+	return 0, fmt.Errorf("invalid protocol version: %s", version)
+}
+
 // Connector connects to MQTT
 type Connector struct {
 	Client paho.Client
 
 	topicPrefix string
+	defaultQoS  mqhub.QoS
+	codec       mqhub.Codec
 	exports     []*Publication
 	lock        sync.RWMutex
 	handlers    *TopicHandlerMap
+	lifecycle   *mqhub.LifecycleStream
+
+	autoReconnect   bool
+	backoff         Backoff
+	republishStates bool
+	clearStates     bool
+	clientID        string
+	// hostID is the client ID escaped as a topic level if presence
+	// is enabled
+	hostID       string
+	queue        *outboundQueue
+	reconnecting bool
+	closed       bool
+	stop         chan struct{}
+
+	// metadataEnvelope wraps payloads with metadata for MQTT 3.x
+	metadataEnvelope bool
+	// contentTypeMark prepends the content type to payloads for MQTT 3.x
+	contentTypeMark bool
+
+	errorHandler  mqhub.ErrorHandler
+	errorEndpoint bool
+	interceptor   mqhub.Interceptor
+	metrics       mqhub.Metrics
+	metricsTopics bool
+	latency       latencySampler
+
+	calls        map[string]chan *replyEnvelope
+	callsLock    sync.Mutex
+	replyHandler *HandlerRef
 }
 
 // NewConnector creates a connector
@@ -78,40 +362,158 @@ func NewConnector(options *Options) *Connector {
 		options = NewOptions()
 	}
 	conn := &Connector{
-		Client:      paho.NewClient(options.clientOptions()),
-		topicPrefix: options.Namespace,
-		handlers:    NewTopicHandlerMap(),
+		topicPrefix:      options.topicPrefix(),
+		defaultQoS:       options.QoS,
+		codec:            options.Codec,
+		handlers:         NewTopicHandlerMap(),
+		lifecycle:        mqhub.NewLifecycleStream(),
+		autoReconnect:    !options.DisableAutoReconnect,
+		backoff:          options.Backoff,
+		republishStates:  options.RepublishStates,
+		clearStates:      options.ClearRetained,
+		metadataEnvelope: options.MetadataEnvelope,
+		contentTypeMark:  options.ContentTypeMark,
+		errorHandler:     options.ErrorHandler,
+		errorEndpoint:    options.ErrorEndpoint,
+		interceptor:      mqhub.ChainInterceptors(options.Interceptors...),
+		metrics:          options.Metrics,
+		metricsTopics:    options.MetricsTopicLabel,
+		stop:             make(chan struct{}),
 	}
-	if conn.topicPrefix != "" && !strings.HasSuffix(conn.topicPrefix, "/") {
-		conn.topicPrefix += "/"
+	conn.Client = options.newClient(conn.connectionLost)
+	reader := conn.Client.OptionsReader()
+	conn.clientID = reader.ClientID()
+	if options.Presence {
+		conn.hostID = hostTopic(conn.clientID)
+	}
+	if options.Queue.Size > 0 {
+		queueOpts := options.Queue
+		// the journal named by a generated client ID would be orphaned
+		if options.ClientID == "" {
+			queueOpts.Dir = ""
+		}
+		conn.queue = newOutboundQueue(queueOpts, conn.clientID, options.Metrics)
+	}
+	if options.Dispatch.Workers > 0 {
+		conn.handlers.dispatcher = newDispatcher(options.Dispatch, options.Metrics)
 	}
 	conn.handlers.prefix = conn.topicPrefix
+	conn.handlers.metrics = options.Metrics
+	conn.handlers.metricsTopics = options.MetricsTopicLabel
 	return conn
 }
 
 // Watch implements Watchable
 func (c *Connector) Watch(sink mqhub.MessageSink) (mqhub.Watcher, error) {
-	return watchTopic(c, c, "#", sink)
+	return c.WatchContext(context.Background(), sink)
+}
+
+// WatchContext implements ContextWatchable
+func (c *Connector) WatchContext(ctx context.Context, sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	return watchTopic(ctx, c, c, "#", c.qos(mqhub.DefaultQoS), sink)
 }
 
 // Connect connects to server
 func (c *Connector) Connect() mqhub.Future {
-	return &Future{token: c.Client.Connect()}
+	return c.connect()
+}
+
+// ConnectContext implements Connector, if ctx is done before connected,
+// ctx.Err() is returned and the pending attempt is left alone, it may still
+// get connected, use Close to give up
+func (c *Connector) ConnectContext(ctx context.Context) error {
+	return c.connect().WaitContext(ctx)
+}
+
+func (c *Connector) connect() *Future {
+	c.lock.Lock()
+	if c.closed {
+		c.closed = false
+		c.stop = make(chan struct{})
+		if c.queue != nil {
+			c.queue.reopen()
+		}
+		if c.handlers.dispatcher != nil {
+			c.handlers.dispatcher.reopen()
+		}
+	}
+	c.lock.Unlock()
+	token := c.Client.Connect()
+	go func() {
+		if !token.Wait() || token.Error() != nil || c.lifecycle.State() == mqhub.Connected {
+			return
+		}
+		c.lock.RLock()
+		closed := c.closed
+		c.lock.RUnlock()
+		// closed while connecting
+		if closed {
+			c.Client.Disconnect(0)
+			return
+		}
+		// the connection is abandoned if the queue can't be flushed,
+		// and flushed again after reconnected
+		if err := c.flush(); err != nil {
+			c.Client.Disconnect(0)
+			c.connectionLost(c.Client, err)
+			return
+		}
+		c.announce(true)
+		c.connected()
+	}()
+	return &Future{token: token}
 }
 
 // Close implements io.Closer
 func (c *Connector) Close() error {
+	c.lock.Lock()
+	closing := !c.closed
+	if closing {
+		c.closed = true
+		close(c.stop)
+	}
+	c.lock.Unlock()
+	if closing && c.Client.IsConnected() {
+		// Last Will is discarded on graceful disconnecting
+		c.announce(false).Wait()
+	}
+	if c.queue != nil {
+		c.queue.close()
+	}
+	if c.handlers.dispatcher != nil {
+		c.handlers.dispatcher.close()
+	}
 	c.Client.Disconnect(0)
+	if closing {
+		c.lifecycle.Emit(mqhub.Closed, nil)
+	}
 	return nil
 }
 
+// Lifecycle implements Connector
+func (c *Connector) Lifecycle() mqhub.Watchable {
+	return c.lifecycle
+}
+
 // Publish implements Publisher
 func (c *Connector) Publish(comp mqhub.Component) (mqhub.Publication, error) {
-	pub := newPublication(c, comp)
+	return c.PublishContext(context.Background(), comp)
+}
+
+// PublishContext implements Connector
+func (c *Connector) PublishContext(ctx context.Context, comp mqhub.Component) (mqhub.Publication, error) {
+	return c.PublishShared(ctx, comp, "")
+}
+
+// PublishShared is same as PublishContext, and the reactors not specifying
+// a share group are subscribed in the group, so each message is handled by
+// only one of the replicas publishing the same component in the group
+func (c *Connector) PublishShared(ctx context.Context, comp mqhub.Component, group string) (mqhub.Publication, error) {
+	pub := newPublication(c, comp, group)
 	c.lock.Lock()
 	c.exports = append(c.exports, pub)
 	c.lock.Unlock()
-	if err := pub.export(); err != nil {
+	if err := pub.export(ctx); err != nil {
 		pub.unexport()
 		c.removePub(pub)
 		return nil, err
@@ -128,37 +530,175 @@ func (c *Connector) Describe(componentID string) mqhub.Descriptor {
 	}
 }
 
+// announce publishes the presence of the connector
+func (c *Connector) announce(online bool) mqhub.Future {
+	if c.hostID == "" {
+		return &Future{}
+	}
+	return c.pub(presenceTopic(c.hostID), 1, mqhub.JSON, &mqhub.Presence{ComponentID: c.hostID, Online: online})
+}
+
+// reportsErrors tells whether the failures of reactors are reported,
+// or counted by metrics
+func (c *Connector) reportsErrors() bool {
+	return c.errorHandler != nil || c.errorEndpoint || c.metrics != nil
+}
+
+// reportError notifies the error handler, and publishes the failure to
+// the ErrorEndpoint of the component owning the endpoint if enabled
+func (c *Connector) reportError(topic string, err error) {
+	countError(c.metrics, topicLabels(c.metricsTopics, topic), err)
+	if c.errorHandler != nil {
+		c.errorHandler(topic, err)
+	}
+	if c.errorEndpoint {
+		compID, _ := ParseTopicRel(topic)
+		c.pub(EndpointTopic(compID, mqhub.ErrorEndpoint), 1, mqhub.JSON, mqhub.MsgFrom(mqhub.ErrorReportOf(topic, err)))
+	}
+}
+
 func (c *Connector) parseTopic(topic string) (string, string) {
 	return ParseTopic(topic, c.topicPrefix)
 }
 
 func (c *Connector) newMsg(msg paho.Message) *Message {
-	return NewMessage(c.topicPrefix, msg)
+	return newMessage(c.topicPrefix, msg, c.metadataEnvelope, c.contentTypeMark)
 }
 
-func (c *Connector) sub(topics []string, handler *HandlerRef) *Future {
+// qos converts the delivery guarantee to MQTT QoS level
+func (c *Connector) qos(qos mqhub.QoS) byte {
+	if qos == mqhub.DefaultQoS {
+		qos = c.defaultQoS
+	}
+	switch qos {
+	case mqhub.AtLeastOnce:
+		return 1
+	case mqhub.ExactlyOnce:
+		return 2
+	}
+	return 0
+}
+
+// sub subscribes the topics with QoS levels
+func (c *Connector) sub(topics map[string]byte, handler *HandlerRef) mqhub.ContextFuture {
 	subs := c.handlers.Add(topics, handler)
+	if len(subs) == 0 {
+		return &Future{}
+	}
+	return c.subscribe(subs)
+}
+
+// subscribe sends SUBSCRIBE for the filters relative to the namespace,
+// each shared filter is subscribed with its own callback, so the messages
+// delivered for other subscriptions are not dispatched to shared handlers.
+// With MQTT v5, the subscription identifiers tell the subscriptions a
+// message is delivered for, with MQTT 3.1.1, a message delivered for an
+// overlapping subscription is also dispatched to the shared handlers
+func (c *Connector) subscribe(filters map[string]byte) mqhub.ContextFuture {
+	var futures mqhub.Futures
 	subsMap := make(map[string]byte)
-	for _, topic := range subs {
-		subsMap[c.topicPrefix+topic] = 0
+	for filter, qos := range filters {
+		if group, _ := SplitSharedFilter(filter); group != "" {
+			token := c.Client.SubscribeMultiple(map[string]byte{prefixFilter(c.topicPrefix, filter): qos},
+				c.handlers.sharedHandler(filter))
+			futures = append(futures, &Future{token: token})
+		} else {
+			subsMap[prefixFilter(c.topicPrefix, filter)] = qos
+		}
 	}
-	return &Future{token: c.Client.SubscribeMultiple(subsMap, c.handlers.HandleMessage)}
+	if len(subsMap) > 0 {
+		futures = append(futures, &Future{token: c.Client.SubscribeMultiple(subsMap, c.handlers.handleRoute)})
+	}
+	return futures
 }
 
 func (c *Connector) unsub(topics []string, handler *HandlerRef) *Future {
 	unsubs := c.handlers.Del(topics, handler)
+	if len(unsubs) == 0 {
+		return &Future{}
+	}
 	for i, topic := range unsubs {
-		unsubs[i] = c.topicPrefix + topic
+		unsubs[i] = prefixFilter(c.topicPrefix, topic)
 	}
 	return &Future{token: c.Client.Unsubscribe(unsubs...)}
 }
 
-func (c *Connector) pub(topic string, msg mqhub.Message) *Future {
-	encoded, err := Encode(msg)
-	if err != nil {
+// propertiesPublisher is a client able to publish with MQTT v5 properties
+type propertiesPublisher interface {
+	PublishProperties(topic string, qos byte, retained bool,
+		payload interface{}, props *packets.Properties) paho.Token
+}
+
+func (c *Connector) pub(topic string, qos byte, codec mqhub.Codec, msg mqhub.Message) *Future {
+	out := &outboundMsg{
+		Topic:    c.topicPrefix + topic,
+		QoS:      qos,
+		Retained: msg.IsState(),
+	}
+	if err := c.encode(out, msg, codec); err != nil {
 		return &Future{err: err}
 	}
-	return &Future{token: c.Client.Publish(c.topicPrefix+topic, 0, msg.IsState(), encoded)}
+	if c.queue != nil {
+		// a queued message is considered published
+		if queued, err := c.queue.offer(out); queued || err != nil {
+			return &Future{err: err}
+		}
+	}
+	token := c.publish(out)
+	c.countPublished(out)
+	c.latency.sample(c.metrics, MetricPublishLatency, func() { token.Wait() })
+	return &Future{token: token}
+}
+
+// clearRetained removes the retained message on the topic
+func (c *Connector) clearRetained(topic string) *Future {
+	return &Future{token: c.publish(&outboundMsg{Topic: c.topicPrefix + topic, QoS: 1, Retained: true})}
+}
+
+func (c *Connector) publish(msg *outboundMsg) paho.Token {
+	if client, ok := c.Client.(propertiesPublisher); ok && msg.Props != nil {
+		return client.PublishProperties(msg.Topic, msg.QoS, msg.Retained, msg.Payload, msg.Props)
+	}
+	return c.Client.Publish(msg.Topic, msg.QoS, msg.Retained, msg.Payload)
+}
+
+// flush publishes messages queued while disconnected
+func (c *Connector) flush() error {
+	if c.queue == nil {
+		return nil
+	}
+	return c.queue.flush(func(msg *outboundMsg) error {
+		token := c.publish(msg)
+		token.Wait()
+		if err := token.Error(); err != nil {
+			return err
+		}
+		c.countPublished(msg)
+		return nil
+	})
+}
+
+// countPublished counts the message handed to the client
+func (c *Connector) countPublished(msg *outboundMsg) {
+	topic := strings.TrimPrefix(msg.Topic, c.topicPrefix)
+	countMessage(c.metrics, MetricMessagesOut, MetricBytesOut, topicLabels(c.metricsTopics, topic), len(msg.Payload))
+}
+
+// Queued returns the number of messages waiting for connection
+func (c *Connector) Queued() int {
+	if c.queue == nil {
+		return 0
+	}
+	return c.queue.len()
+}
+
+// DispatchStats returns the snapshot of dispatching incoming messages,
+// it's empty if messages are delivered synchronously
+func (c *Connector) DispatchStats() DispatchStats {
+	if c.handlers.dispatcher == nil {
+		return DispatchStats{}
+	}
+	return c.handlers.dispatcher.stats()
 }
 
 func (c *Connector) removePub(pub *Publication) {
@@ -173,12 +713,15 @@ func (c *Connector) removePub(pub *Publication) {
 }
 
 // ConnectorFactory implements mqhub.ConnectorFactory
+// mqtts://host:port is same as mqtt+ssl://host:port
 func ConnectorFactory(URL url.URL) (mqhub.Connector, error) {
 	opts := NewOptions()
 	if strings.HasPrefix(URL.Scheme, Protocol+"+") {
 		URL.Scheme = URL.Scheme[len(Protocol)+1:]
 	} else if URL.Scheme == Protocol {
 		URL.Scheme = "tcp"
+	} else if URL.Scheme == ProtocolTLS {
+		URL.Scheme = "ssl"
 	}
 	opts.Servers = append(opts.Servers, &URL)
 	if URL.User != nil {
@@ -188,7 +731,11 @@ func ConnectorFactory(URL url.URL) (mqhub.Connector, error) {
 		}
 	}
 	opts.Namespace = strings.Trim(URL.Path, "/")
-	for key, vals := range URL.Query() {
+	query := URL.Query()
+	if err := parseTLSOptions(opts, query); err != nil {
+		return nil, err
+	}
+	for key, vals := range query {
 		switch key {
 		case OptClientID:
 			if len(vals) > 0 {
@@ -198,19 +745,213 @@ func ConnectorFactory(URL url.URL) (mqhub.Connector, error) {
 				}
 				opts.ClientID = unescaped
 			}
+		case OptVersion:
+			if len(vals) > 0 {
+				version, err := ParseProtocolVersion(vals[len(vals)-1])
+				if err != nil {
+					return nil, err
+				}
+				opts.ProtocolVersion = version
+			}
+		case OptQoS:
+			if len(vals) > 0 {
+				qos, err := mqhub.ParseQoS(vals[len(vals)-1])
+				if err != nil {
+					return nil, err
+				}
+				opts.QoS = qos
+			}
+		case OptCodec:
+			if len(vals) > 0 {
+				codec := mqhub.LookupCodec(vals[len(vals)-1])
+				if codec == nil {
+					return nil, fmt.Errorf("unknown codec %s", vals[len(vals)-1])
+				}
+				opts.Codec = codec
+			}
+		case OptReconnect, OptRepublishStates, OptClearRetained, OptPresence, OptMetadata, OptContentTypeMark, OptErrorEndpoint:
+			if len(vals) > 0 {
+				enabled, err := strconv.ParseBool(vals[len(vals)-1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s: %v", key, err)
+				}
+				switch key {
+				case OptReconnect:
+					opts.DisableAutoReconnect = !enabled
+				case OptRepublishStates:
+					opts.RepublishStates = enabled
+				case OptClearRetained:
+					opts.ClearRetained = enabled
+				case OptMetadata:
+					opts.MetadataEnvelope = enabled
+				case OptContentTypeMark:
+					opts.ContentTypeMark = enabled
+				case OptErrorEndpoint:
+					opts.ErrorEndpoint = enabled
+				default:
+					opts.Presence = enabled
+				}
+			}
+		case OptQueueSize:
+			if len(vals) > 0 {
+				size, err := strconv.Atoi(vals[len(vals)-1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s: %v", key, err)
+				}
+				opts.Queue.Size = size
+			}
+		case OptQueuePolicy:
+			if len(vals) > 0 {
+				policy, err := ParseQueuePolicy(vals[len(vals)-1])
+				if err != nil {
+					return nil, err
+				}
+				opts.Queue.Policy = policy
+			}
+		case OptDispatchWorkers, OptDispatchQueueSize:
+			if len(vals) > 0 {
+				n, err := strconv.Atoi(vals[len(vals)-1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s: %v", key, err)
+				}
+				if key == OptDispatchWorkers {
+					opts.Dispatch.Workers = n
+				} else {
+					opts.Dispatch.QueueSize = n
+				}
+			}
+		case OptDispatchPolicy:
+			if len(vals) > 0 {
+				policy, err := ParseQueuePolicy(vals[len(vals)-1])
+				if err != nil {
+					return nil, err
+				}
+				opts.Dispatch.Policy = policy
+			}
+		case OptQueueDir:
+			if len(vals) > 0 {
+				opts.Queue.Dir = vals[len(vals)-1]
+			}
+		case OptReconnectInterval, OptReconnectMaxInterval:
+			if len(vals) > 0 {
+				interval, err := time.ParseDuration(vals[len(vals)-1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s: %v", key, err)
+				}
+				if key == OptReconnectInterval {
+					opts.Backoff.Initial = interval
+				} else {
+					opts.Backoff.Max = interval
+				}
+			}
 		}
 	}
+	if opts.Queue.Dir != "" && opts.ClientID == "" {
+		return nil, ErrQueueClientID
+	}
 	return NewConnector(opts), nil
 }
 
+func parseTLSOptions(opts *Options, query url.Values) error {
+	if caFile := query.Get(OptTLSCA); caFile != "" {
+		if err := opts.LoadCA(caFile); err != nil {
+			return err
+		}
+	}
+	if certFile := query.Get(OptTLSCert); certFile != "" {
+		if err := opts.LoadClientCert(certFile, query.Get(OptTLSKey)); err != nil {
+			return err
+		}
+	} else if query.Get(OptTLSKey) != "" {
+		return fmt.Errorf("%s requires %s", OptTLSKey, OptTLSCert)
+	}
+	opts.TLS.ServerName = query.Get(OptTLSServerName)
+	if insecure := query.Get(OptTLSInsecure); insecure != "" {
+		skip, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", OptTLSInsecure, err)
+		}
+		opts.TLS.InsecureSkipVerify = skip
+	}
+	return nil
+}
+
 const (
 	// Protocol is the name of protocol for connector
 	Protocol = "mqtt"
+	// ProtocolTLS is the name of protocol for connector using TLS
+	ProtocolTLS = "mqtts"
 
 	// OptClientID is the property name in URL query
 	OptClientID = "client-id"
+	// OptVersion is the property name in URL query for protocol version
+	OptVersion = "version"
+	// OptQoS is the property name in URL query for default delivery guarantee
+	OptQoS = "qos"
+	// OptCodec is the property name in URL query for default codec,
+	// either the registered name or the content type
+	OptCodec = "codec"
+	// OptReconnect is the property name in URL query for enabling
+	// automatic reconnecting
+	OptReconnect = "reconnect"
+	// OptReconnectInterval is the property name in URL query for the
+	// delay before the first reconnect attempt
+	OptReconnectInterval = "reconnect-interval"
+	// OptReconnectMaxInterval is the property name in URL query for the
+	// maximum delay between reconnect attempts
+	OptReconnectMaxInterval = "reconnect-max-interval"
+	// OptRepublishStates is the property name in URL query for
+	// republishing states after reconnected
+	OptRepublishStates = "republish-states"
+	// OptClearRetained is the property name in URL query for clearing
+	// retained states when unpublished
+	OptClearRetained = "clear-retained"
+	// OptPresence is the property name in URL query for maintaining presence
+	OptPresence = "presence"
+	// OptMetadata is the property name in URL query for carrying metadata
+	// in the envelope with MQTT 3.x
+	OptMetadata = "metadata"
+	// OptContentTypeMark is the property name in URL query for marking
+	// payloads with the content type with MQTT 3.x
+	OptContentTypeMark = "content-type-mark"
+	// OptErrorEndpoint is the property name in URL query for publishing
+	// the failures of reactors
+	OptErrorEndpoint = "error-endpoint"
+	// OptQueueSize is the property name in URL query for the size of
+	// offline queue
+	OptQueueSize = "queue-size"
+	// OptQueuePolicy is the property name in URL query for the policy of
+	// offline queue
+	OptQueuePolicy = "queue-policy"
+	// OptQueueDir is the property name in URL query for the directory
+	// persisting offline queue
+	OptQueueDir = "queue-dir"
+	// OptDispatchWorkers is the property name in URL query for the number
+	// of workers delivering incoming messages
+	OptDispatchWorkers = "dispatch-workers"
+	// OptDispatchQueueSize is the property name in URL query for the size
+	// of the dispatch queue of each handler on a topic
+	OptDispatchQueueSize = "dispatch-queue-size"
+	// OptDispatchPolicy is the property name in URL query for the policy
+	// of dispatch queues
+	OptDispatchPolicy = "dispatch-policy"
+	// OptTLSCA is the property name in URL query for the path of CA bundle
+	OptTLSCA = "tls-ca"
+	// OptTLSCert is the property name in URL query for the path of
+	// client certificate
+	OptTLSCert = "tls-cert"
+	// OptTLSKey is the property name in URL query for the path of
+	// client private key
+	OptTLSKey = "tls-key"
+	// OptTLSServerName is the property name in URL query for the server
+	// name used to verify the server certificate
+	OptTLSServerName = "tls-server-name"
+	// OptTLSInsecure is the property name in URL query for skipping
+	// server certificate verification
+	OptTLSInsecure = "tls-insecure"
 )
 
 func init() {
 	mqhub.RegisterConnectorFactory(Protocol, ConnectorFactory)
+	mqhub.RegisterConnectorFactory(ProtocolTLS, ConnectorFactory)
 }
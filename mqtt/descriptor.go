@@ -1,6 +1,10 @@
 package mqtt
 
-import "github.com/robotalks/mqhub.go/mqhub"
+import (
+	"context"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+)
 
 // Descriptor represents advertisements
 // and implements mqhub.Descriptor
@@ -9,6 +13,10 @@ type Descriptor struct {
 	SubTopic    string `json:"topic"`
 
 	conn *Connector
+	// root is the topic of the published component
+	root string
+	// adv is present if discovered
+	adv *mqhub.Advertisement
 }
 
 // SubComponent implements Descriptor
@@ -16,16 +24,53 @@ func (d *Descriptor) SubComponent(id ...string) mqhub.Descriptor {
 	if len(id) == 0 {
 		return d
 	}
-	return &Descriptor{
+	sub := &Descriptor{
 		ComponentID: id[len(id)-1],
 		SubTopic:    SubCompTopic(d.SubTopic, id...),
 		conn:        d.conn,
+		root:        d.rootTopic(),
+	}
+	if d.adv != nil {
+		sub.adv = d.adv.Find(id...)
+	}
+	return sub
+}
+
+// Endpoints implements Descriptor
+func (d *Descriptor) Endpoints() []mqhub.EndpointInfo {
+	if d.adv == nil {
+		return nil
+	}
+	return d.adv.Endpoints
+}
+
+// SubComponents implements Descriptor
+func (d *Descriptor) SubComponents() []mqhub.Descriptor {
+	if d.adv == nil {
+		return nil
+	}
+	subs := make([]mqhub.Descriptor, 0, len(d.adv.Components))
+	for _, comp := range d.adv.Components {
+		subs = append(subs, d.SubComponent(comp.ComponentID))
+	}
+	return subs
+}
+
+func (d *Descriptor) rootTopic() string {
+	if d.root != "" {
+		return d.root
 	}
+	return d.SubTopic
 }
 
 // Watch implements Descriptor
 func (d *Descriptor) Watch(sink mqhub.MessageSink) (mqhub.Watcher, error) {
-	return watchTopic(d.conn, d, SubCompTopic(d.SubTopic, "#"), sink)
+	return d.WatchContext(context.Background(), sink)
+}
+
+// WatchContext implements ContextWatchable
+func (d *Descriptor) WatchContext(ctx context.Context, sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	return watchTopic(ctx, d.conn, d, SubCompTopic(d.SubTopic, "#"), d.conn.qos(mqhub.DefaultQoS), sink)
 }
 
 // ID implements Descriptor
@@ -41,18 +86,50 @@ func (d *Descriptor) Endpoint(name string) mqhub.EndpointRef {
 	}
 }
 
+// Presence implements Descriptor, the presence of a sub-component
+// is the presence of the published component
+func (d *Descriptor) Presence() mqhub.Watchable {
+	return &presenceRef{conn: d.conn, topic: d.rootTopic()}
+}
+
 // EndpointRef implements mqhub.EndpointRef
 type EndpointRef struct {
 	conn  *Connector
 	topic string
+	qos   mqhub.QoS
+	codec mqhub.Codec
 }
 
 // Watch implements EndpointRef
 func (r *EndpointRef) Watch(sink mqhub.MessageSink) (mqhub.Watcher, error) {
-	return watchTopic(r.conn, r, r.topic, sink)
+	return r.WatchContext(context.Background(), sink)
+}
+
+// WatchContext implements ContextWatchable
+func (r *EndpointRef) WatchContext(ctx context.Context, sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	return watchTopic(ctx, r.conn, r, r.topic, r.conn.qos(r.qos), sink)
 }
 
 // ConsumeMessage implements MessageSink
 func (r *EndpointRef) ConsumeMessage(msg mqhub.Message) mqhub.Future {
-	return r.conn.pub(r.topic, msg)
+	return r.conn.pub(r.topic, r.conn.qos(r.qos), r.codec, msg)
+}
+
+// Call implements EndpointRef
+func (r *EndpointRef) Call(ctx context.Context, req interface{}, resp interface{}) error {
+	return r.conn.call(ctx, r.topic, r.conn.qos(r.qos), r.codec, req, resp)
+}
+
+// WithQoS implements EndpointRef
+func (r *EndpointRef) WithQoS(qos mqhub.QoS) mqhub.EndpointRef {
+	ref := *r
+	ref.qos = qos
+	return &ref
+}
+
+// WithCodec implements EndpointRef
+func (r *EndpointRef) WithCodec(codec mqhub.Codec) mqhub.EndpointRef {
+	ref := *r
+	ref.codec = codec
+	return &ref
 }
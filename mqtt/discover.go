@@ -0,0 +1,68 @@
+package mqtt
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+// DiscoverSettle is how long Discover waits for more advertisements
+// after the last one arrived
+var DiscoverSettle = 200 * time.Millisecond
+
+// Discover implements Connector
+func (c *Connector) Discover(ctx context.Context) ([]mqhub.Descriptor, error) {
+	var lock sync.Mutex
+	advs := make(map[string]*mqhub.Advertisement)
+	arrived := make(chan struct{}, 1)
+	handler := MakeHandlerRef(func(_ paho.Client, msg paho.Message) {
+		m := c.newMsg(msg)
+		var adv mqhub.Advertisement
+		if len(m.payload) == 0 || m.As(&adv) != nil {
+			return
+		}
+		lock.Lock()
+		advs[m.Component()] = &adv
+		lock.Unlock()
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+	})
+	topic := EndpointTopic("+", mqhub.AdvertisementEndpoint)
+	defer c.unsub([]string{topic}, handler)
+	if err := c.sub(map[string]byte{topic: 1}, handler).WaitContext(ctx); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(DiscoverSettle)
+	defer timer.Stop()
+	for settled := false; !settled; {
+		select {
+		case <-arrived:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(DiscoverSettle)
+		case <-timer.C:
+			settled = true
+		case <-ctx.Done():
+			settled = true
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	descs := make([]mqhub.Descriptor, 0, len(advs))
+	for id, adv := range advs {
+		descs = append(descs, &Descriptor{ComponentID: id, SubTopic: id, conn: c, adv: adv})
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].ID() < descs[j].ID()
+	})
+	return descs, nil
+}
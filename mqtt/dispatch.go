@@ -0,0 +1,258 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+// DispatchOptions configures delivering incoming messages to handlers
+// asynchronously, so a slow handler doesn't stall the others
+type DispatchOptions struct {
+	// Workers is the maximum number of goroutines delivering messages,
+	// 0 delivers messages synchronously on the goroutine of the client
+	Workers int
+	// QueueSize is the maximum number of messages waiting for each handler
+	// on each topic (a reactor, or a topic matched by a watcher), 0 is
+	// unlimited
+	QueueSize int
+	// Policy is used when the queue of a handler on a topic is full, Block
+	// blocks the client, so all incoming messages are held back
+	Policy QueuePolicy
+}
+
+// DispatchStats is the snapshot of dispatching
+type DispatchStats struct {
+	// Pending is the number of messages waiting for delivery
+	Pending int
+	// MaxPending is the number of messages waiting for the slowest handler
+	// on a topic
+	MaxPending int
+	// Busy is the number of workers delivering messages
+	Busy int
+	// Dropped is the number of messages discarded by the queue policy
+	Dropped uint64
+}
+
+// dispatcher delivers messages to handlers using a bounded pool of
+// workers, messages on the same topic are delivered to a handler in order
+// as they are queued in the same lane, which is taken by one worker at a time
+type dispatcher struct {
+	opts    DispatchOptions
+	sinks   map[*HandlerRef]*sinkQueue
+	ready   []*lane
+	running int
+	busy    int
+	pending int
+	dropped uint64
+	closed  bool
+	lock    sync.Mutex
+	cond    *sync.Cond
+	metrics mqhub.Metrics
+}
+
+// sinkQueue keeps the lanes of a handler
+type sinkQueue struct {
+	handler *HandlerRef
+	lanes   map[string]*lane
+}
+
+// lane queues the messages on a topic for a handler, which is limited by
+// QueueSize, so a slow reactor doesn't affect others of the same
+// publication, it's scheduled until a worker finds it empty
+type lane struct {
+	sink      *sinkQueue
+	topic     string
+	msgs      []*dispatchedMsg
+	scheduled bool
+}
+
+type dispatchedMsg struct {
+	client paho.Client
+	msg    paho.Message
+	state  bool
+	queued time.Time
+}
+
+func newDispatcher(opts DispatchOptions, metrics mqhub.Metrics) *dispatcher {
+	d := &dispatcher{opts: opts, sinks: make(map[*HandlerRef]*sinkQueue), metrics: metrics}
+	d.cond = sync.NewCond(&d.lock)
+	return d
+}
+
+// dispatch queues the message for the handler, a retained message is the
+// latest state of the topic which can be coalesced
+func (d *dispatcher) dispatch(handler *HandlerRef, client paho.Client, msg paho.Message) {
+	state := msg.Retained()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	l := d.laneOf(handler, msg.Topic())
+	if d.opts.Policy == Coalesce && state {
+		for i := len(l.msgs) - 1; i >= 0; i-- {
+			if l.msgs[i].state {
+				d.remove(l, i)
+			}
+		}
+	}
+	for d.opts.QueueSize > 0 && len(l.msgs) >= d.opts.QueueSize {
+		switch d.opts.Policy {
+		case DropNewest:
+			d.drop()
+			d.release(l)
+			return
+		case Block:
+			if d.closed {
+				d.drop()
+				d.release(l)
+				return
+			}
+			d.cond.Wait()
+			// the lane may be released while waiting
+			l = d.laneOf(handler, msg.Topic())
+		case Coalesce:
+			d.dropOldest(l, true)
+		default:
+			d.dropOldest(l, false)
+		}
+	}
+	l.msgs = append(l.msgs, &dispatchedMsg{client: client, msg: msg, state: state, queued: time.Now()})
+	d.pending++
+	d.gaugePending()
+	if !l.scheduled {
+		l.scheduled = true
+		d.ready = append(d.ready, l)
+		if d.running < d.opts.Workers {
+			d.running++
+			go d.work()
+		}
+	}
+}
+
+// laneOf returns the lane of the topic for the handler, it's created
+// if not exist
+func (d *dispatcher) laneOf(handler *HandlerRef, topic string) *lane {
+	sink := d.sinks[handler]
+	if sink == nil {
+		sink = &sinkQueue{handler: handler, lanes: make(map[string]*lane)}
+		d.sinks[handler] = sink
+	}
+	l := sink.lanes[topic]
+	if l == nil {
+		l = &lane{sink: sink, topic: topic}
+		sink.lanes[topic] = l
+	}
+	return l
+}
+
+// dropOldest discards the oldest message queued in the lane, with
+// keepStates, the oldest non-state message is preferred
+func (d *dispatcher) dropOldest(l *lane, keepStates bool) {
+	index := 0
+	if keepStates {
+		for i, m := range l.msgs {
+			if !m.state {
+				index = i
+				break
+			}
+		}
+	}
+	d.remove(l, index)
+	d.drop()
+}
+
+// remove discards the queued message at index of the lane
+func (d *dispatcher) remove(l *lane, index int) {
+	l.msgs = append(l.msgs[:index], l.msgs[index+1:]...)
+	d.pending--
+	d.gaugePending()
+	d.cond.Broadcast()
+}
+
+// drop counts a message discarded by the queue policy
+func (d *dispatcher) drop() {
+	d.dropped++
+	if d.metrics != nil {
+		d.metrics.Count(MetricDispatchDropped, nil, 1)
+	}
+}
+
+// gaugePending reports the number of messages waiting for delivery
+func (d *dispatcher) gaugePending() {
+	if d.metrics != nil {
+		d.metrics.Gauge(MetricDispatchPending, nil, float64(d.pending))
+	}
+}
+
+// release removes the lane if it's neither scheduled nor has messages
+func (d *dispatcher) release(l *lane) {
+	if l.scheduled || len(l.msgs) > 0 {
+		return
+	}
+	delete(l.sink.lanes, l.topic)
+	if len(l.sink.lanes) == 0 {
+		delete(d.sinks, l.sink.handler)
+	}
+}
+
+// work delivers messages from the ready lanes, the worker exits when
+// there's nothing to deliver
+func (d *dispatcher) work() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for len(d.ready) > 0 {
+		l := d.ready[0]
+		d.ready = d.ready[1:]
+		if len(l.msgs) == 0 {
+			l.scheduled = false
+			d.release(l)
+			continue
+		}
+		m := l.msgs[0]
+		l.msgs = l.msgs[1:]
+		d.pending--
+		d.gaugePending()
+		d.busy++
+		d.cond.Broadcast()
+		d.lock.Unlock()
+		l.sink.handler.Handler(m.client, m.msg)
+		observeSince(d.metrics, MetricDispatchLatency, m.queued)
+		d.lock.Lock()
+		d.busy--
+		// the lane is queued again after others for fairness
+		d.ready = append(d.ready, l)
+	}
+	d.running--
+}
+
+// stats returns the snapshot of dispatching
+func (d *dispatcher) stats() DispatchStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	stats := DispatchStats{Pending: d.pending, Busy: d.busy, Dropped: d.dropped}
+	for _, sink := range d.sinks {
+		for _, l := range sink.lanes {
+			if len(l.msgs) > stats.MaxPending {
+				stats.MaxPending = len(l.msgs)
+			}
+		}
+	}
+	return stats
+}
+
+// close wakes up blocked dispatching, messages are dropped instead of
+// blocking until reopened
+func (d *dispatcher) close() {
+	d.lock.Lock()
+	d.closed = true
+	d.cond.Broadcast()
+	d.lock.Unlock()
+}
+
+// reopen allows blocking again after connecting again
+func (d *dispatcher) reopen() {
+	d.lock.Lock()
+	d.closed = false
+	d.lock.Unlock()
+}
@@ -3,8 +3,10 @@ package mqtt
 import (
 	"strings"
 	"sync"
+	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqhub"
 )
 
 // HandlerRef wraps over a handler
@@ -21,35 +23,64 @@ func MakeHandlerRef(handler paho.MessageHandler) *HandlerRef {
 type TopicHandlerMap struct {
 	prefix string
 	topics map[string]*handlerList
-	lock   sync.RWMutex
+	// trie indexes the handlers by the filters including the prefix,
+	// shared subscriptions are not indexed as they are delivered by their
+	// own callbacks
+	trie *TopicTrie[[]*handlerList]
+	lock sync.RWMutex
+	// last is the message handled by the callback of subscribed filters,
+	// guarded by its own lock, so matching only takes the read lock
+	last     paho.Message
+	lastLock sync.Mutex
+
+	// dispatcher delivers messages asynchronously if set
+	dispatcher *dispatcher
+	metrics    mqhub.Metrics
+	// metricsTopics labels the counters of messages by topics
+	metricsTopics bool
 }
 
 type handlerList struct {
 	filter   *TopicFilter
+	shared   bool
+	qos      byte
 	handlers []*HandlerRef
 }
 
+func newHandlerList(filter string, qos byte) *handlerList {
+	group, topicFilter := SplitSharedFilter(filter)
+	return &handlerList{filter: NewTopicFilter(topicFilter), shared: group != "", qos: qos}
+}
+
 // NewTopicHandlerMap creates a new TopicHandlerMap
 func NewTopicHandlerMap() *TopicHandlerMap {
 	return &TopicHandlerMap{
 		topics: make(map[string]*handlerList),
+		trie:   NewTopicTrie[[]*handlerList](),
 	}
 }
 
-// Add inserts filters and corresponding handler
-// the returns subs require a SUBSCRIBE
-func (m *TopicHandlerMap) Add(filters []string, handler *HandlerRef) (subs []string) {
+// Add inserts filters with QoS levels and corresponding handler
+// the returns subs require a SUBSCRIBE (new filters or existing ones
+// requesting a higher QoS)
+func (m *TopicHandlerMap) Add(filters map[string]byte, handler *HandlerRef) (subs map[string]byte) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	for _, filter := range filters {
+	subs = make(map[string]byte)
+	for filter, qos := range filters {
 		handlers := m.topics[filter]
 		if handlers == nil {
-			handlers = &handlerList{filter: NewTopicFilter(filter)}
+			handlers = newHandlerList(filter, qos)
 			m.topics[filter] = handlers
-			subs = append(subs, filter)
+			m.index(handlers)
+			subs[filter] = qos
+		} else if qos > handlers.qos {
+			handlers.qos = qos
+			subs[filter] = qos
 		}
 		handlers.handlers = append(handlers.handlers, handler)
 	}
+	m.countSubscriptions()
 	return
 }
 
@@ -66,31 +97,134 @@ func (m *TopicHandlerMap) Del(filters []string, handler *HandlerRef) (unsubs []s
 					if len(handlers.handlers) == 0 {
 						unsubs = append(unsubs, filter)
 						delete(m.topics, filter)
+						m.unindex(handlers)
 					}
 					break
 				}
 			}
 		}
 	}
+	if len(unsubs) > 0 {
+		m.countSubscriptions()
+	}
 	return
 }
 
-// HandleMessage implements paho.MessageHandler
+// countSubscriptions updates the number of subscribed filters
+func (m *TopicHandlerMap) countSubscriptions() {
+	if m.metrics != nil {
+		m.metrics.Gauge(MetricSubscriptions, nil, float64(len(m.topics)))
+	}
+}
+
+// index adds the handlers to the trie
+func (m *TopicHandlerMap) index(handlers *handlerList) {
+	if handlers.shared {
+		return
+	}
+	filter := m.prefix + handlers.filter.String()
+	lists, _ := m.trie.Get(filter)
+	m.trie.Put(filter, append(lists, handlers))
+}
+
+// unindex removes the handlers from the trie
+func (m *TopicHandlerMap) unindex(handlers *handlerList) {
+	if handlers.shared {
+		return
+	}
+	filter := m.prefix + handlers.filter.String()
+	lists, _ := m.trie.Get(filter)
+	for i, list := range lists {
+		if list == handlers {
+			lists = append(lists[:i:i], lists[i+1:]...)
+			break
+		}
+	}
+	if len(lists) == 0 {
+		m.trie.Delete(filter)
+	} else {
+		m.trie.Put(filter, lists)
+	}
+}
+
+// resubscribe returns all filters with QoS levels for SUBSCRIBE on a new
+// connection
+func (m *TopicHandlerMap) resubscribe() map[string]byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	filters := make(map[string]byte)
+	for filter, handlers := range m.topics {
+		filters[filter] = handlers.qos
+	}
+	return filters
+}
+
+// HandleMessage implements paho.MessageHandler, the message is delivered
+// to the handlers of all matching filters except shared subscriptions,
+// which are delivered by the callbacks of their own
 func (m *TopicHandlerMap) HandleMessage(client paho.Client, msg paho.Message) {
 	topic := msg.Topic()
 	if m.prefix != "" && !strings.HasPrefix(topic, m.prefix) {
 		return
 	}
-	tokens := TokenizeTopic(topic[len(m.prefix):])
+	relTopic := topic[len(m.prefix):]
+	countMessage(m.metrics, MetricMessagesIn, MetricBytesIn, topicLabels(m.metricsTopics, relTopic), len(msg.Payload()))
 	var handlers []*HandlerRef
 	m.lock.RLock()
-	for _, list := range m.topics {
-		if list.filter.MatchesTokenized(tokens) {
+	m.trie.Match(topic, func(lists []*handlerList) {
+		for _, list := range lists {
 			handlers = append(handlers, list.handlers...)
 		}
-	}
+	})
 	m.lock.RUnlock()
 	for _, handler := range handlers {
+		m.deliver(handler, client, msg)
+	}
+}
+
+// handleRoute is the callback of subscribed filters, paho invokes the
+// callbacks of all routes matching the topic with the same message, so the
+// message is only handled once
+func (m *TopicHandlerMap) handleRoute(client paho.Client, msg paho.Message) {
+	m.lastLock.Lock()
+	handled := msg == m.last
+	m.last = msg
+	m.lastLock.Unlock()
+	if !handled {
+		m.HandleMessage(client, msg)
+	}
+}
+
+// sharedHandler returns the callback of the shared subscription, which only
+// delivers to the handlers of the shared filter
+func (m *TopicHandlerMap) sharedHandler(filter string) paho.MessageHandler {
+	return func(client paho.Client, msg paho.Message) {
+		topic := msg.Topic()
+		if m.prefix != "" && !strings.HasPrefix(topic, m.prefix) {
+			return
+		}
+		countMessage(m.metrics, MetricMessagesIn, MetricBytesIn,
+			topicLabels(m.metricsTopics, topic[len(m.prefix):]), len(msg.Payload()))
+		var handlers []*HandlerRef
+		m.lock.RLock()
+		if list := m.topics[filter]; list != nil {
+			handlers = append(handlers, list.handlers...)
+		}
+		m.lock.RUnlock()
+		for _, handler := range handlers {
+			m.deliver(handler, client, msg)
+		}
+	}
+}
+
+// deliver invokes the handler directly, or queues the message if
+// dispatching asynchronously
+func (m *TopicHandlerMap) deliver(handler *HandlerRef, client paho.Client, msg paho.Message) {
+	if m.dispatcher != nil {
+		m.dispatcher.dispatch(handler, client, msg)
+	} else {
+		start := time.Now()
 		handler.Handler(client, msg)
+		observeSince(m.metrics, MetricDispatchLatency, start)
 	}
 }
@@ -1,12 +1,15 @@
 package mqtt
 
 import (
-	"encoding/json"
+	"context"
 	"path"
+	"sort"
 	"strings"
+	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/packets"
 )
 
 // EndpointTopic creates a topic for endpoints
@@ -103,12 +106,31 @@ type Message struct {
 	ComponentID  string
 	EndpointName string
 	Raw          paho.Message
+
+	// call is present if the message is a request of Call
+	call    *callInfo
+	meta    *mqhub.Metadata
+	payload []byte
+	// marked tells the payload may be marked with the content type
+	marked bool
+	// codec decodes the payload if the codec is not identified on the
+	// wire, it's the codec of the reactor
+	codec mqhub.Codec
 }
 
 // NewMessage wraps mqtt message
 func NewMessage(prefix string, msg paho.Message) *Message {
-	m := &Message{Raw: msg}
-	m.ComponentID, m.EndpointName = ParseTopic(msg.Topic(), prefix)
+	return newMessage(prefix, msg, false, false)
+}
+
+// newMessage wraps mqtt message, the metadata envelope is unwrapped
+// and the content type mark is recognized if enabled
+func newMessage(prefix string, msg paho.Message, envelope, marked bool) *Message {
+	m := &Message{Raw: msg, marked: marked}
+	var topic string
+	m.call, topic, m.payload = decodeCall(msg)
+	m.ComponentID, m.EndpointName = ParseTopic(topic, prefix)
+	m.meta, m.payload = decodeMetadata(rawProperties(msg), m.payload, envelope)
 	return m
 }
 
@@ -133,28 +155,127 @@ func (m *Message) IsState() bool {
 	return m.Raw.Retained()
 }
 
-// As implements Message
+// As implements Message, the payload is decoded by the codec
+// identified on the wire, or the codec of the reactor
 func (m *Message) As(out interface{}) error {
-	if data := m.Raw.Payload(); data != nil {
-		return json.Unmarshal(data, out)
+	if m.payload == nil {
+		return nil
 	}
-	return nil
+	codec, data := m.decodePayload()
+	return codec.Decode(data, out)
+}
+
+// Codec returns the codec which encoded the payload
+func (m *Message) Codec() mqhub.Codec {
+	codec, _ := m.decodePayload()
+	return codec
+}
+
+// decodePayload returns the codec and the payload without the mark,
+// JSON is used if the codec is neither identified nor specified
+func (m *Message) decodePayload() (mqhub.Codec, []byte) {
+	var contentType string
+	if m.call != nil {
+		contentType = m.call.Type
+	}
+	codec, data := decodePayload(rawProperties(m.Raw), contentType, m.payload, m.marked)
+	switch {
+	case codec != nil:
+		return codec, data
+	case m.codec != nil:
+		return m.codec, data
+	}
+	return mqhub.JSON, data
 }
 
 // Payload implements EncodedPayload
 func (m *Message) Payload() ([]byte, error) {
-	return m.Raw.Payload(), nil
+	return m.payload, nil
 }
 
-// Encode encodes original message into bytes
+// Metadata implements MetadataCarrier
+func (m *Message) Metadata() *mqhub.Metadata {
+	return m.meta
+}
+
+// propertiesMessage is a received message with MQTT v5 properties
+type propertiesMessage interface {
+	Properties() *packets.Properties
+}
+
+// Properties implements PropertiesCarrier
+func (m *Message) Properties() *mqhub.Properties {
+	if props := rawProperties(m.Raw); props != nil {
+		return DecodeProperties(props)
+	}
+	return nil
+}
+
+// rawProperties returns MQTT v5 properties of the received message
+func rawProperties(msg paho.Message) *packets.Properties {
+	if m, ok := msg.(propertiesMessage); ok {
+		return m.Properties()
+	}
+	return nil
+}
+
+// Encode encodes original message into bytes using the codec identified
+// by the content type of the message, or JSON
 func Encode(msg mqhub.Message) ([]byte, error) {
-	if p, ok := msg.(mqhub.EncodedPayload); ok {
-		return p.Payload()
+	return EncodeWith(msg, nil)
+}
+
+// EncodeProperties converts properties of the message to MQTT v5 properties
+// nil is returned if the message has no properties
+func EncodeProperties(msg mqhub.Message) *packets.Properties {
+	carrier, ok := msg.(mqhub.PropertiesCarrier)
+	if !ok {
+		return nil
+	}
+	props := carrier.Properties()
+	if props == nil {
+		return nil
 	}
-	if v, ok := msg.Value(); ok {
-		return json.Marshal(v)
+	encoded := &packets.Properties{ContentType: props.ContentType}
+	if props.Expiry > 0 {
+		// round up so the message doesn't expire earlier
+		encoded.MessageExpiry = packets.Uint32(uint32((props.Expiry + time.Second - 1) / time.Second))
 	}
-	return nil, nil
+	keys := make([]string, 0, len(props.User))
+	for key := range props.User {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// metadata is added from MetadataCarrier
+		if !isMetadataKey(key) {
+			encoded.Add(key, props.User[key])
+		}
+	}
+	return encoded
+}
+
+// DecodeProperties converts MQTT v5 properties to message properties
+// nil is returned if props is nil
+func DecodeProperties(props *packets.Properties) *mqhub.Properties {
+	if props == nil {
+		return nil
+	}
+	decoded := &mqhub.Properties{ContentType: props.ContentType}
+	if props.MessageExpiry != nil {
+		decoded.Expiry = time.Duration(*props.MessageExpiry) * time.Second
+	}
+	for _, prop := range props.User {
+		// metadata is available from MetadataCarrier
+		if isMetadataKey(prop.Key) {
+			continue
+		}
+		if decoded.User == nil {
+			decoded.User = make(map[string]string)
+		}
+		decoded.User[prop.Key] = prop.Value
+	}
+	return decoded
 }
 
 // Future implements mqhub.Future
@@ -172,3 +293,45 @@ func (f *Future) Wait() error {
 	}
 	return f.err
 }
+
+// WaitContext implements ContextFuture, it stops waiting when ctx is done,
+// but the operation isn't cancelled as the packet may already be sent,
+// it still completes and can be waited again
+func (f *Future) WaitContext(ctx context.Context) error {
+	if f.token != nil && !waitToken(ctx, f.token) {
+		return ctx.Err()
+	}
+	return f.Wait()
+}
+
+// doneToken is a token with a channel closed when completed
+type doneToken interface {
+	Done() <-chan struct{}
+}
+
+// tokenPollInterval is the interval checking ctx while waiting for a token
+// not providing the Done channel
+const tokenPollInterval = 10 * time.Millisecond
+
+// waitToken returns false if ctx is done before the token completes,
+// paho tokens are polled, so no goroutine is left waiting for the token
+func waitToken(ctx context.Context, token paho.Token) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	t, ok := token.(doneToken)
+	if !ok {
+		for !token.WaitTimeout(tokenPollInterval) {
+			if ctx.Err() != nil {
+				return false
+			}
+		}
+		return true
+	}
+	select {
+	case <-t.Done():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+)
+
+// MQTT v5 user properties carrying the metadata of a message
+const (
+	// MetaTimestampKey is the timestamp in RFC3339 with nanoseconds
+	MetaTimestampKey = "mqhub-ts"
+	// MetaSequenceKey is the sequence number in decimal
+	MetaSequenceKey = "mqhub-seq"
+	// MetaSourceKey is the client ID of the producer
+	MetaSourceKey = "mqhub-src"
+)
+
+func isMetadataKey(key string) bool {
+	return key == MetaTimestampKey || key == MetaSequenceKey || key == MetaSourceKey
+}
+
+// metaEnvelope wraps the payload with metadata for MQTT 3.x which has no
+// user properties, the payload not encoded as JSON is carried in Data
+type metaEnvelope struct {
+	Meta  *mqhub.Metadata `json:"$meta"`
+	Value json.RawMessage `json:"$value,omitempty"`
+	Data  []byte          `json:"$data,omitempty"`
+}
+
+var metaEnvelopePrefix = []byte(`{"$meta":`)
+
+// wrapMetadata wraps the payload in metaEnvelope
+func wrapMetadata(meta *mqhub.Metadata, payload []byte) ([]byte, error) {
+	env := &metaEnvelope{Meta: meta}
+	if payload == nil || json.Valid(payload) {
+		env.Value = payload
+	} else {
+		env.Data = payload
+	}
+	return json.Marshal(env)
+}
+
+// addMetadata adds metadata to MQTT v5 user properties
+func addMetadata(props *packets.Properties, meta *mqhub.Metadata) {
+	if !meta.Timestamp.IsZero() {
+		props.Add(MetaTimestampKey, meta.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	if meta.Sequence != 0 {
+		props.Add(MetaSequenceKey, strconv.FormatUint(meta.Sequence, 10))
+	}
+	if meta.Source != "" {
+		props.Add(MetaSourceKey, meta.Source)
+	}
+}
+
+// decodeMetadata extracts metadata from MQTT v5 user properties or the
+// envelope if enabled, and returns the actual payload
+func decodeMetadata(props *packets.Properties, payload []byte, envelope bool) (*mqhub.Metadata, []byte) {
+	if props != nil {
+		var meta *mqhub.Metadata
+		for _, prop := range props.User {
+			if !isMetadataKey(prop.Key) {
+				continue
+			}
+			if meta == nil {
+				meta = &mqhub.Metadata{}
+			}
+			switch prop.Key {
+			case MetaTimestampKey:
+				meta.Timestamp, _ = time.Parse(time.RFC3339Nano, prop.Value)
+			case MetaSequenceKey:
+				meta.Sequence, _ = strconv.ParseUint(prop.Value, 10, 64)
+			case MetaSourceKey:
+				meta.Source = prop.Value
+			}
+		}
+		if meta != nil {
+			return meta, payload
+		}
+	}
+	if envelope && bytes.HasPrefix(payload, metaEnvelopePrefix) {
+		var env metaEnvelope
+		if json.Unmarshal(payload, &env) == nil && env.Meta != nil {
+			if env.Data != nil {
+				return env.Meta, env.Data
+			}
+			return env.Meta, env.Value
+		}
+	}
+	return nil, payload
+}
+
+// metadataOf returns the metadata of msg with the source filled
+func (c *Connector) metadataOf(msg mqhub.Message) *mqhub.Metadata {
+	meta := mqhub.MetadataOf(msg)
+	if meta != nil && meta.Source == "" {
+		stamped := *meta
+		stamped.Source = c.clientID
+		meta = &stamped
+	}
+	return meta
+}
@@ -0,0 +1,100 @@
+package mqtt
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+// Metrics collected by the connector, counters of messages are labeled
+// by LabelTopic, the topic relative to the namespace, if enabled by
+// Options.MetricsTopicLabel
+const (
+	// MetricMessagesIn counts the received messages
+	MetricMessagesIn = "mqhub_messages_received_total"
+	// MetricMessagesOut counts the published messages, the messages queued
+	// while disconnected are counted once flushed
+	MetricMessagesOut = "mqhub_messages_published_total"
+	// MetricBytesIn counts the payload bytes of received messages
+	MetricBytesIn = "mqhub_received_bytes_total"
+	// MetricBytesOut counts the payload bytes of published messages
+	MetricBytesOut = "mqhub_published_bytes_total"
+	// MetricDecodeErrors counts the messages reactors failed to decode
+	MetricDecodeErrors = "mqhub_decode_errors_total"
+	// MetricPublishLatency is the histogram of seconds until a publish
+	// completes, sampled by measuring one publish at a time, messages queued
+	// while disconnected are excluded
+	MetricPublishLatency = "mqhub_publish_latency_seconds"
+	// MetricDispatchLatency is the histogram of seconds from receiving
+	// a message until a handler returns, including the time queued
+	MetricDispatchLatency = "mqhub_dispatch_latency_seconds"
+	// MetricSubscriptions is the number of subscribed topic filters
+	MetricSubscriptions = "mqhub_subscriptions"
+	// MetricDispatchPending is the number of received messages waiting
+	// for delivery by the dispatcher
+	MetricDispatchPending = "mqhub_dispatch_pending"
+	// MetricDispatchDropped counts the received messages discarded by the
+	// queue policy of the dispatcher
+	MetricDispatchDropped = "mqhub_dispatch_dropped_total"
+	// MetricQueueDropped counts the outgoing messages discarded by the
+	// policy of the queue while disconnected
+	MetricQueueDropped = "mqhub_queue_dropped_total"
+
+	// LabelTopic is the label of the topic
+	LabelTopic = "topic"
+)
+
+// topicLabels labels the topic if enabled, as topics matched by watchers
+// with wildcards are unbounded
+func topicLabels(enabled bool, topic string) mqhub.Labels {
+	if !enabled {
+		return nil
+	}
+	return mqhub.Labels{LabelTopic: topic}
+}
+
+// countMessage counts the message and payload bytes
+func countMessage(metrics mqhub.Metrics, name, bytesName string, labels mqhub.Labels, size int) {
+	if metrics == nil {
+		return
+	}
+	metrics.Count(name, labels, 1)
+	metrics.Count(bytesName, labels, float64(size))
+}
+
+// observeSince records the seconds elapsed since start
+func observeSince(metrics mqhub.Metrics, name string, start time.Time) {
+	if metrics != nil {
+		metrics.Observe(name, nil, time.Since(start).Seconds())
+	}
+}
+
+// countError counts the failure of the reactor
+func countError(metrics mqhub.Metrics, labels mqhub.Labels, err error) {
+	var decodeErr *mqhub.DecodeError
+	if metrics != nil && errors.As(err, &decodeErr) {
+		metrics.Count(MetricDecodeErrors, labels, 1)
+	}
+}
+
+// latencySampler measures one operation at a time, so waiting for the
+// completion takes at most one goroutine
+type latencySampler struct {
+	busy int32
+}
+
+// sample records the seconds until wait returns, unless another operation
+// is being measured
+func (s *latencySampler) sample(metrics mqhub.Metrics, name string, wait func()) {
+	if metrics == nil || !atomic.CompareAndSwapInt32(&s.busy, 0, 1) {
+		return
+	}
+	start := time.Now()
+	go func() {
+		wait()
+		observeSince(metrics, name, start)
+		atomic.StoreInt32(&s.busy, 0)
+	}()
+}
@@ -0,0 +1,578 @@
+// Package mqtt5 implements an MQTT v5 client
+// The client implements paho.Client so it can be used in place of the paho
+// client, and exposes MQTT v5 features: properties on published and received
+// messages, reason codes reported as errors, and topic aliases which are
+// assigned automatically to published topics when the server allows.
+// Reconnecting is not handled by the client, OnConnectionLost is invoked
+// and it's up to the caller to Connect again.
+// Sessions are not resumed, in-flight messages are not retransmitted after
+// reconnected, so CleanSession (Clean Start in MQTT v5) must be set.
+package mqtt5
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+	"github.com/robotalks/mqhub.go/mqtt/topics"
+)
+
+var (
+	// ErrNotConnected is reported when operating on a disconnected client
+	ErrNotConnected = fmt.Errorf("not connected")
+	// ErrConnectionLost is reported on pending operations when connection drops
+	ErrConnectionLost = fmt.Errorf("connection lost")
+	// ErrPingTimeout is reported when server doesn't respond to PINGREQ
+	ErrPingTimeout = fmt.Errorf("ping timeout")
+	// ErrNoServer is reported when no server is specified
+	ErrNoServer = fmt.Errorf("no server specified")
+	// ErrSessionNotSupported is reported when CleanSession is not set
+	ErrSessionNotSupported = fmt.Errorf("resuming session not supported")
+)
+
+// maxSubscriptionID is the maximum subscription identifier
+const maxSubscriptionID = 268435455
+
+// ReasonError is a failure reported by server using reason code
+type ReasonError struct {
+	Code byte
+	// Reason is the optional reason string from server
+	Reason string
+}
+
+// Error implements error
+func (e *ReasonError) Error() string {
+	msg := "mqtt: " + packets.ReasonText(e.Code)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+func reasonError(code byte, props *packets.Properties) error {
+	if !packets.IsFailure(code) {
+		return nil
+	}
+	err := &ReasonError{Code: code}
+	if props != nil {
+		err.Reason = props.ReasonString
+	}
+	return err
+}
+
+// Config defines MQTT v5 specific settings
+type Config struct {
+	// TopicAliasMaximum is the number of topic aliases accepted from server
+	TopicAliasMaximum uint16
+}
+
+// route is a subscribed filter, with the subscription identifier sent in
+// SUBSCRIBE, 0 for routes added without subscribing
+type route struct {
+	filter  string
+	id      int
+	handler paho.MessageHandler
+}
+
+// Client is an MQTT v5 client
+type Client struct {
+	opts   paho.ClientOptions
+	config Config
+	reader paho.ClientOptionsReader
+
+	conn    net.Conn
+	session *connSession
+	routes  []*route
+	lastSub int
+	lock    sync.Mutex
+	// msgs is consumed by dispatchLoop until stop is closed by Disconnect
+	msgs chan *Message
+	stop chan struct{}
+}
+
+// connSession is the state bound to a single network connection
+type connSession struct {
+	conn     net.Conn
+	reader   *packets.Reader
+	writer   sync.Mutex
+	msgs     chan *Message
+	pending  map[uint16]*Token
+	incoming map[uint16]bool
+	lastID   uint16
+	// aliasMax is the number of topic aliases allowed by server
+	aliasMax uint16
+	// noSubIDs is set if server doesn't support subscription identifiers
+	noSubIDs bool
+	aliases  map[string]uint16
+	// inAliases are topic aliases assigned by server
+	inAliases map[uint16]string
+	pinging   bool
+	closed    bool
+	done      chan struct{}
+}
+
+// NewClient creates a client
+func NewClient(opts *paho.ClientOptions, config Config) *Client {
+	c := &Client{
+		opts:   *opts,
+		config: config,
+	}
+	// ClientOptionsReader can only be created by paho, an unconnected paho
+	// client is lightweight and only used to provide it
+	c.reader = paho.NewClient(opts).OptionsReader()
+	return c
+}
+
+// IsConnected implements paho.Client
+func (c *Client) IsConnected() bool {
+	return c.IsConnectionOpen()
+}
+
+// IsConnectionOpen implements paho.Client
+func (c *Client) IsConnectionOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.session != nil
+}
+
+// Connect implements paho.Client
+func (c *Client) Connect() paho.Token {
+	token := newToken()
+	c.lock.Lock()
+	if c.stop == nil {
+		c.msgs, c.stop = make(chan *Message, 100), make(chan struct{})
+		go c.dispatchLoop(c.msgs, c.stop)
+	}
+	c.lock.Unlock()
+	go func() {
+		token.complete(c.connect())
+	}()
+	return token
+}
+
+// Disconnect implements paho.Client
+func (c *Client) Disconnect(quiesce uint) {
+	c.lock.Lock()
+	s := c.session
+	c.session = nil
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.lock.Unlock()
+	if s == nil {
+		return
+	}
+	if quiesce > 0 {
+		s.waitPending(time.Duration(quiesce) * time.Millisecond)
+	}
+	s.write(&packets.Disconnect{ReasonCode: packets.NormalDisconnection})
+	s.close(ErrNotConnected)
+}
+
+// Publish implements paho.Client
+func (c *Client) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	return c.PublishProperties(topic, qos, retained, payload, nil)
+}
+
+// PublishProperties publishes a message with MQTT v5 properties
+func (c *Client) PublishProperties(topic string, qos byte, retained bool,
+	payload interface{}, props *packets.Properties) paho.Token {
+	pkt := &packets.Publish{
+		QoS:        qos,
+		Retain:     retained,
+		Topic:      topic,
+		Properties: props.Copy(),
+	}
+	switch p := payload.(type) {
+	case string:
+		pkt.Payload = []byte(p)
+	case []byte:
+		pkt.Payload = p
+	case bytes.Buffer:
+		pkt.Payload = p.Bytes()
+	case *bytes.Buffer:
+		pkt.Payload = p.Bytes()
+	default:
+		return completedToken(fmt.Errorf("unknown payload type %T", payload))
+	}
+	if qos > 2 {
+		return completedToken(fmt.Errorf("invalid QoS %d", qos))
+	}
+	s := c.currentSession()
+	if s == nil {
+		return completedToken(ErrNotConnected)
+	}
+	if qos == 0 {
+		return completedToken(s.write(pkt))
+	}
+	token := s.request(func(id uint16) packets.Packet {
+		pkt.PacketID = id
+		return pkt
+	})
+	return token
+}
+
+// Subscribe implements paho.Client
+func (c *Client) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+// SubscribeMultiple implements paho.Client
+func (c *Client) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
+	s := c.currentSession()
+	if s == nil {
+		return completedToken(ErrNotConnected)
+	}
+	// the filters are identified together, so a message is only routed
+	// to the callbacks of the subscriptions it's delivered for
+	pkt := &packets.Subscribe{}
+	var id int
+	if !s.noSubIDs {
+		id = c.nextSubscriptionID()
+		pkt.Properties = &packets.Properties{SubscriptionIdentifier: []int{id}}
+	}
+	for filter, qos := range filters {
+		pkt.Subscriptions = append(pkt.Subscriptions, packets.Subscription{Filter: filter, QoS: qos})
+		if callback != nil {
+			c.addRoute(filter, id, callback)
+		}
+	}
+	return s.request(func(id uint16) packets.Packet {
+		pkt.PacketID = id
+		return pkt
+	})
+}
+
+// Unsubscribe implements paho.Client
+func (c *Client) Unsubscribe(topics ...string) paho.Token {
+	c.lock.Lock()
+	for _, topic := range topics {
+		for i, r := range c.routes {
+			if r.filter == topic {
+				c.routes = append(c.routes[:i], c.routes[i+1:]...)
+				break
+			}
+		}
+	}
+	s := c.session
+	c.lock.Unlock()
+	if s == nil {
+		return completedToken(ErrNotConnected)
+	}
+	return s.request(func(id uint16) packets.Packet {
+		return &packets.Unsubscribe{PacketID: id, Filters: topics}
+	})
+}
+
+// AddRoute implements paho.Client
+func (c *Client) AddRoute(topic string, callback paho.MessageHandler) {
+	c.addRoute(topic, 0, callback)
+}
+
+func (c *Client) addRoute(topic string, id int, callback paho.MessageHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.routes {
+		if r.filter == topic {
+			r.id, r.handler = id, callback
+			return
+		}
+	}
+	c.routes = append(c.routes, &route{filter: topic, id: id, handler: callback})
+}
+
+// nextSubscriptionID allocates a subscription identifier
+func (c *Client) nextSubscriptionID() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.lastSub >= maxSubscriptionID {
+		c.lastSub = 0
+	}
+	c.lastSub++
+	return c.lastSub
+}
+
+// matches returns whether the message is delivered for the route, by the
+// subscription identifiers if the server sends them
+func (r *route) matches(msg *Message) bool {
+	if !topics.Matches(r.filter, msg.Topic()) {
+		return false
+	}
+	props := msg.Properties()
+	if r.id == 0 || props == nil || len(props.SubscriptionIdentifier) == 0 {
+		return true
+	}
+	for _, id := range props.SubscriptionIdentifier {
+		if id == r.id {
+			return true
+		}
+	}
+	return false
+}
+
+// OptionsReader implements paho.Client
+func (c *Client) OptionsReader() paho.ClientOptionsReader {
+	return c.reader
+}
+
+func (c *Client) currentSession() *connSession {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.session
+}
+
+func (c *Client) connect() error {
+	if len(c.opts.Servers) == 0 {
+		return ErrNoServer
+	}
+	if !c.opts.CleanSession {
+		return ErrSessionNotSupported
+	}
+	var err error
+	for _, server := range c.opts.Servers {
+		var s *connSession
+		if s, err = c.connectServer(server); err == nil {
+			c.lock.Lock()
+			prev := c.session
+			c.session, s.msgs = s, c.msgs
+			c.lock.Unlock()
+			if prev != nil {
+				prev.close(ErrConnectionLost)
+			}
+			go c.readLoop(s)
+			if c.opts.KeepAlive > 0 {
+				go c.keepAlive(s, time.Duration(c.opts.KeepAlive)*time.Second)
+			}
+			if c.opts.OnConnect != nil {
+				go c.opts.OnConnect(c)
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+func dial(server *url.URL, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	switch server.Scheme {
+	case "tcp", "mqtt":
+		return dialer.Dial("tcp", server.Host)
+	case "ssl", "tls", "tcps", "mqtts":
+		return tls.DialWithDialer(dialer, "tcp", server.Host, tlsConfig)
+	}
+	return nil, fmt.Errorf("unsupported scheme %s", server.Scheme)
+}
+
+func (c *Client) connectServer(server *url.URL) (*connSession, error) {
+	conn, err := dial(server, c.opts.TLSConfig, c.opts.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	connect := &packets.Connect{
+		ProtocolName:  "MQTT",
+		ProtocolLevel: packets.ProtocolV5,
+		CleanSession:  c.opts.CleanSession,
+		KeepAlive:     uint16(c.opts.KeepAlive),
+		ClientID:      c.opts.ClientID,
+	}
+	if c.opts.Username != "" {
+		connect.Username = &c.opts.Username
+	}
+	if c.opts.Password != "" {
+		connect.Password = []byte(c.opts.Password)
+	}
+	if c.opts.WillEnabled {
+		connect.Will = &packets.Will{
+			Topic:   c.opts.WillTopic,
+			Payload: c.opts.WillPayload,
+			QoS:     c.opts.WillQos,
+			Retain:  c.opts.WillRetained,
+		}
+	}
+	props := &packets.Properties{}
+	if c.config.TopicAliasMaximum > 0 {
+		props.TopicAliasMaximum = packets.Uint16(c.config.TopicAliasMaximum)
+	}
+	connect.Properties = props
+
+	if c.opts.ConnectTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.opts.ConnectTimeout))
+	}
+	if err = packets.WritePacket(conn, connect, packets.ProtocolV5); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := packets.NewReader(conn)
+	reader.Version = packets.ProtocolV5
+	pkt, err := reader.ReadPacket()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	connack, ok := pkt.(*packets.Connack)
+	if !ok {
+		conn.Close()
+		return nil, packets.ErrMalformed
+	}
+	if err = reasonError(connack.ReturnCode, connack.Properties); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	s := &connSession{
+		conn:      conn,
+		pending:   make(map[uint16]*Token),
+		incoming:  make(map[uint16]bool),
+		aliases:   make(map[string]uint16),
+		inAliases: make(map[uint16]string),
+		done:      make(chan struct{}),
+	}
+	if connack.Properties != nil && connack.Properties.SubIDAvailable != nil {
+		s.noSubIDs = *connack.Properties.SubIDAvailable == 0
+	}
+	if connack.Properties != nil && connack.Properties.TopicAliasMaximum != nil {
+		s.aliasMax = *connack.Properties.TopicAliasMaximum
+	}
+	s.reader = reader
+	return s, nil
+}
+
+// connectionLost is invoked when the connection drops unexpectedly
+func (c *Client) connectionLost(s *connSession, err error) {
+	c.lock.Lock()
+	current := c.session == s
+	if current {
+		c.session = nil
+	}
+	c.lock.Unlock()
+	s.close(ErrConnectionLost)
+	if current && c.opts.OnConnectionLost != nil {
+		go c.opts.OnConnectionLost(c, err)
+	}
+}
+
+func (c *Client) readLoop(s *connSession) {
+	for {
+		pkt, err := s.reader.ReadPacket()
+		if err != nil {
+			c.connectionLost(s, err)
+			return
+		}
+		if err = c.handlePacket(s, pkt); err != nil {
+			c.connectionLost(s, err)
+			return
+		}
+	}
+}
+
+func (c *Client) handlePacket(s *connSession, pkt packets.Packet) error {
+	switch p := pkt.(type) {
+	case *packets.Publish:
+		if !s.resolveTopic(p) {
+			s.write(&packets.Disconnect{ReasonCode: packets.TopicAliasInvalid})
+			return &ReasonError{Code: packets.TopicAliasInvalid}
+		}
+		switch p.QoS {
+		case 0:
+			s.deliver(p)
+		case 1:
+			s.deliver(p)
+			s.write(&packets.Ack{PacketType: packets.PUBACK, PacketID: p.PacketID})
+		case 2:
+			if s.received(p.PacketID) {
+				s.deliver(p)
+			}
+			s.write(&packets.Ack{PacketType: packets.PUBREC, PacketID: p.PacketID})
+		}
+	case *packets.Ack:
+		switch p.PacketType {
+		case packets.PUBACK, packets.PUBCOMP:
+			s.completed(p.PacketID, reasonError(p.ReasonCode, p.Properties))
+		case packets.PUBREC:
+			if err := reasonError(p.ReasonCode, p.Properties); err != nil {
+				s.completed(p.PacketID, err)
+			} else {
+				s.write(&packets.Ack{PacketType: packets.PUBREL, PacketID: p.PacketID})
+			}
+		case packets.PUBREL:
+			s.released(p.PacketID)
+			s.write(&packets.Ack{PacketType: packets.PUBCOMP, PacketID: p.PacketID})
+		}
+	case *packets.Suback:
+		s.completed(p.PacketID, firstFailure(p.ReturnCodes, p.Properties))
+	case *packets.Unsuback:
+		s.completed(p.PacketID, firstFailure(p.ReasonCodes, p.Properties))
+	case *packets.Empty:
+		if p.PacketType == packets.PINGRESP {
+			s.pong()
+		}
+	case *packets.Disconnect:
+		if err := reasonError(p.ReasonCode, p.Properties); err != nil {
+			return err
+		}
+		return ErrConnectionLost
+	default:
+		return packets.ErrMalformed
+	}
+	return nil
+}
+
+func firstFailure(codes []byte, props *packets.Properties) error {
+	for _, code := range codes {
+		if err := reasonError(code, props); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) keepAlive(s *connSession, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if !s.ping() {
+				c.connectionLost(s, ErrPingTimeout)
+				return
+			}
+		}
+	}
+}
+
+// dispatchLoop invokes handlers in a separate goroutine, so handlers are
+// allowed to use the client, and messages are delivered in order
+func (c *Client) dispatchLoop(msgs chan *Message, stop chan struct{}) {
+	for {
+		var msg *Message
+		select {
+		case msg = <-msgs:
+		case <-stop:
+			return
+		}
+		var handlers []paho.MessageHandler
+		c.lock.Lock()
+		for _, r := range c.routes {
+			if r.matches(msg) {
+				handlers = append(handlers, r.handler)
+			}
+		}
+		c.lock.Unlock()
+		if len(handlers) == 0 && c.opts.DefaultPublishHandler != nil {
+			handlers = append(handlers, c.opts.DefaultPublishHandler)
+		}
+		for _, handler := range handlers {
+			handler(c, msg)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package mqtt5_test
+
+import (
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/robotalks/mqhub.go/mqtt/mqtt5"
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+	"github.com/stretchr/testify/assert"
+)
+
+func startBroker(t *testing.T) *broker.Broker {
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func connect(t *testing.T, b *broker.Broker, id string) *mqtt5.Client {
+	opts := paho.NewClientOptions().AddBroker(b.URL()).SetClientID(id)
+	client := mqtt5.NewClient(opts, mqtt5.Config{})
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	return client
+}
+
+func subscribe(t *testing.T, client paho.Client, filter string) chan paho.Message {
+	ch := make(chan paho.Message, 16)
+	token := client.Subscribe(filter, 1, func(_ paho.Client, msg paho.Message) {
+		ch <- msg
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	return ch
+}
+
+func recv(t *testing.T, ch chan paho.Message) paho.Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+func waitToken(token paho.Token) error {
+	token.Wait()
+	return token.Error()
+}
+
+func TestPublishProperties(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	pub := connect(t, b, "pub")
+	defer pub.Disconnect(0)
+	sub := connect(t, b, "sub")
+	defer sub.Disconnect(0)
+	ch := subscribe(t, sub, "t/#")
+
+	props := &packets.Properties{ContentType: "application/json"}
+	props.Add("k", "v")
+	props.MessageExpiry = packets.Uint32(60)
+	// the second publish uses the topic alias established by the first one
+	for i := 0; i < 2; i++ {
+		a.NoError(waitToken(pub.PublishProperties("t/a", 1, false, "1", props)))
+		msg := recv(t, ch)
+		a.Equal("t/a", msg.Topic())
+		a.Equal("1", string(msg.Payload()))
+		received := msg.(*mqtt5.Message).Properties()
+		if a.NotNil(received) {
+			a.Equal("application/json", received.ContentType)
+			value, ok := received.Get("k")
+			a.True(ok)
+			a.Equal("v", value)
+			a.Nil(received.TopicAlias)
+			if a.NotNil(received.MessageExpiry) {
+				a.True(*received.MessageExpiry <= 60)
+			}
+		}
+	}
+}
+
+func TestMessageExpiry(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	pub := connect(t, b, "pub")
+	defer pub.Disconnect(0)
+	props := &packets.Properties{MessageExpiry: packets.Uint32(1)}
+	a.NoError(waitToken(pub.PublishProperties("e/a", 1, true, "1", props)))
+	a.NotNil(b.Retained("e/a"))
+	time.Sleep(1100 * time.Millisecond)
+	a.Nil(b.Retained("e/a"))
+}
+
+func TestReasonCodes(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	client := connect(t, b, "client")
+	defer client.Disconnect(0)
+	err := waitToken(client.Subscribe("a/#/b", 0, nil))
+	if a.Error(err) {
+		reason, ok := err.(*mqtt5.ReasonError)
+		if a.True(ok) {
+			a.Equal(packets.TopicFilterInvalid, reason.Code)
+		}
+	}
+	err = waitToken(client.Unsubscribe("not/subscribed"))
+	a.NoError(err)
+}
+
+func TestInteroperability(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	pub := connect(t, b, "pub")
+	defer pub.Disconnect(0)
+	opts := paho.NewClientOptions().AddBroker(b.URL()).SetClientID("sub3")
+	sub := paho.NewClient(opts)
+	a.NoError(waitToken(sub.Connect()))
+	defer sub.Disconnect(0)
+	ch := subscribe(t, sub, "i/#")
+
+	props := &packets.Properties{}
+	props.Add("k", "v")
+	a.NoError(waitToken(pub.PublishProperties("i/a", 1, false, "1", props)))
+	msg := recv(t, ch)
+	a.Equal("i/a", msg.Topic())
+	a.Equal("1", string(msg.Payload()))
+}
+
+func TestExactlyOnce(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	pub := connect(t, b, "pub")
+	defer pub.Disconnect(0)
+	sub := connect(t, b, "sub")
+	defer sub.Disconnect(0)
+	ch := subscribe(t, sub, "q/#")
+
+	a.NoError(waitToken(pub.Publish("q/a", 2, false, "1")))
+	msg := recv(t, ch)
+	a.Equal("q/a", msg.Topic())
+	// subscriptions are granted at most QoS 1 by the embedded broker
+	a.Equal(byte(1), msg.Qos())
+}
+
+func TestCleanSessionRequired(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	opts := paho.NewClientOptions().AddBroker(b.URL()).SetClientID("client").SetCleanSession(false)
+	client := mqtt5.NewClient(opts, mqtt5.Config{})
+	a.Equal(mqtt5.ErrSessionNotSupported, waitToken(client.Connect()))
+	client.Disconnect(0)
+}
+
+func TestConnectAfterDisconnect(t *testing.T) {
+	a := assert.New(t)
+	b := startBroker(t)
+	defer b.Close()
+
+	client := connect(t, b, "client")
+	client.Disconnect(0)
+	// the dispatching is stopped by Disconnect, and started again
+	a.NoError(waitToken(client.Connect()))
+	defer client.Disconnect(0)
+	ch := subscribe(t, client, "a/b")
+	a.NoError(waitToken(client.Publish("a/b", 1, false, "hello")))
+	a.Equal("hello", string(recv(t, ch).Payload()))
+}
@@ -0,0 +1,105 @@
+package mqtt5
+
+import (
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+)
+
+// Token implements paho.Token
+type Token struct {
+	done chan struct{}
+	err  error
+}
+
+func newToken() *Token {
+	return &Token{done: make(chan struct{})}
+}
+
+func completedToken(err error) *Token {
+	t := newToken()
+	t.complete(err)
+	return t
+}
+
+func (t *Token) complete(err error) {
+	t.err = err
+	close(t.done)
+}
+
+// Wait implements paho.Token
+func (t *Token) Wait() bool {
+	<-t.done
+	return true
+}
+
+// WaitTimeout implements paho.Token
+func (t *Token) WaitTimeout(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Done returns a channel closed when the operation completes
+func (t *Token) Done() <-chan struct{} {
+	return t.done
+}
+
+// Error implements paho.Token
+func (t *Token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// Message is a received message, implements paho.Message
+type Message struct {
+	pkt *packets.Publish
+}
+
+// Duplicate implements paho.Message
+func (m *Message) Duplicate() bool {
+	return m.pkt.Dup
+}
+
+// Qos implements paho.Message
+func (m *Message) Qos() byte {
+	return m.pkt.QoS
+}
+
+// Retained implements paho.Message
+func (m *Message) Retained() bool {
+	return m.pkt.Retain
+}
+
+// Topic implements paho.Message
+func (m *Message) Topic() string {
+	return m.pkt.Topic
+}
+
+// MessageID implements paho.Message
+func (m *Message) MessageID() uint16 {
+	return m.pkt.PacketID
+}
+
+// Payload implements paho.Message
+func (m *Message) Payload() []byte {
+	return m.pkt.Payload
+}
+
+// Ack implements paho.Message, the message is acknowledged once received
+func (m *Message) Ack() {
+}
+
+// Properties returns MQTT v5 properties of the message, nil if none
+func (m *Message) Properties() *packets.Properties {
+	return m.pkt.Properties
+}
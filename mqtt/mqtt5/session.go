@@ -0,0 +1,182 @@
+package mqtt5
+
+import (
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+)
+
+// write encodes the packet and sends it, topic aliases are applied to PUBLISH
+// here as aliases must be established in the order packets are sent
+func (s *connSession) write(pkt packets.Packet) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	if s.closed {
+		return ErrNotConnected
+	}
+	if p, ok := pkt.(*packets.Publish); ok {
+		pkt = s.applyAlias(p)
+	}
+	return packets.WritePacket(s.conn, pkt, packets.ProtocolV5)
+}
+
+func (s *connSession) applyAlias(p *packets.Publish) *packets.Publish {
+	if s.aliasMax == 0 {
+		return p
+	}
+	alias, exists := s.aliases[p.Topic]
+	if !exists {
+		if len(s.aliases) >= int(s.aliasMax) {
+			return p
+		}
+		alias = uint16(len(s.aliases) + 1)
+		s.aliases[p.Topic] = alias
+	}
+	out := p.Copy()
+	if out.Properties == nil {
+		out.Properties = &packets.Properties{}
+	}
+	out.Properties.TopicAlias = packets.Uint16(alias)
+	if exists {
+		out.Topic = ""
+	}
+	return out
+}
+
+// request sends a packet requiring acknowledgement, the returned token is
+// completed when acknowledged
+func (s *connSession) request(build func(id uint16) packets.Packet) *Token {
+	token := newToken()
+	s.writer.Lock()
+	if s.closed {
+		s.writer.Unlock()
+		token.complete(ErrNotConnected)
+		return token
+	}
+	id := s.nextID()
+	s.pending[id] = token
+	s.writer.Unlock()
+	if err := s.write(build(id)); err != nil {
+		s.completed(id, err)
+	}
+	return token
+}
+
+func (s *connSession) nextID() uint16 {
+	for {
+		s.lastID++
+		if s.lastID == 0 {
+			continue
+		}
+		if _, inuse := s.pending[s.lastID]; !inuse {
+			return s.lastID
+		}
+	}
+}
+
+func (s *connSession) completed(id uint16, err error) {
+	s.writer.Lock()
+	token := s.pending[id]
+	delete(s.pending, id)
+	s.writer.Unlock()
+	if token != nil {
+		token.complete(err)
+	}
+}
+
+// received records the packet ID of an incoming QoS 2 PUBLISH,
+// returns false if it's already received
+func (s *connSession) received(id uint16) bool {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+	if s.incoming[id] {
+		return false
+	}
+	s.incoming[id] = true
+	return true
+}
+
+func (s *connSession) released(id uint16) {
+	s.writer.Lock()
+	delete(s.incoming, id)
+	s.writer.Unlock()
+}
+
+// deliver queues an incoming PUBLISH for dispatching, it's dropped if the
+// session is closed meanwhile
+func (s *connSession) deliver(p *packets.Publish) {
+	select {
+	case s.msgs <- &Message{pkt: p}:
+	case <-s.done:
+	}
+}
+
+// resolveTopic applies topic alias assigned by server, it's only
+// invoked from the read loop
+func (s *connSession) resolveTopic(p *packets.Publish) bool {
+	if p.Properties == nil || p.Properties.TopicAlias == nil {
+		return p.Topic != ""
+	}
+	alias := *p.Properties.TopicAlias
+	if p.Topic == "" {
+		topic, ok := s.inAliases[alias]
+		if !ok {
+			return false
+		}
+		p.Topic = topic
+	} else {
+		s.inAliases[alias] = p.Topic
+	}
+	p.Properties.TopicAlias = nil
+	return true
+}
+
+// ping sends PINGREQ, returns false if the previous one is not responded
+func (s *connSession) ping() bool {
+	s.writer.Lock()
+	pinging := s.pinging
+	s.pinging = true
+	s.writer.Unlock()
+	if pinging {
+		return false
+	}
+	return s.write(&packets.Empty{PacketType: packets.PINGREQ}) == nil
+}
+
+func (s *connSession) pong() {
+	s.writer.Lock()
+	s.pinging = false
+	s.writer.Unlock()
+}
+
+// waitPending waits until all pending requests are completed or timeout
+func (s *connSession) waitPending(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		s.writer.Lock()
+		n := len(s.pending)
+		s.writer.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// close shuts down the connection and fails all pending requests
+func (s *connSession) close(err error) {
+	s.writer.Lock()
+	if s.closed {
+		s.writer.Unlock()
+		return
+	}
+	s.closed = true
+	pending := s.pending
+	s.pending = make(map[uint16]*Token)
+	close(s.done)
+	s.writer.Unlock()
+	s.conn.Close()
+	for _, token := range pending {
+		token.complete(err)
+	}
+}
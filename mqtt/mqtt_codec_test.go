@@ -0,0 +1,203 @@
+package mqtt_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/codec/cbor"
+	"github.com/robotalks/mqhub.go/codec/msgpack"
+	"github.com/robotalks/mqhub.go/codec/protobuf"
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecComp struct {
+	mqhub.ComponentBase
+	packed *mqhub.DataPoint
+	cbor   *mqhub.DataPoint
+	square *mqhub.Reactor
+	raw    *mqhub.Reactor
+}
+
+func newCodecComp() *codecComp {
+	c := &codecComp{
+		packed: mqhub.NewRetainDataPoint("packed"),
+		cbor:   mqhub.NewRetainDataPoint("cbor").WithCodec(cbor.Codec),
+		square: mqhub.ReactorAs("square", func(val int) (int, error) {
+			return val * val, nil
+		}),
+	}
+	c.SetID("codec")
+	return c
+}
+
+func (c *codecComp) Endpoints() []mqhub.Endpoint {
+	endpoints := []mqhub.Endpoint{c.packed, c.cbor, c.square}
+	if c.raw != nil {
+		endpoints = append(endpoints, c.raw)
+	}
+	return endpoints
+}
+
+func TestCodec(t *testing.T) {
+	for _, version := range []string{"3", "5"} {
+		testCodec(t, version)
+	}
+}
+
+func testCodec(t *testing.T, version string) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	prefix := "codec-" + utils.UniqueID()
+	// MQTT 3.x identifies the codec by the mark prepended to payloads
+	baseURL := "mqtt+" + b.URL() + "/" + prefix + "?qos=1&content-type-mark=true&version=" + version
+
+	// msgpack is the default of the host
+	host, err := mqhub.NewConnector(baseURL + "&codec=msgpack")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := newCodecComp()
+	if _, err = host.Publish(comp); !a.NoError(err) {
+		return
+	}
+	a.NoError(comp.packed.Update(mqhub.StateFrom("packed")).Wait())
+	a.NoError(comp.cbor.Update(mqhub.StateFrom("cbor")).Wait())
+
+	retained := b.Retained(prefix + "/codec/packed")
+	if a.NotNil(retained) {
+		if version == "5" {
+			a.Equal(msgpack.ContentType, retained.Properties.ContentType)
+			a.Equal([]byte("\xa6packed"), retained.Payload)
+		} else {
+			a.Equal([]byte("\x00"+msgpack.ContentType+"\x00\xa6packed"), retained.Payload)
+		}
+	}
+
+	// the receiver decodes by the codec identified on the wire
+	client, err := mqhub.NewConnector(baseURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("codec")
+	for _, name := range []string{"packed", "cbor"} {
+		valCh := make(chan string, 1)
+		watcher, err := desc.Endpoint(name).Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+			var val string
+			a.NoError(msg.As(&val))
+			valCh <- val
+			return nil
+		}))
+		if !a.NoError(err) {
+			return
+		}
+		select {
+		case val := <-valCh:
+			a.Equal(name, val)
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout")
+		}
+		watcher.Close()
+	}
+
+	// per message codec overrides the endpoint
+	a.NoError(comp.packed.Update(mqhub.StateFrom("json").WithCodec(mqhub.JSON)).Wait())
+	if retained := b.Retained(prefix + "/codec/packed"); a.NotNil(retained) {
+		a.Equal(`"json"`, string(retained.Payload))
+	}
+	a.NoError(desc.Endpoint("packed").WithCodec(protobuf.Codec).
+		ConsumeMessage(mqhub.StateFrom(wrapperspb.String("proto"))).Wait())
+	valCh := make(chan string, 1)
+	_, err = desc.Endpoint("packed").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var val wrapperspb.StringValue
+		if msg.As(&val) == nil {
+			valCh <- val.Value
+		}
+		return nil
+	}))
+	if a.NoError(err) {
+		select {
+		case val := <-valCh:
+			a.Equal("proto", val)
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	// the reply is encoded by the codec of the request
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var result int
+	if a.NoError(desc.Endpoint("square").WithCodec(cbor.Codec).Call(ctx, 5, &result)) {
+		a.Equal(25, result)
+	}
+}
+
+func TestCodecWithoutMark(t *testing.T) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	prefix := "codec-" + utils.UniqueID()
+	baseURL := "mqtt+" + b.URL() + "/" + prefix + "?qos=1&version=3"
+
+	host, err := mqhub.NewConnector(baseURL + "&codec=msgpack")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := newCodecComp()
+	valCh := make(chan string, 1)
+	comp.raw = mqhub.ReactorAs("raw", func(val string) { valCh <- val }).WithCodec(msgpack.Codec)
+	if _, err = host.Publish(comp); !a.NoError(err) {
+		return
+	}
+	a.NoError(comp.packed.Update(mqhub.StateFrom("packed")).Wait())
+	if retained := b.Retained(prefix + "/codec/packed"); a.NotNil(retained) {
+		a.Equal([]byte("\xa6packed"), retained.Payload)
+	}
+
+	client, err := mqhub.NewConnector(baseURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	// the reactor decodes the payload from a client not using mqhub
+	token := client.(*mqtt.Connector).Client.Publish(prefix+"/codec/raw", 1, false, []byte("\xa5plain"))
+	token.Wait()
+	a.NoError(token.Error())
+	select {
+	case val := <-valCh:
+		a.Equal("plain", val)
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	// the codec of a request is carried by the call envelope
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var result int
+	if a.NoError(client.Describe("codec").Endpoint("square").WithCodec(cbor.Codec).Call(ctx, 5, &result)) {
+		a.Equal(25, result)
+	}
+}
+
+func TestCodecOption(t *testing.T) {
+	_, err := mqhub.NewConnector("mqtt://127.0.0.1:1/?codec=unknown")
+	assert.Error(t, err)
+	_, err = mqhub.NewConnector("mqtt://127.0.0.1:1/?codec=" + cbor.ContentType)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,107 @@
+package mqtt_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectContext(t *testing.T) {
+	a := assert.New(t)
+	// a server never answers CONNECT
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	for _, version := range []string{"3", "5"} {
+		conn, err := mqhub.NewConnector("mqtt://" + l.Addr().String() + "/?version=" + version)
+		if !a.NoError(err) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		a.Equal(context.DeadlineExceeded, conn.ConnectContext(ctx))
+		cancel()
+		// fail the pending attempt
+		(<-conns).Close()
+	}
+}
+
+func TestPublishContext(t *testing.T) {
+	a := assert.New(t)
+	prefix := "ctx-" + utils.UniqueID()
+	host, err := TestEnv.NewConnector(prefix, "ctx-host")
+	if !a.NoError(err) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if !a.NoError(host.ConnectContext(ctx)) {
+		return
+	}
+	defer host.Close()
+
+	pub0 := NewPub0()
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	_, err = host.PublishContext(canceled, pub0)
+	a.Equal(context.Canceled, err)
+
+	_, err = host.PublishContext(ctx, pub0)
+	a.NoError(err)
+	a.NoError(mqhub.WaitContext(ctx, pub0.Comp0.state0.Update(1)))
+
+	stateCh := make(chan pubState, 1)
+	_, err = mqhub.WatchContext(ctx, host.Describe("pub0").SubComponent("comp0").Endpoint("state0"), makeSinkFunc(t, stateCh))
+	a.NoError(err)
+	a.Equal(1, recvState(t, stateCh).state)
+}
+
+func TestConnectContextCanceled(t *testing.T) {
+	a := assert.New(t)
+	conn, err := TestEnv.NewConnector("ctx-"+utils.UniqueID(), "ctx-canceled")
+	if !a.NoError(err) {
+		return
+	}
+	defer conn.Close()
+	states := make(chan mqhub.ConnectionState, 4)
+	watcher, err := conn.Lifecycle().Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var state mqhub.ConnectionState
+		a.NoError(msg.As(&state))
+		states <- state
+		return nil
+	}))
+	if !a.NoError(err) {
+		return
+	}
+	defer watcher.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Equal(context.Canceled, conn.ConnectContext(ctx))
+	// the attempt is not aborted, nor the connector closed
+	for _, expected := range []mqhub.ConnectionState{mqhub.Disconnected, mqhub.Connected} {
+		select {
+		case state := <-states:
+			a.Equal(expected, state)
+		case <-time.After(3 * time.Second):
+			a.Fail("not connected")
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mqtt_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type discoverPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type discoverComp struct {
+	mqhub.ComponentBase
+	Pos  *mqhub.TypedDataPoint[discoverPoint] `mqhub:"pos,retain"`
+	Stop func() error                         `mqhub:"stop"`
+	Arm  struct {
+		Angle *mqhub.DataPoint `mqhub:"angle"`
+	} `mqhub:"arm"`
+}
+
+func TestDiscover(t *testing.T) {
+	for _, version := range []string{"3", "5"} {
+		testDiscover(t, version)
+	}
+}
+
+func testDiscover(t *testing.T, version string) {
+	a := assert.New(t)
+	prefix := "discover-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "discover-host") + "&qos=1&version=" + version)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	c := &discoverComp{Stop: func() error { return nil }}
+	c.SetID("discovered")
+	comp, err := mqhub.ComponentOf(c)
+	if !a.NoError(err) {
+		return
+	}
+	pub, err := host.Publish(comp)
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "discover-client") + "&version=" + version)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	descs, err := client.Discover(ctx)
+	if !a.NoError(err) || !a.Len(descs, 1) {
+		return
+	}
+	desc := descs[0]
+	a.Equal("discovered", desc.ID())
+	if a.Len(desc.Endpoints(), 2) {
+		pos := desc.Endpoints()[0]
+		a.Equal("pos", pos.Name)
+		a.Equal(mqhub.DataPointKind, pos.Kind)
+		a.True(pos.Retain)
+		a.Equal("object", pos.Schema["type"])
+		a.Contains(pos.Schema["properties"], "x")
+		stop := desc.Endpoints()[1]
+		a.Equal("stop", stop.Name)
+		a.Equal(mqhub.ReactorKind, stop.Kind)
+	}
+	if subs := desc.SubComponents(); a.Len(subs, 1) {
+		a.Equal("arm", subs[0].ID())
+		a.Len(subs[0].Endpoints(), 1)
+	}
+	a.NoError(desc.Endpoint("stop").ConsumeMessage(mqhub.MsgFrom(nil)).Wait())
+
+	a.NoError(pub.Close())
+	descs, err = client.Discover(ctx)
+	a.NoError(err)
+	a.Empty(descs)
+}
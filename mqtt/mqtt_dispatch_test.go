@@ -0,0 +1,171 @@
+package mqtt_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type dispatchComp struct {
+	mqhub.ComponentBase
+	Slow func(int) `mqhub:"slow"`
+	Fast func(int) `mqhub:"fast"`
+}
+
+// dispatchHost publishes a dispatchComp, the slow reactor blocks until
+// release is closed
+type dispatchHost struct {
+	conn    *mqtt.Connector
+	release chan struct{}
+	fast    chan int
+	slow    []int
+	lock    sync.Mutex
+}
+
+func newDispatchHost(a *assert.Assertions, prefix, query string) *dispatchHost {
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "dispatch-host") + "&qos=1&" + query)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return nil
+	}
+	h := &dispatchHost{conn: host.(*mqtt.Connector), release: make(chan struct{}), fast: make(chan int, 100)}
+	c := &dispatchComp{
+		Slow: func(val int) {
+			<-h.release
+			h.lock.Lock()
+			h.slow = append(h.slow, val)
+			h.lock.Unlock()
+		},
+		Fast: func(val int) { h.fast <- val },
+	}
+	c.SetID("comp")
+	comp, err := mqhub.ComponentOf(c)
+	if !a.NoError(err) {
+		host.Close()
+		return nil
+	}
+	if _, err = host.Publish(comp); !a.NoError(err) {
+		host.Close()
+		return nil
+	}
+	return h
+}
+
+func (h *dispatchHost) slowValues() []int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]int(nil), h.slow...)
+}
+
+func TestDispatchSlowReactor(t *testing.T) {
+	a := assert.New(t)
+	prefix := "dispatch-" + utils.UniqueID()
+	host := newDispatchHost(a, prefix, "dispatch-workers=2")
+	if host == nil {
+		return
+	}
+	defer host.conn.Close()
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "dispatch-client") + "&qos=1")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("comp")
+	for i := 0; i < 20; i++ {
+		a.NoError(desc.Endpoint("slow").ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	// the blocked reactor doesn't stall the others
+	a.NoError(desc.Endpoint("fast").ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+	select {
+	case val := <-host.fast:
+		a.Equal(1, val)
+	case <-time.After(3 * time.Second):
+		a.Fail("fast reactor stalled")
+	}
+	a.Eventually(func() bool {
+		return host.conn.DispatchStats().Pending == 19
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// messages on the same topic are delivered in order
+	close(host.release)
+	a.Eventually(func() bool {
+		return len(host.slowValues()) == 20
+	}, 3*time.Second, 10*time.Millisecond)
+	for i, val := range host.slowValues() {
+		a.Equal(i, val)
+	}
+	a.Equal(mqtt.DispatchStats{}, host.conn.DispatchStats())
+}
+
+func TestDispatchOverflow(t *testing.T) {
+	a := assert.New(t)
+	prefix := "dispatch-overflow-" + utils.UniqueID()
+	host := newDispatchHost(a, prefix, "dispatch-workers=1&dispatch-queue-size=2&dispatch-policy=drop-newest")
+	if host == nil {
+		return
+	}
+	defer host.conn.Close()
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "dispatch-client") + "&qos=1")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("comp")
+	for i := 0; i < 5; i++ {
+		a.NoError(desc.Endpoint("slow").ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	// one is being delivered, two are queued, and the rest are dropped
+	a.Eventually(func() bool {
+		stats := host.conn.DispatchStats()
+		return stats.Dropped == 2 && stats.MaxPending == 2 && stats.Busy == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	close(host.release)
+	a.Eventually(func() bool {
+		return len(host.slowValues()) == 3
+	}, 3*time.Second, 10*time.Millisecond)
+	a.Equal([]int{0, 1, 2}, host.slowValues())
+}
+
+func TestDispatchOverflowPerEndpoint(t *testing.T) {
+	a := assert.New(t)
+	prefix := "dispatch-endpoint-" + utils.UniqueID()
+	host := newDispatchHost(a, prefix, "dispatch-workers=2&dispatch-queue-size=2&dispatch-policy=drop-newest")
+	if host == nil {
+		return
+	}
+	defer host.conn.Close()
+	defer close(host.release)
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "dispatch-client") + "&qos=1")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("comp")
+	for i := 0; i < 5; i++ {
+		a.NoError(desc.Endpoint("slow").ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	a.Eventually(func() bool {
+		return host.conn.DispatchStats().Dropped == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	// the full queue of the slow reactor doesn't drop messages of the others
+	for i := 0; i < 2; i++ {
+		a.NoError(desc.Endpoint("fast").ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case val := <-host.fast:
+			a.Equal(i, val)
+		case <-time.After(3 * time.Second):
+			a.Fail("message dropped")
+			return
+		}
+	}
+	a.Equal(uint64(2), host.conn.DispatchStats().Dropped)
+}
@@ -0,0 +1,109 @@
+package mqtt_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDynamicComposition(t *testing.T) {
+	a := assert.New(t)
+	prefix := "dynamic-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "dynamic-host") + "&qos=1")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := &mqhub.DynamicBase{}
+	comp.SetID("dynamic")
+	_, err = host.Publish(comp)
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "dynamic-client") + "&qos=1")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("dynamic")
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sub := &mqhub.DynamicBase{}
+	sub.SetID("sub")
+	temp := mqhub.NewRetainDataPoint("temp")
+	sub.AddEndpoint(temp)
+	a.NoError(comp.AttachComponent(sub).Wait())
+	a.NoError(sub.AddEndpoint(mqhub.ReactorAs("square", func(n int) (int, error) { return n * n, nil })).Wait())
+	var result int
+	if a.NoError(desc.SubComponent("sub").Endpoint("square").Call(ctx, 3, &result)) {
+		a.Equal(9, result)
+	}
+	tempCh := make(chan int, 1)
+	_, err = desc.SubComponent("sub").Endpoint("temp").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var val int
+		a.NoError(msg.As(&val))
+		tempCh <- val
+		return nil
+	}))
+	a.NoError(err)
+	a.NoError(temp.Update(mqhub.StateFrom(20)).Wait())
+	select {
+	case val := <-tempCh:
+		a.Equal(20, val)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	a.NoError(comp.RemoveComponent("sub").Wait())
+	shortCtx, shortCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer shortCancel()
+	a.Error(desc.SubComponent("sub").Endpoint("square").Call(shortCtx, 3, &result))
+	descs, err := client.Discover(ctx)
+	if a.NoError(err) && a.Len(descs, 1) {
+		a.Empty(descs[0].SubComponents())
+	}
+}
+
+// lateDynamic adds the endpoint right after its endpoints are enumerated
+// for the first time, as if added concurrently while being published
+type lateDynamic struct {
+	mqhub.DynamicBase
+	late  mqhub.Endpoint
+	added int32
+}
+
+func (c *lateDynamic) Endpoints() []mqhub.Endpoint {
+	endpoints := c.DynamicBase.Endpoints()
+	if atomic.CompareAndSwapInt32(&c.added, 0, 1) {
+		c.AddEndpoint(c.late)
+	}
+	return endpoints
+}
+
+func TestDynamicCompositionWhilePublishing(t *testing.T) {
+	a := assert.New(t)
+	prefix := "dynamic-" + utils.UniqueID()
+	conn, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "dynamic-late") + "&qos=1")
+	if !a.NoError(err) || !a.NoError(conn.Connect().Wait()) {
+		return
+	}
+	defer conn.Close()
+	comp := &lateDynamic{late: mqhub.ReactorAs("double", func(n int) (int, error) { return n * 2, nil })}
+	comp.SetID("dynamic")
+	if _, err = conn.Publish(comp); !a.NoError(err) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var result int
+	if a.NoError(conn.Describe("dynamic").Endpoint("double").Call(ctx, 3, &result)) {
+		a.Equal(6, result)
+	}
+}
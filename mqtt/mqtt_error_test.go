@@ -0,0 +1,113 @@
+package mqtt_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type checkerComp struct {
+	mqhub.ComponentBase
+	Check func(int) error `mqhub:"check"`
+}
+
+func TestErrorReport(t *testing.T) {
+	a := assert.New(t)
+	prefix := "error-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "error-host") + "&qos=1&error-endpoint=true")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	c := &checkerComp{Check: func(val int) error {
+		if val < 0 {
+			return fmt.Errorf("negative value")
+		}
+		return nil
+	}}
+	c.SetID("checker")
+	comp, err := mqhub.ComponentOf(c)
+	if !a.NoError(err) {
+		return
+	}
+	if _, err = host.Publish(comp); !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "error-client") + "&qos=1")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("checker")
+	reports := make(chan *mqhub.ErrorReport, 2)
+	_, err = desc.Endpoint(mqhub.ErrorEndpoint).Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var report mqhub.ErrorReport
+		a.NoError(msg.As(&report))
+		reports <- &report
+		return nil
+	}))
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(desc.Endpoint("check").ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+	a.NoError(desc.Endpoint("check").ConsumeMessage(mqhub.MsgFrom("abc")).Wait())
+	a.NoError(desc.Endpoint("check").ConsumeMessage(mqhub.MsgFrom(-1)).Wait())
+	received := make(map[bool]*mqhub.ErrorReport)
+	for i := 0; i < 2; i++ {
+		select {
+		case report := <-reports:
+			a.Equal("checker/check", report.Topic)
+			received[report.Decode] = report
+		case <-time.After(3 * time.Second):
+			t.Fatal("error not reported")
+		}
+	}
+	if a.Contains(received, false) {
+		a.Equal("negative value", received[false].Error)
+	}
+	a.Contains(received, true)
+}
+
+func TestErrorHandler(t *testing.T) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	failures := make(chan error, 1)
+	opts := mqtt.NewOptions().SetErrorHandler(func(topic string, err error) {
+		a.Equal("checker/check", topic)
+		failures <- err
+	})
+	opts.AddServer(b.URL())
+	host := mqtt.NewConnector(opts)
+	if !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	c := &checkerComp{Check: func(int) error { return nil }}
+	c.SetID("checker")
+	comp, err := mqhub.ComponentOf(c)
+	if !a.NoError(err) {
+		return
+	}
+	if _, err = host.Publish(comp); !a.NoError(err) {
+		return
+	}
+	a.NoError(host.Describe("checker").Endpoint("check").ConsumeMessage(mqhub.MsgFrom("abc")).Wait())
+	select {
+	case err := <-failures:
+		_, decode := err.(*mqhub.DecodeError)
+		a.True(decode)
+	case <-time.After(3 * time.Second):
+		t.Fatal("error handler not notified")
+	}
+}
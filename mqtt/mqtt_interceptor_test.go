@@ -0,0 +1,123 @@
+package mqtt_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+type interceptedComp struct {
+	mqhub.ComponentBase
+	State *mqhub.DataPoint `mqhub:"state"`
+	Set   func(int)        `mqhub:"set"`
+	Crash func()           `mqhub:"crash"`
+}
+
+func TestInterceptors(t *testing.T) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	var lock sync.Mutex
+	var seen []string
+	record := func(prefix string) mqhub.Interceptor {
+		return func(msg mqhub.Message, info *mqhub.SinkInfo, next mqhub.MessageSink) mqhub.Future {
+			lock.Lock()
+			seen = append(seen, fmt.Sprintf("%s %s %s", prefix, info.Kind, info.Topic))
+			lock.Unlock()
+			return next.ConsumeMessage(msg)
+		}
+	}
+	recorded := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), seen...)
+	}
+	failures := make(chan error, 1)
+	opts := mqtt.NewOptions().
+		AddInterceptor(record("conn"), mqhub.Recover).
+		SetErrorHandler(func(topic string, err error) { failures <- err })
+	opts.AddServer(b.URL())
+	host := mqtt.NewConnector(opts)
+	if !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+
+	setCh := make(chan int, 1)
+	c := &interceptedComp{
+		Set:   func(val int) { setCh <- val },
+		Crash: func() { panic("crashed") },
+	}
+	c.SetID("comp")
+	comp, err := mqhub.ComponentOf(c)
+	if !a.NoError(err) {
+		return
+	}
+	pub, err := host.Publish(comp)
+	if !a.NoError(err) {
+		return
+	}
+	// the reactor is rejected by the interceptor of the publication
+	pub.(*mqtt.Publication).Use(record("pub"), func(msg mqhub.Message, info *mqhub.SinkInfo, next mqhub.MessageSink) mqhub.Future {
+		var val int
+		if info.Kind == mqhub.ReactorSink && msg.As(&val) == nil && val < 0 {
+			return &mqhub.ImmediateFuture{Error: fmt.Errorf("invalid value")}
+		}
+		return next.ConsumeMessage(msg)
+	})
+
+	msgCh := make(chan mqhub.Message, 1)
+	_, err = host.Describe("comp").Endpoint("state").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		msgCh <- msg
+		return nil
+	}))
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(c.State.Update(mqhub.MsgFrom(1)).Wait())
+	select {
+	case <-msgCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("state not received")
+	}
+	a.Equal([]string{
+		"conn emitter comp/state",
+		"pub emitter comp/state",
+		"conn watcher comp/state",
+	}, recorded())
+
+	desc := host.Describe("comp")
+	a.NoError(desc.Endpoint("set").ConsumeMessage(mqhub.MsgFrom(-1)).Wait())
+	select {
+	case err := <-failures:
+		a.EqualError(err, "invalid value")
+	case <-time.After(3 * time.Second):
+		t.Fatal("rejection not reported")
+	}
+	a.NoError(desc.Endpoint("set").ConsumeMessage(mqhub.MsgFrom(2)).Wait())
+	select {
+	case val := <-setCh:
+		a.Equal(2, val)
+	case <-time.After(3 * time.Second):
+		t.Fatal("reactor not invoked")
+	}
+
+	// the panic is recovered and reported
+	a.NoError(desc.Endpoint("crash").ConsumeMessage(mqhub.MsgFrom(nil)).Wait())
+	select {
+	case err := <-failures:
+		a.EqualError(err, "reactor comp/crash: panic: crashed")
+	case <-time.After(3 * time.Second):
+		t.Fatal("panic not reported")
+	}
+}
@@ -0,0 +1,134 @@
+package mqtt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	for _, opts := range []string{"version=3&metadata=true", "version=5"} {
+		testMetadata(t, opts, true)
+	}
+	testMetadata(t, "version=3", false)
+}
+
+func testMetadata(t *testing.T, opts string, carried bool) {
+	a := assert.New(t)
+	prefix := "meta-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "meta-host") + "&qos=1&" + opts)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := &mqhub.ComponentBase{}
+	comp.SetID("meta")
+	counter := mqhub.NewDataPoint("counter")
+	_, err = host.Publish(&metaComp{ComponentBase: comp, counter: counter})
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "meta-client") + "&" + opts)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	msgCh := make(chan mqhub.Message, 3)
+	_, err = client.Describe("meta").Endpoint("counter").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		msgCh <- msg
+		return nil
+	}))
+	if !a.NoError(err) {
+		return
+	}
+
+	start := time.Now()
+	for i := 1; i <= 3; i++ {
+		msg := mqhub.MsgFrom(i).WithProperty("k", "v")
+		a.NoError(counter.Update(msg).Wait())
+		// the message of the caller isn't stamped
+		a.Nil(msg.Meta)
+	}
+	for i := 1; i <= 3; i++ {
+		var msg mqhub.Message
+		select {
+		case msg = <-msgCh:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout")
+		}
+		var val int
+		a.NoError(msg.As(&val))
+		a.Equal(i, val)
+		meta := mqhub.MetadataOf(msg)
+		if !carried {
+			a.Nil(meta)
+			continue
+		}
+		if a.NotNil(meta) {
+			a.Equal(uint64(i), meta.Sequence)
+			a.Equal("meta-host", meta.Source)
+			a.False(meta.Timestamp.Before(start.Truncate(time.Millisecond)))
+		}
+		if props := msg.(mqhub.PropertiesCarrier).Properties(); props != nil {
+			// metadata isn't exposed as user properties
+			a.Equal(map[string]string{"k": "v"}, props.User)
+		}
+	}
+}
+
+type metaComp struct {
+	*mqhub.ComponentBase
+	counter *mqhub.DataPoint
+}
+
+func (c *metaComp) Endpoints() []mqhub.Endpoint {
+	return []mqhub.Endpoint{c.counter}
+}
+
+func TestMetadataEnvelopeDisabled(t *testing.T) {
+	a := assert.New(t)
+	prefix := "meta-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "meta-host") + "&qos=1&version=3&metadata=true")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	comp := &mqhub.ComponentBase{}
+	comp.SetID("meta")
+	counter := mqhub.NewDataPoint("counter")
+	_, err = host.Publish(&metaComp{ComponentBase: comp, counter: counter})
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "meta-client") + "&version=3")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	msgCh := make(chan mqhub.Message, 1)
+	_, err = client.Describe("meta").Endpoint("counter").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		msgCh <- msg
+		return nil
+	}))
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(counter.Update(mqhub.MsgFrom(1)).Wait())
+	select {
+	case msg := <-msgCh:
+		// the envelope is delivered as is without the option
+		a.Nil(mqhub.MetadataOf(msg))
+		var env map[string]interface{}
+		if a.NoError(msg.As(&env)) {
+			a.Contains(env, "$meta")
+			a.Equal(float64(1), env["$value"])
+		}
+	case <-time.After(3 * time.Second):
+		a.Fail("timeout")
+	}
+}
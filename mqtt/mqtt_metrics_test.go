@@ -0,0 +1,120 @@
+package mqtt_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/metrics/prometheus"
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+type meteredComp struct {
+	mqhub.ComponentBase
+	State *mqhub.DataPoint `mqhub:"state"`
+	Set   func(int)        `mqhub:"set"`
+}
+
+func TestMetrics(t *testing.T) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	registry := prometheus.NewRegistry()
+	opts := mqtt.NewOptions().SetMetrics(registry).SetQoS(mqhub.AtLeastOnce).
+		SetDispatch(1, 0, mqtt.DropOldest).SetMetricsTopicLabel(true)
+	opts.AddServer(b.URL())
+	host := mqtt.NewConnector(opts)
+	if !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	setCh := make(chan int, 1)
+	c := &meteredComp{Set: func(val int) { setCh <- val }}
+	c.SetID("comp")
+	comp, err := mqhub.ComponentOf(c)
+	if !a.NoError(err) {
+		return
+	}
+	if _, err = host.Publish(comp); !a.NoError(err) {
+		return
+	}
+
+	desc := host.Describe("comp")
+	a.NoError(desc.Endpoint("set").ConsumeMessage(mqhub.MsgFrom(12)).Wait())
+	select {
+	case val := <-setCh:
+		a.Equal(12, val)
+	case <-time.After(3 * time.Second):
+		t.Fatal("reactor not invoked")
+	}
+	a.NoError(desc.Endpoint("set").ConsumeMessage(mqhub.MsgFrom("abc")).Wait())
+	a.NoError(c.State.Update(mqhub.MsgFrom(1)).Wait())
+
+	expected := []string{
+		`mqhub_messages_published_total{topic="comp/set"} 2`,
+		`mqhub_published_bytes_total{topic="comp/set"} 7`,
+		`mqhub_messages_received_total{topic="comp/set"} 2`,
+		`mqhub_received_bytes_total{topic="comp/set"} 7`,
+		`mqhub_messages_published_total{topic="comp/state"} 1`,
+		`mqhub_decode_errors_total{topic="comp/set"} 1`,
+		`mqhub_dispatch_latency_seconds_count 2`,
+		`mqhub_dispatch_pending 0`,
+		// the reactor and its wrapped requests
+		`mqhub_subscriptions 2`,
+		`# TYPE mqhub_publish_latency_seconds histogram`,
+	}
+	a.Eventually(func() bool {
+		var buf bytes.Buffer
+		registry.WriteTo(&buf)
+		for _, line := range expected {
+			if !bytes.Contains(buf.Bytes(), []byte(line+"\n")) {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestMetricsWithoutTopicLabel(t *testing.T) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	registry := prometheus.NewRegistry()
+	opts := mqtt.NewOptions().SetMetrics(registry).SetQoS(mqhub.AtLeastOnce)
+	opts.AddServer(b.URL())
+	conn := mqtt.NewConnector(opts)
+	if !a.NoError(conn.Connect().Wait()) {
+		return
+	}
+	defer conn.Close()
+	watched := make(chan struct{}, 1)
+	_, err := conn.Watch(mqhub.MessageSinkFunc(func(mqhub.Message) mqhub.Future {
+		watched <- struct{}{}
+		return nil
+	}))
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(conn.Describe("comp").Endpoint("any").ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+	select {
+	case <-watched:
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not watched")
+	}
+	var buf bytes.Buffer
+	registry.WriteTo(&buf)
+	a.Contains(buf.String(), "mqhub_messages_published_total 1\n")
+	a.Contains(buf.String(), "mqhub_messages_received_total 1\n")
+	a.NotContains(buf.String(), mqtt.LabelTopic+"=")
+}
@@ -0,0 +1,145 @@
+package mqtt_test
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresence(t *testing.T) {
+	for _, version := range []string{"3", "5"} {
+		testPresence(t, version)
+	}
+}
+
+func recvPresence(t *testing.T, ch chan mqhub.Presence) (presence mqhub.Presence) {
+	select {
+	case <-time.After(3 * time.Second):
+		t.Error("timeout")
+	case presence = <-ch:
+	}
+	return
+}
+
+func testPresence(t *testing.T, version string) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	prefix := "presence-" + utils.UniqueID()
+	baseURL := "mqtt+" + b.URL() + "/" + prefix + "?version=" + version + "&presence=true&reconnect=false"
+
+	client, err := mqhub.NewConnector(baseURL + "&client-id=presence-client")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	presenceCh := make(chan mqhub.Presence, 4)
+	watcher, err := client.Describe("pub0").SubComponent("comp0").Presence().Watch(
+		mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+			var presence mqhub.Presence
+			a.NoError(msg.As(&presence))
+			presenceCh <- presence
+			return nil
+		}))
+	if !a.NoError(err) {
+		return
+	}
+	defer watcher.Close()
+
+	publish := func(hostID string) (mqhub.Connector, mqhub.Publication) {
+		host, err := mqhub.NewConnector(baseURL + "&client-id=" + url.QueryEscape(hostID))
+		if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+			t.FailNow()
+		}
+		pub, err := host.Publish(NewPub0())
+		if !a.NoError(err) {
+			t.FailNow()
+		}
+		presence := recvPresence(t, presenceCh)
+		a.True(presence.Online)
+		a.Equal("pub0", presence.ComponentID)
+		// the client ID is escaped as a topic level
+		a.Equal(strings.NewReplacer("/", "%2F", "+", "%2B", "#", "%23").Replace(hostID), presence.Host)
+		return host, pub
+	}
+
+	// explicitly closed publication
+	host, pub := publish("presence-host1")
+	a.NoError(pub.Close())
+	a.False(recvPresence(t, presenceCh).Online)
+	host.Close()
+
+	// connection dropped, Last Will makes the host offline, the will topic
+	// is valid though the client ID has the characters reserved by topics
+	host, _ = publish("presence/host#2")
+	defer host.Close()
+	a.True(b.DisconnectClient("presence/host#2"))
+	presence := recvPresence(t, presenceCh)
+	a.False(presence.Online)
+	a.Equal("presence%2Fhost%232", presence.Host)
+}
+
+// TestWildcardWatchReserved watches all topics, the presence, requests and
+// replies are used by the hub and not delivered
+func TestWildcardWatchReserved(t *testing.T) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	prefix := "reserved-" + utils.UniqueID()
+	baseURL := "mqtt+" + b.URL() + "/" + prefix + "?version=3&presence=true&reconnect=false"
+
+	client, err := mqhub.NewConnector(baseURL + "&client-id=reserved-client")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	endpoints := make(chan string, 16)
+	watcher, err := client.Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		endpoints <- msg.Endpoint()
+		return nil
+	}))
+	if !a.NoError(err) {
+		return
+	}
+	defer watcher.Close()
+
+	host, err := mqhub.NewConnector(baseURL + "&client-id=reserved-host")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	if _, err = host.Publish(newCalcComp()); !a.NoError(err) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ref := client.Describe("calc").Endpoint("div")
+	var result int
+	a.NoError(ref.Call(ctx, &calcReq{A: 7, B: 2}, &result))
+	a.NoError(ref.ConsumeMessage(mqhub.MsgFrom(&calcReq{A: 1, B: 1})).Wait())
+
+	select {
+	case endpoint := <-endpoints:
+		a.Equal("div", endpoint)
+	case <-time.After(3 * time.Second):
+		a.Fail("message not delivered")
+	}
+	select {
+	case endpoint := <-endpoints:
+		a.Fail("unexpected message", endpoint)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -92,6 +92,7 @@ func TestPublication(t *testing.T) {
 	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
 		return
 	}
+	defer host.Close()
 
 	pub0 := NewPub0()
 
@@ -107,6 +108,7 @@ func TestPublication(t *testing.T) {
 	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
 		return
 	}
+	defer client.Close()
 
 	stateCh := make(chan pubState, 2)
 	sinkFunc := makeSinkFunc(t, stateCh)
@@ -198,3 +200,106 @@ func TestTopLevelSubscribe(t *testing.T) {
 	a.Equal("pub0/comp0", state.component)
 	a.Equal("state1", state.endpoint)
 }
+
+func TestMessagePropertiesV5(t *testing.T) {
+	a := assert.New(t)
+	prefix := "props-" + utils.UniqueID()
+
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "props-pub") + "&version=5")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+
+	pub0 := NewPub0()
+	_, err = host.Publish(pub0)
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "props-client") + "&version=5")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+
+	msgCh := make(chan mqhub.Message, 1)
+	desc := client.Describe("pub0").SubComponent("comp0")
+	_, err = desc.Endpoint("state1").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		msgCh <- msg
+		return nil
+	}))
+	a.NoError(err)
+
+	msg := mqhub.MsgFrom(10).WithContentType("application/json").
+		WithExpiry(time.Minute).WithProperty("source", "test")
+	a.NoError(pub0.Comp0.state1.Update(msg).Wait())
+	select {
+	case received := <-msgCh:
+		var val int
+		a.NoError(received.As(&val))
+		a.Equal(10, val)
+		props := received.(mqhub.PropertiesCarrier).Properties()
+		if a.NotNil(props) {
+			a.Equal("application/json", props.ContentType)
+			a.Equal("test", props.User["source"])
+			a.True(props.Expiry > 0 && props.Expiry <= time.Minute)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("timeout")
+	}
+}
+
+func TestEndpointQoS(t *testing.T) {
+	a := assert.New(t)
+	prefix := "qos-" + utils.UniqueID()
+
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "qos-pub") + "&qos=at-least-once")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+
+	pub0 := NewPub0()
+	pub0.Comp0.actor0.WithQoS(mqhub.ExactlyOnce)
+	pub0.Comp0.state1.WithQoS(mqhub.AtMostOnce)
+	_, err = host.Publish(pub0)
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := TestEnv.NewConnector(prefix, "qos-client")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+
+	qosCh := make(chan byte, 2)
+	desc := client.Describe("pub0").SubComponent("comp0")
+	_, err = desc.Endpoint("state0").WithQoS(mqhub.AtLeastOnce).Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		qosCh <- msg.(*mqtt.Message).Raw.Qos()
+		return nil
+	}))
+	a.NoError(err)
+
+	// state0 is published at connector default QoS
+	a.NoError(desc.Endpoint("a").WithQoS(mqhub.ExactlyOnce).ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+	select {
+	case qos := <-qosCh:
+		a.Equal(byte(1), qos)
+	case <-time.After(3 * time.Second):
+		t.Error("timeout")
+	}
+}
+
+func TestParseQoS(t *testing.T) {
+	a := assert.New(t)
+	qos, err := mqhub.ParseQoS("2")
+	a.NoError(err)
+	a.Equal(mqhub.ExactlyOnce, qos)
+	qos, err = mqhub.ParseQoS("at-most-once")
+	a.NoError(err)
+	a.Equal(mqhub.AtMostOnce, qos)
+	_, err = mqhub.ParseQoS("3")
+	a.Error(err)
+}
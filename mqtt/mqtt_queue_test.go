@@ -0,0 +1,188 @@
+package mqtt_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/metrics/prometheus"
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOfflineQueue(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "mqhub-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	prefix := "queue-" + utils.UniqueID()
+	baseURL := "mqtt+" + b.URL() + "/" + prefix
+	hostURL := baseURL + "?client-id=queue-host&queue-size=3&queue-policy=coalesce&queue-dir=" + url.QueryEscape(dir)
+
+	host, err := mqhub.NewConnector(hostURL)
+	if !a.NoError(err) {
+		return
+	}
+	desc := host.Describe("comp")
+	// states are coalesced
+	a.NoError(desc.Endpoint("state").ConsumeMessage(mqhub.StateFrom(1)).Wait())
+	a.NoError(desc.Endpoint("state").ConsumeMessage(mqhub.StateFrom(2)).Wait())
+	a.Equal(1, host.(*mqtt.Connector).Queued())
+	// the oldest is dropped when full
+	for i := 0; i < 3; i++ {
+		a.NoError(desc.Endpoint("event").ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	a.Equal(3, host.(*mqtt.Connector).Queued())
+	host.Close()
+
+	client, err := mqhub.NewConnector(baseURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	eventCh := make(chan int, 3)
+	_, err = client.Describe("comp").Endpoint("event").Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var val int
+		a.NoError(msg.As(&val))
+		eventCh <- val
+		return nil
+	}))
+	a.NoError(err)
+
+	// the persisted queue is flushed when connected
+	host, err = mqhub.NewConnector(hostURL)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal(3, host.(*mqtt.Connector).Queued())
+	a.NoError(host.Connect().Wait())
+	defer host.Close()
+	for i := 1; i < 3; i++ {
+		select {
+		case val := <-eventCh:
+			a.Equal(i, val)
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	if retained := b.Retained(prefix + "/comp/state"); a.NotNil(retained) {
+		a.Equal("2", string(retained.Payload))
+	}
+	a.Equal(0, host.(*mqtt.Connector).Queued())
+}
+
+func TestOfflineQueueJournal(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "mqhub-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	connURL := "mqtt://127.0.0.1:1/?client-id=journal&queue-size=3&queue-dir=" + url.QueryEscape(dir)
+	conn, err := mqhub.NewConnector(connURL)
+	if !a.NoError(err) {
+		return
+	}
+	ref := conn.Describe("comp").Endpoint("event")
+	for i := 0; i < 100; i++ {
+		a.NoError(ref.ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	conn.Close()
+	// the journal is compacted instead of growing with the messages
+	data, err := ioutil.ReadFile(filepath.Join(dir, "outbound-journal.log"))
+	if a.NoError(err) {
+		a.True(bytes.Count(data, []byte("\n")) <= 6)
+	}
+
+	conn, err = mqhub.NewConnector(connURL)
+	if !a.NoError(err) {
+		return
+	}
+	defer conn.Close()
+	a.Equal(3, conn.(*mqtt.Connector).Queued())
+}
+
+func TestOfflineQueueJournalClientID(t *testing.T) {
+	_, err := mqhub.NewConnector("mqtt://127.0.0.1:1/?queue-size=3&queue-dir=" + url.QueryEscape(os.TempDir()))
+	assert.Equal(t, mqtt.ErrQueueClientID, err)
+}
+
+func TestOfflineQueueMetrics(t *testing.T) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	registry := prometheus.NewRegistry()
+	opts := mqtt.NewOptions().SetMetrics(registry).SetQueue(1, mqtt.DropOldest)
+	opts.AddServer(b.URL())
+	conn := mqtt.NewConnector(opts)
+	defer conn.Close()
+	ref := conn.Describe("comp").Endpoint("event")
+	for i := 0; i < 3; i++ {
+		a.NoError(ref.ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	var buf bytes.Buffer
+	registry.WriteTo(&buf)
+	// queued messages are not published yet
+	a.Contains(buf.String(), "mqhub_queue_dropped_total 2\n")
+	a.NotContains(buf.String(), "mqhub_messages_published_total")
+
+	if !a.NoError(conn.Connect().Wait()) {
+		return
+	}
+	a.Eventually(func() bool {
+		buf.Reset()
+		registry.WriteTo(&buf)
+		return bytes.Contains(buf.Bytes(), []byte("mqhub_messages_published_total 1\n"))
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestOfflineQueuePolicies(t *testing.T) {
+	a := assert.New(t)
+	conn, err := mqhub.NewConnector("mqtt://127.0.0.1:1/?queue-size=1&queue-policy=drop-newest")
+	if !a.NoError(err) {
+		return
+	}
+	ref := conn.Describe("comp").Endpoint("event")
+	a.NoError(ref.ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+	a.Equal(mqtt.ErrQueueFull, ref.ConsumeMessage(mqhub.MsgFrom(2)).Wait())
+	conn.Close()
+
+	conn, err = mqhub.NewConnector("mqtt://127.0.0.1:1/?queue-size=1&queue-policy=block")
+	if !a.NoError(err) {
+		return
+	}
+	ref = conn.Describe("comp").Endpoint("event")
+	a.NoError(ref.ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+	errCh := make(chan error)
+	go func() {
+		errCh <- ref.ConsumeMessage(mqhub.MsgFrom(2)).Wait()
+	}()
+	select {
+	case <-errCh:
+		t.Error("not blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	conn.Close()
+	a.Equal(mqtt.ErrQueueClosed, <-errCh)
+
+	_, err = mqhub.NewConnector("mqtt://127.0.0.1:1/?queue-size=1&queue-policy=unknown")
+	a.Error(err)
+}
@@ -0,0 +1,113 @@
+package mqtt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnect(t *testing.T) {
+	for _, version := range []string{"3", "5"} {
+		testReconnect(t, version)
+	}
+}
+
+func testReconnect(t *testing.T, version string) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	addr := b.Addr().String()
+	prefix := "reconnect-" + utils.UniqueID()
+	connURL := "mqtt+" + b.URL() + "/" + prefix + "?version=" + version +
+		"&reconnect-interval=50ms&reconnect-max-interval=200ms&republish-states=true"
+
+	host, err := mqhub.NewConnector(connURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	pub0 := NewPub0()
+	_, err = host.Publish(pub0)
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(pub0.Comp0.state0.Update(5).Wait())
+	events := make(chan mqhub.ConnectionEvent, 16)
+	_, err = host.Lifecycle().Watch(mqhub.MessageSinkFunc(func(msg mqhub.Message) mqhub.Future {
+		var event mqhub.ConnectionEvent
+		a.NoError(msg.As(&event))
+		events <- event
+		return nil
+	}))
+	a.NoError(err)
+	a.Equal(mqhub.Connected, recvEvent(t, events).State)
+
+	client, err := mqhub.NewConnector(connURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	stateCh := make(chan pubState, 2)
+	desc := client.Describe("pub0").SubComponent("comp0")
+	_, err = desc.Endpoint("state0").Watch(makeSinkFunc(t, stateCh))
+	a.NoError(err)
+	a.Equal(5, recvState(t, stateCh).state)
+
+	// restart the broker, all retained messages are lost
+	a.NoError(b.Close())
+	b = broker.New()
+	if err := b.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// the client resubscribes and the host republishes the state
+	a.Equal(5, recvState(t, stateCh).state)
+	a.NotNil(b.Retained(prefix + "/pub0/comp0/state0"))
+
+	// reactors are subscribed again
+	a.NoError(desc.Endpoint("a").ConsumeMessage(mqhub.MsgFrom(7)).Wait())
+	a.Equal(7, recvState(t, stateCh).state)
+
+	event := recvEvent(t, events)
+	a.Equal(mqhub.ConnectionLost, event.State)
+	a.Error(event.Error)
+	a.False(event.Time.IsZero())
+	for event = recvEvent(t, events); event.State == mqhub.Reconnecting; event = recvEvent(t, events) {
+	}
+	a.Equal(mqhub.Connected, event.State)
+	host.Close()
+	a.Equal(mqhub.Closed, recvEvent(t, events).State)
+}
+
+func recvEvent(t *testing.T, events chan mqhub.ConnectionEvent) (event mqhub.ConnectionEvent) {
+	select {
+	case <-time.After(3 * time.Second):
+		t.Error("timeout")
+	case event = <-events:
+	}
+	return
+}
+
+func TestBackoff(t *testing.T) {
+	a := assert.New(t)
+	backoff := mqtt.Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 2}
+	a.Equal(time.Second, backoff.Delay(0))
+	a.Equal(2*time.Second, backoff.Delay(1))
+	a.Equal(4*time.Second, backoff.Delay(2))
+	a.Equal(5*time.Second, backoff.Delay(3))
+	a.Equal(5*time.Second, backoff.Delay(100))
+
+	backoff.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		delay := backoff.Delay(1)
+		a.True(delay >= time.Second && delay <= 3*time.Second)
+	}
+}
@@ -0,0 +1,141 @@
+package mqtt_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type calcReq struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type calcComp struct {
+	mqhub.ComponentBase
+	div *mqhub.Reactor
+}
+
+func newCalcComp() *calcComp {
+	c := &calcComp{
+		div: mqhub.ReactorAs("div", func(req calcReq) (int, error) {
+			if req.B == 0 {
+				return 0, fmt.Errorf("divided by zero")
+			}
+			return req.A / req.B, nil
+		}),
+	}
+	c.SetID("calc")
+	return c
+}
+
+func (c *calcComp) Endpoints() []mqhub.Endpoint {
+	return []mqhub.Endpoint{c.div}
+}
+
+func TestCall(t *testing.T) {
+	for _, version := range []string{"3", "5"} {
+		testCall(t, version)
+	}
+}
+
+func testCall(t *testing.T, version string) {
+	a := assert.New(t)
+	prefix := "rpc-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "rpc-host") + "&version=" + version)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	_, err = host.Publish(newCalcComp())
+	if !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "rpc-client") + "&version=" + version)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ref := client.Describe("calc").Endpoint("div")
+	var result int
+	if a.NoError(ref.Call(ctx, &calcReq{A: 7, B: 2}, &result)) {
+		a.Equal(3, result)
+	}
+	err = ref.Call(ctx, &calcReq{A: 7}, &result)
+	if a.IsType(&mqhub.RemoteError{}, err) {
+		a.Equal("divided by zero", err.Error())
+	}
+
+	// no one answers
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	a.Equal(context.DeadlineExceeded, client.Describe("calc").Endpoint("none").Call(ctx, 1, nil))
+}
+
+// TestCallLookalike sends a payload looking like a wrapped request to
+// a reactor, it's delivered as is
+func TestCallLookalike(t *testing.T) {
+	a := assert.New(t)
+	prefix := "rpc-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "rpc-host") + "&qos=1&version=3")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	payloads := make(chan map[string]interface{}, 1)
+	comp := &mqhub.DynamicBase{}
+	comp.SetID("echo")
+	comp.AddEndpoint(mqhub.ReactorAs("in", func(payload map[string]interface{}) {
+		payloads <- payload
+	}))
+	if _, err = host.Publish(comp); !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "rpc-client") + "&qos=1&version=3")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	lookalike := map[string]interface{}{"$call": map[string]interface{}{"reply": "x", "id": "1"}}
+	a.NoError(client.Describe("echo").Endpoint("in").ConsumeMessage(mqhub.MsgFrom(lookalike)).Wait())
+	select {
+	case payload := <-payloads:
+		a.Equal(lookalike, payload)
+	case <-time.After(3 * time.Second):
+		a.Fail("reactor not invoked")
+	}
+}
+
+// TestCallWrappedToV5 sends a wrapped request from MQTT 3.x to a host using
+// MQTT v5, which doesn't subscribe the topics of wrapped requests
+func TestCallWrappedToV5(t *testing.T) {
+	a := assert.New(t)
+	prefix := "rpc-" + utils.UniqueID()
+	host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "rpc-host") + "&version=5")
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	if _, err = host.Publish(newCalcComp()); !a.NoError(err) {
+		return
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "rpc-client") + "&version=3")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var result int
+	a.Equal(context.DeadlineExceeded, client.Describe("calc").Endpoint("div").Call(ctx, &calcReq{A: 7, B: 2}, &result))
+}
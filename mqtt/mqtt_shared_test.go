@@ -0,0 +1,126 @@
+package mqtt_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type sharedComp struct {
+	mqhub.ComponentBase
+	Work func(int) `mqhub:"work,share=workers"`
+	Echo func(int) `mqhub:"echo"`
+}
+
+func TestSharedReactor(t *testing.T) {
+	for _, version := range []string{"3", "5"} {
+		testSharedReactor(t, version, false)
+	}
+	// only MQTT v5 tells the subscriptions a message is delivered for
+	testSharedReactor(t, "5", true)
+}
+
+// testSharedReactor publishes two replicas, with overlap, each host also
+// watches the shared endpoint
+func testSharedReactor(t *testing.T, version string, overlap bool) {
+	a := assert.New(t)
+	prefix := "shared-" + utils.UniqueID()
+	var works, echoes, watched [2]int32
+	for i := range works {
+		i := i
+		host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, fmt.Sprintf("shared-host%d", i)) + "&qos=1&version=" + version)
+		if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+			return
+		}
+		defer host.Close()
+		c := &sharedComp{
+			Work: func(int) { atomic.AddInt32(&works[i], 1) },
+			Echo: func(int) { atomic.AddInt32(&echoes[i], 1) },
+		}
+		c.SetID("replica")
+		comp, err := mqhub.ComponentOf(c)
+		if !a.NoError(err) {
+			return
+		}
+		_, err = host.Publish(comp)
+		if !a.NoError(err) {
+			return
+		}
+		if overlap {
+			_, err = host.Describe("replica").Endpoint("work").Watch(mqhub.MessageSinkFunc(func(mqhub.Message) mqhub.Future {
+				atomic.AddInt32(&watched[i], 1)
+				return nil
+			}))
+			if !a.NoError(err) {
+				return
+			}
+		}
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "shared-client") + "&qos=1&version=" + version)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	desc := client.Describe("replica")
+	for i := 0; i < 10; i++ {
+		a.NoError(desc.Endpoint("work").ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	a.NoError(desc.Endpoint("echo").ConsumeMessage(mqhub.MsgFrom(0)).Wait())
+	a.Eventually(func() bool {
+		return atomic.LoadInt32(&echoes[0]) == 1 && atomic.LoadInt32(&echoes[1]) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	a.Eventually(func() bool {
+		return atomic.LoadInt32(&works[0])+atomic.LoadInt32(&works[1]) == 10
+	}, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	a.Equal(int32(10), atomic.LoadInt32(&works[0])+atomic.LoadInt32(&works[1]))
+	a.NotZero(atomic.LoadInt32(&works[0]))
+	a.NotZero(atomic.LoadInt32(&works[1]))
+	if overlap {
+		a.Equal(int32(10), atomic.LoadInt32(&watched[0]))
+		a.Equal(int32(10), atomic.LoadInt32(&watched[1]))
+	}
+}
+
+func TestPublishShared(t *testing.T) {
+	a := assert.New(t)
+	prefix := "pubshared-" + utils.UniqueID()
+	var handled [2]int32
+	for i := range handled {
+		i := i
+		host, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, fmt.Sprintf("pubshared-host%d", i)) + "&qos=1")
+		if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+			return
+		}
+		defer host.Close()
+		comp := &mqhub.DynamicBase{}
+		comp.SetID("replica")
+		comp.AddEndpoint(mqhub.ReactorAs("work", func(int) { atomic.AddInt32(&handled[i], 1) }))
+		_, err = host.(*mqtt.Connector).PublishShared(context.Background(), comp, "workers")
+		if !a.NoError(err) {
+			return
+		}
+	}
+
+	client, err := mqhub.NewConnector(TestEnv.ConnectorURL(prefix, "pubshared-client") + "&qos=1")
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	for i := 0; i < 4; i++ {
+		a.NoError(client.Describe("replica").Endpoint("work").ConsumeMessage(mqhub.MsgFrom(i)).Wait())
+	}
+	a.Eventually(func() bool {
+		return atomic.LoadInt32(&handled[0])+atomic.LoadInt32(&handled[1]) == 4
+	}, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	a.Equal(int32(4), atomic.LoadInt32(&handled[0])+atomic.LoadInt32(&handled[1]))
+}
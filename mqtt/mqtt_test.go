@@ -1,21 +1,27 @@
 package mqtt_test
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/robotalks/mqhub.go/mqhub"
 	_ "github.com/robotalks/mqhub.go/mqtt"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
 )
 
 type EnvBuilder interface {
 	Setup() error
 	TearDown() error
+	ConnectorURL(prefix, id string) string
 	NewConnector(prefix, id string) (mqhub.Connector, error)
 }
 
@@ -35,8 +41,105 @@ func (b *RemoteEnvBuilder) TearDown() error {
 	return nil
 }
 
+func (b *RemoteEnvBuilder) ConnectorURL(prefix, id string) string {
+	return "mqtt+" + b.serverURL + "/" + prefix + "?client-id=" + url.QueryEscape(id)
+}
+
 func (b *RemoteEnvBuilder) NewConnector(prefix, id string) (mqhub.Connector, error) {
-	return mqhub.NewConnector("mqtt+" + b.serverURL + "/" + prefix + "?client-id=" + url.QueryEscape(id))
+	return mqhub.NewConnector(b.ConnectorURL(prefix, id))
+}
+
+// retainedLatency holds back the retained messages from the embedded broker
+const retainedLatency = 50 * time.Millisecond
+
+// LocalEnvBuilder runs an embedded broker on a loopback port, behind a
+// proxy holding back retained messages like a remote broker over the
+// network, the tests written against a remote broker expect the handlers
+// of consecutive watchers are added before the retained messages arrive
+type LocalEnvBuilder struct {
+	RemoteEnvBuilder
+	broker   *broker.Broker
+	listener net.Listener
+}
+
+func (b *LocalEnvBuilder) Setup() error {
+	b.broker = broker.New()
+	if err := b.broker.Listen("127.0.0.1:0"); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.broker.Close()
+		return err
+	}
+	b.listener = listener
+	b.serverURL = "tcp://" + listener.Addr().String()
+	go b.accept()
+	return nil
+}
+
+func (b *LocalEnvBuilder) TearDown() error {
+	b.listener.Close()
+	return b.broker.Close()
+}
+
+func (b *LocalEnvBuilder) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		upstream, err := net.Dial("tcp", b.broker.Addr().String())
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		go func() {
+			relayPackets(conn, upstream)
+			conn.Close()
+		}()
+	}
+}
+
+// relayPackets forwards MQTT packets from the broker, the retained
+// messages are delayed by retainedLatency, keeping the order of packets
+func relayPackets(dst io.Writer, src io.Reader) {
+	reader := bufio.NewReader(src)
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		pkt := []byte{header}
+		length, shift := 0, 0
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			pkt = append(pkt, b)
+			length |= int(b&0x7f) << shift
+			if b&0x80 == 0 {
+				break
+			}
+			shift += 7
+		}
+		body := make([]byte, length)
+		if _, err = io.ReadFull(reader, body); err != nil {
+			return
+		}
+		// PUBLISH with RETAIN flag
+		if header>>4 == 3 && header&1 != 0 {
+			time.Sleep(retainedLatency)
+		}
+		if _, err = dst.Write(append(pkt, body...)); err != nil {
+			return
+		}
+	}
 }
 
 var (
@@ -46,10 +149,15 @@ var (
 func buildEnv() error {
 	envBuilders := map[string]EnvBuilder{
 		"remote": &RemoteEnvBuilder{},
+		"local":  &LocalEnvBuilder{},
 	}
 	envName := os.Getenv("TEST_ENV")
 	if envName == "" {
-		envName = "remote"
+		// without an explicit broker, run against the embedded one
+		envName = "local"
+		if os.Getenv("MQTT_URL") != "" {
+			envName = "remote"
+		}
 	}
 
 	if TestEnv = envBuilders[envName]; TestEnv == nil {
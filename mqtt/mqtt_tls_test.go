@@ -0,0 +1,135 @@
+package mqtt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{cn},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) writeFiles(t *testing.T, dir, name string) (certFile, keyFile string) {
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestMutualTLS(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "mqhub-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "broker.local", ca, false)
+	client := newTestCert(t, "client", ca, false)
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	certFile, keyFile := client.writeFiles(t, dir, "client")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	b := broker.New()
+	err = b.ListenTLS("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	baseURL := "mqtts://" + strings.TrimPrefix(b.URL(), "ssl://") + "/tls?tls-ca=" + url.QueryEscape(caFile)
+	for _, version := range []string{"3", "5"} {
+		conn, err := mqhub.NewConnector(baseURL + "&version=" + version +
+			"&tls-server-name=broker.local" +
+			"&tls-cert=" + url.QueryEscape(certFile) +
+			"&tls-key=" + url.QueryEscape(keyFile))
+		if a.NoError(err) && a.NoError(conn.Connect().Wait()) {
+			a.NoError(conn.Describe("c").Endpoint("e").ConsumeMessage(mqhub.MsgFrom(1)).Wait())
+			conn.Close()
+		}
+	}
+
+	// without client certificate
+	conn, err := mqhub.NewConnector(baseURL + "&tls-server-name=broker.local")
+	if a.NoError(err) {
+		a.Error(conn.Connect().Wait())
+	}
+
+	// wrong server name
+	conn, err = mqhub.NewConnector(baseURL + "&tls-server-name=other" +
+		"&tls-cert=" + url.QueryEscape(certFile) + "&tls-key=" + url.QueryEscape(keyFile))
+	if a.NoError(err) {
+		a.Error(conn.Connect().Wait())
+	}
+
+	_, err = mqhub.NewConnector(baseURL + "&tls-key=" + url.QueryEscape(keyFile))
+	a.Error(err)
+}
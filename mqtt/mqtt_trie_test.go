@@ -0,0 +1,81 @@
+package mqtt_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqtt"
+	"github.com/stretchr/testify/assert"
+)
+
+func matchTrie(trie *mqtt.TopicTrie[string], topic string) []string {
+	var matched []string
+	trie.Match(topic, func(filter string) {
+		matched = append(matched, filter)
+	})
+	sort.Strings(matched)
+	return matched
+}
+
+func TestTopicTrie(t *testing.T) {
+	a := assert.New(t)
+	trie := mqtt.NewTopicTrie[string]()
+	for _, filter := range []string{"#", "a/b", "a/+", "a/#", "+/b", "+/+/c", "$SYS/#"} {
+		trie.Put(filter, filter)
+	}
+	a.Equal([]string{"#", "+/b", "a/#", "a/+", "a/b"}, matchTrie(trie, "a/b"))
+	a.Equal([]string{"#", "+/+/c", "a/#"}, matchTrie(trie, "a/b/c"))
+	a.Equal([]string{"#", "a/#"}, matchTrie(trie, "a"))
+	a.Equal([]string{"#", "+/b"}, matchTrie(trie, "x/b"))
+	// topics starting with $ are not matched by leading wildcards
+	a.Equal([]string{"$SYS/#"}, matchTrie(trie, "$SYS/b"))
+
+	trie.Delete("a/#")
+	trie.Delete("#")
+	trie.Delete("a/b/c")
+	a.Equal([]string{"+/b", "a/+", "a/b"}, matchTrie(trie, "a/b"))
+	a.Empty(matchTrie(trie, "a"))
+}
+
+type benchMessage struct {
+	paho.Message
+	topic string
+}
+
+func (m *benchMessage) Topic() string     { return m.topic }
+func (m *benchMessage) Retained() bool    { return false }
+func (m *benchMessage) Payload() []byte   { return []byte("1") }
+func (m *benchMessage) Duplicate() bool   { return false }
+func (m *benchMessage) Qos() byte         { return 0 }
+func (m *benchMessage) MessageID() uint16 { return 0 }
+
+// BenchmarkTopicHandlerMap dispatches a message with the number of filters
+// growing, the cost should stay the same
+func BenchmarkTopicHandlerMap(b *testing.B) {
+	for _, count := range []int{10, 1000, 100000} {
+		b.Run(fmt.Sprintf("filters-%d", count), func(b *testing.B) {
+			m := mqtt.NewTopicHandlerMap()
+			handled := 0
+			handler := mqtt.MakeHandlerRef(func(paho.Client, paho.Message) {
+				handled++
+			})
+			filters := make(map[string]byte)
+			for i := 0; i < count; i++ {
+				filters[fmt.Sprintf("comp%d/+", i)] = 0
+				filters[fmt.Sprintf("comp%d/sub/value", i)] = 0
+			}
+			m.Add(filters, handler)
+			msg := &benchMessage{topic: fmt.Sprintf("comp%d/value", count/2)}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.HandleMessage(nil, msg)
+			}
+			b.StopTimer()
+			if handled != b.N {
+				b.Fatalf("handled %d of %d", handled, b.N)
+			}
+		})
+	}
+}
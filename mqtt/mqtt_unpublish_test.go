@@ -0,0 +1,84 @@
+package mqtt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/broker"
+	"github.com/robotalks/mqhub.go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type unpublishComp struct {
+	mqhub.ComponentBase
+	Temp  *mqhub.DataPoint `mqhub:"temp,retain"`
+	Reset func()           `mqhub:"reset"`
+}
+
+func TestUnpublish(t *testing.T) {
+	for _, clear := range []bool{false, true} {
+		testUnpublish(t, clear)
+	}
+}
+
+func testUnpublish(t *testing.T, clear bool) {
+	a := assert.New(t)
+	b := broker.New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	prefix := "unpublish-" + utils.UniqueID()
+	baseURL := "mqtt+" + b.URL() + "/" + prefix + "?qos=1"
+	if clear {
+		baseURL += "&clear-retained=true"
+	}
+	host, err := mqhub.NewConnector(baseURL)
+	if !a.NoError(err) || !a.NoError(host.Connect().Wait()) {
+		return
+	}
+	defer host.Close()
+	resetCh := make(chan struct{}, 1)
+	c := &unpublishComp{Reset: func() { resetCh <- struct{}{} }}
+	c.SetID("unpublish")
+	comp, err := mqhub.ComponentOf(c)
+	if !a.NoError(err) {
+		return
+	}
+	pub, err := host.Publish(comp)
+	if !a.NoError(err) {
+		return
+	}
+	a.NoError(c.Temp.Update(mqhub.StateFrom(20)).Wait())
+	a.NotNil(b.Retained(prefix + "/unpublish/temp"))
+	a.NotNil(b.Retained(prefix + "/unpublish/$adv"))
+
+	client, err := mqhub.NewConnector(baseURL)
+	if !a.NoError(err) || !a.NoError(client.Connect().Wait()) {
+		return
+	}
+	defer client.Close()
+	reset := client.Describe("unpublish").Endpoint("reset")
+	a.NoError(reset.ConsumeMessage(mqhub.MsgFrom(nil)).Wait())
+	select {
+	case <-resetCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	a.NoError(pub.Close())
+	a.Nil(b.Retained(prefix + "/unpublish/$adv"))
+	if clear {
+		a.Nil(b.Retained(prefix + "/unpublish/temp"))
+	} else {
+		a.NotNil(b.Retained(prefix + "/unpublish/temp"))
+	}
+	// the reactor is unsubscribed
+	a.NoError(reset.ConsumeMessage(mqhub.MsgFrom(nil)).Wait())
+	select {
+	case <-resetCh:
+		t.Error("reactor invoked after unpublished")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,229 @@
+package packets
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrMalformed indicates the packet doesn't conform to the protocol
+	ErrMalformed = fmt.Errorf("malformed packet")
+	// ErrPacketTooLarge indicates remaining length exceeds the limit
+	ErrPacketTooLarge = fmt.Errorf("packet too large")
+)
+
+// MaxRemainingLength is the maximum value of remaining length
+const MaxRemainingLength = 268435455
+
+type encoder struct {
+	version byte
+	buf     []byte
+}
+
+func (e *encoder) v5() bool {
+	return e.version >= ProtocolV5
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) varint(v int) {
+	e.buf = encodeLength(e.buf, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint16(uint16(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.uint16(uint16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) raw(b []byte) {
+	e.buf = append(e.buf, b...)
+}
+
+type decoder struct {
+	version byte
+	buf     []byte
+	pos     int
+}
+
+func (d *decoder) v5() bool {
+	return d.version >= ProtocolV5
+}
+
+func (d *decoder) remaining() int {
+	return len(d.buf) - d.pos
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.remaining() < 1 {
+		return 0, ErrMalformed
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) uint16() (uint16, error) {
+	if d.remaining() < 2 {
+		return 0, ErrMalformed
+	}
+	v := binary.BigEndian.Uint16(d.buf[d.pos:])
+	d.pos += 2
+	return v, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if d.remaining() < 4 {
+		return 0, ErrMalformed
+	}
+	v := binary.BigEndian.Uint32(d.buf[d.pos:])
+	d.pos += 4
+	return v, nil
+}
+
+func (d *decoder) ReadByte() (byte, error) {
+	return d.byte()
+}
+
+func (d *decoder) varint() (int, error) {
+	return readLength(d)
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	l, err := d.uint16()
+	if err != nil {
+		return nil, err
+	}
+	if d.remaining() < int(l) {
+		return nil, ErrMalformed
+	}
+	b := make([]byte, l)
+	copy(b, d.buf[d.pos:])
+	d.pos += int(l)
+	return b, nil
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+func (d *decoder) rest() []byte {
+	b := make([]byte, d.remaining())
+	copy(b, d.buf[d.pos:])
+	d.pos = len(d.buf)
+	return b
+}
+
+func encodeLength(buf []byte, l int) []byte {
+	for {
+		b := byte(l % 128)
+		l /= 128
+		if l > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if l == 0 {
+			return buf
+		}
+	}
+}
+
+func readLength(r io.ByteReader) (int, error) {
+	var l, mul int = 0, 1
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		l += int(b&0x7f) * mul
+		if b&0x80 == 0 {
+			return l, nil
+		}
+		mul *= 128
+	}
+	return 0, ErrMalformed
+}
+
+// Reader reads packets from a stream
+type Reader struct {
+	// Version is the protocol level used to decode packets, it's updated
+	// automatically when CONNECT is read
+	Version byte
+
+	r *bufio.Reader
+}
+
+// NewReader creates a Reader
+func NewReader(r io.Reader) *Reader {
+	return &Reader{Version: ProtocolV311, r: bufio.NewReader(r)}
+}
+
+// ReadPacket reads the next packet
+func (r *Reader) ReadPacket() (Packet, error) {
+	header, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	l, err := readLength(r.r)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, l)
+	if _, err = io.ReadFull(r.r, body); err != nil {
+		return nil, err
+	}
+	pkt := newPacket(header >> 4)
+	if pkt == nil {
+		return nil, ErrMalformed
+	}
+	if err = pkt.decode(&decoder{version: r.Version, buf: body}, header&0x0f); err != nil {
+		return nil, err
+	}
+	if connect, ok := pkt.(*Connect); ok {
+		r.Version = connect.ProtocolLevel
+	}
+	return pkt, nil
+}
+
+// WritePacket encodes and writes the packet
+func WritePacket(w io.Writer, pkt Packet, version byte) error {
+	data, err := Encode(pkt, version)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Encode encodes the packet into bytes using the protocol level
+func Encode(pkt Packet, version byte) ([]byte, error) {
+	enc := &encoder{version: version}
+	if connect, ok := pkt.(*Connect); ok {
+		enc.version = connect.ProtocolLevel
+	}
+	flags := pkt.encode(enc)
+	if len(enc.buf) > MaxRemainingLength {
+		return nil, ErrPacketTooLarge
+	}
+	data := make([]byte, 0, len(enc.buf)+5)
+	data = append(data, pkt.Type()<<4|flags&0x0f)
+	data = encodeLength(data, len(enc.buf))
+	return append(data, enc.buf...), nil
+}
@@ -0,0 +1,673 @@
+// Package packets implements encoding of MQTT 3.1/3.1.1/5 control packets
+// MQTT v5 only fields (properties, reason codes) are ignored when encoding
+// and left empty when decoding packets of earlier protocol levels
+package packets
+
+// Control packet types
+const (
+	CONNECT     byte = 1
+	CONNACK     byte = 2
+	PUBLISH     byte = 3
+	PUBACK      byte = 4
+	PUBREC      byte = 5
+	PUBREL      byte = 6
+	PUBCOMP     byte = 7
+	SUBSCRIBE   byte = 8
+	SUBACK      byte = 9
+	UNSUBSCRIBE byte = 10
+	UNSUBACK    byte = 11
+	PINGREQ     byte = 12
+	PINGRESP    byte = 13
+	DISCONNECT  byte = 14
+	AUTH        byte = 15
+)
+
+// Protocol levels
+const (
+	// ProtocolV31 is MQTT 3.1 (protocol name MQIsdp)
+	ProtocolV31 byte = 3
+	// ProtocolV311 is MQTT 3.1.1
+	ProtocolV311 byte = 4
+	// ProtocolV5 is MQTT 5.0
+	ProtocolV5 byte = 5
+)
+
+// CONNACK return codes
+const (
+	Accepted                   byte = 0
+	RefusedProtocolVersion     byte = 1
+	RefusedIdentifierRejected  byte = 2
+	RefusedServerUnavailable   byte = 3
+	RefusedBadUsernamePassword byte = 4
+	RefusedNotAuthorized       byte = 5
+)
+
+// SubscribeFailure is the SUBACK return code for a rejected subscription
+const SubscribeFailure byte = 0x80
+
+const (
+	connectFlagUsername     byte = 0x80
+	connectFlagPassword     byte = 0x40
+	connectFlagWillRetain   byte = 0x20
+	connectFlagWill         byte = 0x04
+	connectFlagCleanSession byte = 0x02
+	connectFlagWillQoSShift      = 3
+
+	publishFlagDup      byte = 0x08
+	publishFlagRetain   byte = 0x01
+	publishFlagQoSShift      = 1
+
+	subscribeOptNoLocal             byte = 0x04
+	subscribeOptRetainAsPublished   byte = 0x08
+	subscribeOptRetainHandlingShift      = 4
+
+	// fixed header flags required by PUBREL, SUBSCRIBE and UNSUBSCRIBE
+	flagsRequired byte = 0x02
+)
+
+// Packet is the abstraction of a control packet
+type Packet interface {
+	// Type returns control packet type
+	Type() byte
+
+	encode(*encoder) (flags byte)
+	decode(dec *decoder, flags byte) error
+}
+
+func newPacket(typ byte) Packet {
+	switch typ {
+	case CONNECT:
+		return &Connect{}
+	case CONNACK:
+		return &Connack{}
+	case PUBLISH:
+		return &Publish{}
+	case PUBACK, PUBREC, PUBREL, PUBCOMP:
+		return &Ack{PacketType: typ}
+	case SUBSCRIBE:
+		return &Subscribe{}
+	case SUBACK:
+		return &Suback{}
+	case UNSUBSCRIBE:
+		return &Unsubscribe{}
+	case UNSUBACK:
+		return &Unsuback{}
+	case PINGREQ, PINGRESP:
+		return &Empty{PacketType: typ}
+	case DISCONNECT:
+		return &Disconnect{}
+	case AUTH:
+		return &Auth{}
+	}
+	return nil
+}
+
+// Will is the will message in CONNECT
+type Will struct {
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+	Properties *Properties
+}
+
+// Connect is CONNECT packet
+type Connect struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanSession  bool
+	KeepAlive     uint16
+	ClientID      string
+	Will          *Will
+	Username      *string
+	Password      []byte
+	Properties    *Properties
+}
+
+// Type implements Packet
+func (p *Connect) Type() byte {
+	return CONNECT
+}
+
+func (p *Connect) encode(enc *encoder) byte {
+	enc.string(p.ProtocolName)
+	enc.byte(p.ProtocolLevel)
+	var flags byte
+	if p.Username != nil {
+		flags |= connectFlagUsername
+	}
+	if p.Password != nil {
+		flags |= connectFlagPassword
+	}
+	if p.Will != nil {
+		flags |= connectFlagWill | (p.Will.QoS&3)<<connectFlagWillQoSShift
+		if p.Will.Retain {
+			flags |= connectFlagWillRetain
+		}
+	}
+	if p.CleanSession {
+		flags |= connectFlagCleanSession
+	}
+	enc.byte(flags)
+	enc.uint16(p.KeepAlive)
+	if enc.v5() {
+		p.Properties.encode(enc)
+	}
+	enc.string(p.ClientID)
+	if p.Will != nil {
+		if enc.v5() {
+			p.Will.Properties.encode(enc)
+		}
+		enc.string(p.Will.Topic)
+		enc.bytes(p.Will.Payload)
+	}
+	if p.Username != nil {
+		enc.string(*p.Username)
+	}
+	if p.Password != nil {
+		enc.bytes(p.Password)
+	}
+	return 0
+}
+
+func (p *Connect) decode(dec *decoder, _ byte) (err error) {
+	if p.ProtocolName, err = dec.string(); err != nil {
+		return
+	}
+	if p.ProtocolLevel, err = dec.byte(); err != nil {
+		return
+	}
+	// the rest of the packet is encoded using the protocol level it specifies
+	dec.version = p.ProtocolLevel
+	var flags byte
+	if flags, err = dec.byte(); err != nil {
+		return
+	}
+	if flags&0x01 != 0 {
+		return ErrMalformed
+	}
+	p.CleanSession = flags&connectFlagCleanSession != 0
+	if p.KeepAlive, err = dec.uint16(); err != nil {
+		return
+	}
+	if dec.v5() {
+		if p.Properties, err = decodeProperties(dec); err != nil {
+			return
+		}
+	}
+	if p.ClientID, err = dec.string(); err != nil {
+		return
+	}
+	if flags&connectFlagWill != 0 {
+		p.Will = &Will{
+			QoS:    (flags >> connectFlagWillQoSShift) & 3,
+			Retain: flags&connectFlagWillRetain != 0,
+		}
+		if dec.v5() {
+			if p.Will.Properties, err = decodeProperties(dec); err != nil {
+				return
+			}
+		}
+		if p.Will.Topic, err = dec.string(); err != nil {
+			return
+		}
+		if p.Will.Payload, err = dec.bytes(); err != nil {
+			return
+		}
+	}
+	if flags&connectFlagUsername != 0 {
+		var username string
+		if username, err = dec.string(); err != nil {
+			return
+		}
+		p.Username = &username
+	}
+	if flags&connectFlagPassword != 0 {
+		if p.Password, err = dec.bytes(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Connack is CONNACK packet
+// ReturnCode is the reason code in MQTT v5
+type Connack struct {
+	SessionPresent bool
+	ReturnCode     byte
+	Properties     *Properties
+}
+
+// Type implements Packet
+func (p *Connack) Type() byte {
+	return CONNACK
+}
+
+func (p *Connack) encode(enc *encoder) byte {
+	if p.SessionPresent {
+		enc.byte(1)
+	} else {
+		enc.byte(0)
+	}
+	enc.byte(p.ReturnCode)
+	if enc.v5() {
+		p.Properties.encode(enc)
+	}
+	return 0
+}
+
+func (p *Connack) decode(dec *decoder, _ byte) error {
+	flags, err := dec.byte()
+	if err != nil {
+		return err
+	}
+	p.SessionPresent = flags&1 != 0
+	if p.ReturnCode, err = dec.byte(); err != nil {
+		return err
+	}
+	if dec.v5() && dec.remaining() > 0 {
+		p.Properties, err = decodeProperties(dec)
+	}
+	return err
+}
+
+// Publish is PUBLISH packet
+type Publish struct {
+	Dup        bool
+	QoS        byte
+	Retain     bool
+	Topic      string
+	PacketID   uint16
+	Payload    []byte
+	Properties *Properties
+}
+
+// Type implements Packet
+func (p *Publish) Type() byte {
+	return PUBLISH
+}
+
+// Copy returns a copy of the packet, the payload is shared
+func (p *Publish) Copy() *Publish {
+	pkt := *p
+	pkt.Properties = p.Properties.Copy()
+	return &pkt
+}
+
+func (p *Publish) encode(enc *encoder) byte {
+	flags := (p.QoS & 3) << publishFlagQoSShift
+	if p.Dup {
+		flags |= publishFlagDup
+	}
+	if p.Retain {
+		flags |= publishFlagRetain
+	}
+	enc.string(p.Topic)
+	if p.QoS > 0 {
+		enc.uint16(p.PacketID)
+	}
+	if enc.v5() {
+		p.Properties.encode(enc)
+	}
+	enc.raw(p.Payload)
+	return flags
+}
+
+func (p *Publish) decode(dec *decoder, flags byte) (err error) {
+	p.Dup = flags&publishFlagDup != 0
+	p.QoS = (flags >> publishFlagQoSShift) & 3
+	p.Retain = flags&publishFlagRetain != 0
+	if p.QoS > 2 {
+		return ErrMalformed
+	}
+	if p.Topic, err = dec.string(); err != nil {
+		return
+	}
+	if p.QoS > 0 {
+		if p.PacketID, err = dec.uint16(); err != nil {
+			return
+		}
+	}
+	if dec.v5() {
+		if p.Properties, err = decodeProperties(dec); err != nil {
+			return
+		}
+	}
+	p.Payload = dec.rest()
+	return
+}
+
+// Ack is the packet only carrying packet ID (and reason code in MQTT v5):
+// PUBACK, PUBREC, PUBREL, PUBCOMP
+type Ack struct {
+	PacketType byte
+	PacketID   uint16
+	ReasonCode byte
+	Properties *Properties
+}
+
+// Type implements Packet
+func (p *Ack) Type() byte {
+	return p.PacketType
+}
+
+func (p *Ack) encode(enc *encoder) byte {
+	enc.uint16(p.PacketID)
+	// reason code and properties can be omitted for success
+	if enc.v5() && (p.ReasonCode != Success || p.Properties != nil) {
+		enc.byte(p.ReasonCode)
+		p.Properties.encode(enc)
+	}
+	if p.PacketType == PUBREL {
+		return flagsRequired
+	}
+	return 0
+}
+
+func (p *Ack) decode(dec *decoder, _ byte) (err error) {
+	if p.PacketID, err = dec.uint16(); err != nil {
+		return
+	}
+	if dec.v5() && dec.remaining() > 0 {
+		if p.ReasonCode, err = dec.byte(); err != nil {
+			return
+		}
+		if dec.remaining() > 0 {
+			p.Properties, err = decodeProperties(dec)
+		}
+	}
+	return
+}
+
+// Subscription is a topic filter with requested QoS
+// NoLocal, RetainAsPublished and RetainHandling are MQTT v5 subscription options
+type Subscription struct {
+	Filter            string
+	QoS               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+func (s *Subscription) options(v5 bool) byte {
+	opts := s.QoS & 3
+	if v5 {
+		if s.NoLocal {
+			opts |= subscribeOptNoLocal
+		}
+		if s.RetainAsPublished {
+			opts |= subscribeOptRetainAsPublished
+		}
+		opts |= (s.RetainHandling & 3) << subscribeOptRetainHandlingShift
+	}
+	return opts
+}
+
+func (s *Subscription) setOptions(opts byte, v5 bool) error {
+	s.QoS = opts & 3
+	if !v5 {
+		if opts&0xfc != 0 {
+			return ErrMalformed
+		}
+		return nil
+	}
+	if opts&0xc0 != 0 {
+		return ErrMalformed
+	}
+	s.NoLocal = opts&subscribeOptNoLocal != 0
+	s.RetainAsPublished = opts&subscribeOptRetainAsPublished != 0
+	s.RetainHandling = (opts >> subscribeOptRetainHandlingShift) & 3
+	return nil
+}
+
+// Subscribe is SUBSCRIBE packet
+type Subscribe struct {
+	PacketID      uint16
+	Subscriptions []Subscription
+	Properties    *Properties
+}
+
+// Type implements Packet
+func (p *Subscribe) Type() byte {
+	return SUBSCRIBE
+}
+
+func (p *Subscribe) encode(enc *encoder) byte {
+	enc.uint16(p.PacketID)
+	if enc.v5() {
+		p.Properties.encode(enc)
+	}
+	for _, sub := range p.Subscriptions {
+		enc.string(sub.Filter)
+		enc.byte(sub.options(enc.v5()))
+	}
+	return flagsRequired
+}
+
+func (p *Subscribe) decode(dec *decoder, _ byte) (err error) {
+	if p.PacketID, err = dec.uint16(); err != nil {
+		return
+	}
+	if dec.v5() {
+		if p.Properties, err = decodeProperties(dec); err != nil {
+			return
+		}
+	}
+	for dec.remaining() > 0 {
+		var sub Subscription
+		if sub.Filter, err = dec.string(); err != nil {
+			return
+		}
+		var opts byte
+		if opts, err = dec.byte(); err != nil {
+			return
+		}
+		if err = sub.setOptions(opts, dec.v5()); err != nil {
+			return
+		}
+		p.Subscriptions = append(p.Subscriptions, sub)
+	}
+	if len(p.Subscriptions) == 0 {
+		return ErrMalformed
+	}
+	return
+}
+
+// Suback is SUBACK packet
+type Suback struct {
+	PacketID    uint16
+	ReturnCodes []byte
+	Properties  *Properties
+}
+
+// Type implements Packet
+func (p *Suback) Type() byte {
+	return SUBACK
+}
+
+func (p *Suback) encode(enc *encoder) byte {
+	enc.uint16(p.PacketID)
+	if enc.v5() {
+		p.Properties.encode(enc)
+	}
+	enc.raw(p.ReturnCodes)
+	return 0
+}
+
+func (p *Suback) decode(dec *decoder, _ byte) (err error) {
+	if p.PacketID, err = dec.uint16(); err != nil {
+		return
+	}
+	if dec.v5() {
+		if p.Properties, err = decodeProperties(dec); err != nil {
+			return
+		}
+	}
+	p.ReturnCodes = dec.rest()
+	return
+}
+
+// Unsubscribe is UNSUBSCRIBE packet
+type Unsubscribe struct {
+	PacketID   uint16
+	Filters    []string
+	Properties *Properties
+}
+
+// Type implements Packet
+func (p *Unsubscribe) Type() byte {
+	return UNSUBSCRIBE
+}
+
+func (p *Unsubscribe) encode(enc *encoder) byte {
+	enc.uint16(p.PacketID)
+	if enc.v5() {
+		p.Properties.encode(enc)
+	}
+	for _, filter := range p.Filters {
+		enc.string(filter)
+	}
+	return flagsRequired
+}
+
+func (p *Unsubscribe) decode(dec *decoder, _ byte) (err error) {
+	if p.PacketID, err = dec.uint16(); err != nil {
+		return
+	}
+	if dec.v5() {
+		if p.Properties, err = decodeProperties(dec); err != nil {
+			return
+		}
+	}
+	for dec.remaining() > 0 {
+		var filter string
+		if filter, err = dec.string(); err != nil {
+			return
+		}
+		p.Filters = append(p.Filters, filter)
+	}
+	if len(p.Filters) == 0 {
+		return ErrMalformed
+	}
+	return
+}
+
+// Unsuback is UNSUBACK packet, ReasonCodes are only available in MQTT v5
+type Unsuback struct {
+	PacketID    uint16
+	ReasonCodes []byte
+	Properties  *Properties
+}
+
+// Type implements Packet
+func (p *Unsuback) Type() byte {
+	return UNSUBACK
+}
+
+func (p *Unsuback) encode(enc *encoder) byte {
+	enc.uint16(p.PacketID)
+	if enc.v5() {
+		p.Properties.encode(enc)
+		enc.raw(p.ReasonCodes)
+	}
+	return 0
+}
+
+func (p *Unsuback) decode(dec *decoder, _ byte) (err error) {
+	if p.PacketID, err = dec.uint16(); err != nil {
+		return
+	}
+	if dec.v5() {
+		if p.Properties, err = decodeProperties(dec); err != nil {
+			return
+		}
+		p.ReasonCodes = dec.rest()
+	}
+	return
+}
+
+// Disconnect is DISCONNECT packet, the reason code and properties are
+// only available in MQTT v5
+type Disconnect struct {
+	ReasonCode byte
+	Properties *Properties
+}
+
+// Type implements Packet
+func (p *Disconnect) Type() byte {
+	return DISCONNECT
+}
+
+func (p *Disconnect) encode(enc *encoder) byte {
+	// normal disconnection can be sent without variable header
+	if enc.v5() && (p.ReasonCode != NormalDisconnection || p.Properties != nil) {
+		enc.byte(p.ReasonCode)
+		p.Properties.encode(enc)
+	}
+	return 0
+}
+
+func (p *Disconnect) decode(dec *decoder, _ byte) (err error) {
+	if dec.v5() && dec.remaining() > 0 {
+		if p.ReasonCode, err = dec.byte(); err != nil {
+			return
+		}
+		if dec.remaining() > 0 {
+			p.Properties, err = decodeProperties(dec)
+		}
+	}
+	return
+}
+
+// Auth is AUTH packet introduced by MQTT v5
+type Auth struct {
+	ReasonCode byte
+	Properties *Properties
+}
+
+// Type implements Packet
+func (p *Auth) Type() byte {
+	return AUTH
+}
+
+func (p *Auth) encode(enc *encoder) byte {
+	if p.ReasonCode != Success || p.Properties != nil {
+		enc.byte(p.ReasonCode)
+		p.Properties.encode(enc)
+	}
+	return 0
+}
+
+func (p *Auth) decode(dec *decoder, _ byte) (err error) {
+	if !dec.v5() {
+		return ErrMalformed
+	}
+	if dec.remaining() > 0 {
+		if p.ReasonCode, err = dec.byte(); err != nil {
+			return
+		}
+		if dec.remaining() > 0 {
+			p.Properties, err = decodeProperties(dec)
+		}
+	}
+	return
+}
+
+// Empty is the packet without variable header and payload:
+// PINGREQ, PINGRESP
+type Empty struct {
+	PacketType byte
+}
+
+// Type implements Packet
+func (p *Empty) Type() byte {
+	return p.PacketType
+}
+
+func (p *Empty) encode(*encoder) byte {
+	return 0
+}
+
+func (p *Empty) decode(*decoder, byte) error {
+	return nil
+}
@@ -0,0 +1,344 @@
+package packets
+
+// Property identifiers defined by MQTT v5
+const (
+	PropPayloadFormat          byte = 0x01
+	PropMessageExpiry          byte = 0x02
+	PropContentType            byte = 0x03
+	PropResponseTopic          byte = 0x08
+	PropCorrelationData        byte = 0x09
+	PropSubscriptionIdentifier byte = 0x0B
+	PropSessionExpiry          byte = 0x11
+	PropAssignedClientID       byte = 0x12
+	PropServerKeepAlive        byte = 0x13
+	PropAuthMethod             byte = 0x15
+	PropAuthData               byte = 0x16
+	PropRequestProblemInfo     byte = 0x17
+	PropWillDelay              byte = 0x18
+	PropRequestResponseInfo    byte = 0x19
+	PropResponseInfo           byte = 0x1A
+	PropServerReference        byte = 0x1C
+	PropReasonString           byte = 0x1F
+	PropReceiveMaximum         byte = 0x21
+	PropTopicAliasMaximum      byte = 0x22
+	PropTopicAlias             byte = 0x23
+	PropMaximumQoS             byte = 0x24
+	PropRetainAvailable        byte = 0x25
+	PropUserProperty           byte = 0x26
+	PropMaximumPacketSize      byte = 0x27
+	PropWildcardSubAvailable   byte = 0x28
+	PropSubIDAvailable         byte = 0x29
+	PropSharedSubAvailable     byte = 0x2A
+)
+
+// UserProperty is a name/value pair, the same name may appear more than once
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// Properties are MQTT v5 properties, optional values are pointers
+type Properties struct {
+	PayloadFormat          *byte
+	MessageExpiry          *uint32
+	ContentType            string
+	ResponseTopic          string
+	CorrelationData        []byte
+	SubscriptionIdentifier []int
+	SessionExpiry          *uint32
+	AssignedClientID       string
+	ServerKeepAlive        *uint16
+	AuthMethod             string
+	AuthData               []byte
+	RequestProblemInfo     *byte
+	WillDelay              *uint32
+	RequestResponseInfo    *byte
+	ResponseInfo           string
+	ServerReference        string
+	ReasonString           string
+	ReceiveMaximum         *uint16
+	TopicAliasMaximum      *uint16
+	TopicAlias             *uint16
+	MaximumQoS             *byte
+	RetainAvailable        *byte
+	User                   []UserProperty
+	MaximumPacketSize      *uint32
+	WildcardSubAvailable   *byte
+	SubIDAvailable         *byte
+	SharedSubAvailable     *byte
+}
+
+// Copy returns a copy of properties, nil is returned if p is nil
+func (p *Properties) Copy() *Properties {
+	if p == nil {
+		return nil
+	}
+	props := *p
+	props.User = append([]UserProperty(nil), p.User...)
+	props.SubscriptionIdentifier = append([]int(nil), p.SubscriptionIdentifier...)
+	return &props
+}
+
+// Get returns the value of first user property with the key
+func (p *Properties) Get(key string) (string, bool) {
+	if p != nil {
+		for _, prop := range p.User {
+			if prop.Key == key {
+				return prop.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Add appends a user property
+func (p *Properties) Add(key, value string) *Properties {
+	p.User = append(p.User, UserProperty{Key: key, Value: value})
+	return p
+}
+
+// Uint16 returns a pointer of v, for setting optional properties
+func Uint16(v uint16) *uint16 {
+	return &v
+}
+
+// Uint32 returns a pointer of v, for setting optional properties
+func Uint32(v uint32) *uint32 {
+	return &v
+}
+
+// Byte returns a pointer of v, for setting optional properties
+func Byte(v byte) *byte {
+	return &v
+}
+
+func (p *Properties) encode(enc *encoder) {
+	props := &encoder{version: enc.version}
+	if p != nil {
+		if p.PayloadFormat != nil {
+			props.byte(PropPayloadFormat)
+			props.byte(*p.PayloadFormat)
+		}
+		if p.MessageExpiry != nil {
+			props.byte(PropMessageExpiry)
+			props.uint32(*p.MessageExpiry)
+		}
+		if p.ContentType != "" {
+			props.byte(PropContentType)
+			props.string(p.ContentType)
+		}
+		if p.ResponseTopic != "" {
+			props.byte(PropResponseTopic)
+			props.string(p.ResponseTopic)
+		}
+		if p.CorrelationData != nil {
+			props.byte(PropCorrelationData)
+			props.bytes(p.CorrelationData)
+		}
+		for _, id := range p.SubscriptionIdentifier {
+			props.byte(PropSubscriptionIdentifier)
+			props.varint(id)
+		}
+		if p.SessionExpiry != nil {
+			props.byte(PropSessionExpiry)
+			props.uint32(*p.SessionExpiry)
+		}
+		if p.AssignedClientID != "" {
+			props.byte(PropAssignedClientID)
+			props.string(p.AssignedClientID)
+		}
+		if p.ServerKeepAlive != nil {
+			props.byte(PropServerKeepAlive)
+			props.uint16(*p.ServerKeepAlive)
+		}
+		if p.AuthMethod != "" {
+			props.byte(PropAuthMethod)
+			props.string(p.AuthMethod)
+		}
+		if p.AuthData != nil {
+			props.byte(PropAuthData)
+			props.bytes(p.AuthData)
+		}
+		if p.RequestProblemInfo != nil {
+			props.byte(PropRequestProblemInfo)
+			props.byte(*p.RequestProblemInfo)
+		}
+		if p.WillDelay != nil {
+			props.byte(PropWillDelay)
+			props.uint32(*p.WillDelay)
+		}
+		if p.RequestResponseInfo != nil {
+			props.byte(PropRequestResponseInfo)
+			props.byte(*p.RequestResponseInfo)
+		}
+		if p.ResponseInfo != "" {
+			props.byte(PropResponseInfo)
+			props.string(p.ResponseInfo)
+		}
+		if p.ServerReference != "" {
+			props.byte(PropServerReference)
+			props.string(p.ServerReference)
+		}
+		if p.ReasonString != "" {
+			props.byte(PropReasonString)
+			props.string(p.ReasonString)
+		}
+		if p.ReceiveMaximum != nil {
+			props.byte(PropReceiveMaximum)
+			props.uint16(*p.ReceiveMaximum)
+		}
+		if p.TopicAliasMaximum != nil {
+			props.byte(PropTopicAliasMaximum)
+			props.uint16(*p.TopicAliasMaximum)
+		}
+		if p.TopicAlias != nil {
+			props.byte(PropTopicAlias)
+			props.uint16(*p.TopicAlias)
+		}
+		if p.MaximumQoS != nil {
+			props.byte(PropMaximumQoS)
+			props.byte(*p.MaximumQoS)
+		}
+		if p.RetainAvailable != nil {
+			props.byte(PropRetainAvailable)
+			props.byte(*p.RetainAvailable)
+		}
+		for _, prop := range p.User {
+			props.byte(PropUserProperty)
+			props.string(prop.Key)
+			props.string(prop.Value)
+		}
+		if p.MaximumPacketSize != nil {
+			props.byte(PropMaximumPacketSize)
+			props.uint32(*p.MaximumPacketSize)
+		}
+		if p.WildcardSubAvailable != nil {
+			props.byte(PropWildcardSubAvailable)
+			props.byte(*p.WildcardSubAvailable)
+		}
+		if p.SubIDAvailable != nil {
+			props.byte(PropSubIDAvailable)
+			props.byte(*p.SubIDAvailable)
+		}
+		if p.SharedSubAvailable != nil {
+			props.byte(PropSharedSubAvailable)
+			props.byte(*p.SharedSubAvailable)
+		}
+	}
+	enc.varint(len(props.buf))
+	enc.raw(props.buf)
+}
+
+// decodeProperties decodes properties, nil is returned if there's no property
+func decodeProperties(dec *decoder) (*Properties, error) {
+	l, err := dec.varint()
+	if err != nil {
+		return nil, err
+	}
+	if l == 0 {
+		return nil, nil
+	}
+	if dec.remaining() < l {
+		return nil, ErrMalformed
+	}
+	props := &decoder{version: dec.version, buf: dec.buf[dec.pos : dec.pos+l]}
+	dec.pos += l
+	p := &Properties{}
+	for props.remaining() > 0 {
+		id, err := props.byte()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.decodeProperty(id, props); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Properties) decodeProperty(id byte, dec *decoder) (err error) {
+	var b byte
+	var u16 uint16
+	var u32 uint32
+	var n int
+	switch id {
+	case PropPayloadFormat:
+		b, err = dec.byte()
+		p.PayloadFormat = &b
+	case PropMessageExpiry:
+		u32, err = dec.uint32()
+		p.MessageExpiry = &u32
+	case PropContentType:
+		p.ContentType, err = dec.string()
+	case PropResponseTopic:
+		p.ResponseTopic, err = dec.string()
+	case PropCorrelationData:
+		p.CorrelationData, err = dec.bytes()
+	case PropSubscriptionIdentifier:
+		n, err = dec.varint()
+		p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, n)
+	case PropSessionExpiry:
+		u32, err = dec.uint32()
+		p.SessionExpiry = &u32
+	case PropAssignedClientID:
+		p.AssignedClientID, err = dec.string()
+	case PropServerKeepAlive:
+		u16, err = dec.uint16()
+		p.ServerKeepAlive = &u16
+	case PropAuthMethod:
+		p.AuthMethod, err = dec.string()
+	case PropAuthData:
+		p.AuthData, err = dec.bytes()
+	case PropRequestProblemInfo:
+		b, err = dec.byte()
+		p.RequestProblemInfo = &b
+	case PropWillDelay:
+		u32, err = dec.uint32()
+		p.WillDelay = &u32
+	case PropRequestResponseInfo:
+		b, err = dec.byte()
+		p.RequestResponseInfo = &b
+	case PropResponseInfo:
+		p.ResponseInfo, err = dec.string()
+	case PropServerReference:
+		p.ServerReference, err = dec.string()
+	case PropReasonString:
+		p.ReasonString, err = dec.string()
+	case PropReceiveMaximum:
+		u16, err = dec.uint16()
+		p.ReceiveMaximum = &u16
+	case PropTopicAliasMaximum:
+		u16, err = dec.uint16()
+		p.TopicAliasMaximum = &u16
+	case PropTopicAlias:
+		u16, err = dec.uint16()
+		p.TopicAlias = &u16
+	case PropMaximumQoS:
+		b, err = dec.byte()
+		p.MaximumQoS = &b
+	case PropRetainAvailable:
+		b, err = dec.byte()
+		p.RetainAvailable = &b
+	case PropUserProperty:
+		var prop UserProperty
+		if prop.Key, err = dec.string(); err == nil {
+			prop.Value, err = dec.string()
+		}
+		p.User = append(p.User, prop)
+	case PropMaximumPacketSize:
+		u32, err = dec.uint32()
+		p.MaximumPacketSize = &u32
+	case PropWildcardSubAvailable:
+		b, err = dec.byte()
+		p.WildcardSubAvailable = &b
+	case PropSubIDAvailable:
+		b, err = dec.byte()
+		p.SubIDAvailable = &b
+	case PropSharedSubAvailable:
+		b, err = dec.byte()
+		p.SharedSubAvailable = &b
+	default:
+		err = ErrMalformed
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package packets
+
+import "fmt"
+
+// Reason codes defined by MQTT v5, values less than 0x80 indicate success
+const (
+	Success                     byte = 0x00
+	NormalDisconnection         byte = 0x00
+	GrantedQoS0                 byte = 0x00
+	GrantedQoS1                 byte = 0x01
+	GrantedQoS2                 byte = 0x02
+	DisconnectWithWill          byte = 0x04
+	NoMatchingSubscribers       byte = 0x10
+	NoSubscriptionExisted       byte = 0x11
+	ContinueAuthentication      byte = 0x18
+	ReAuthenticate              byte = 0x19
+	UnspecifiedError            byte = 0x80
+	MalformedPacket             byte = 0x81
+	ProtocolError               byte = 0x82
+	ImplementationSpecificError byte = 0x83
+	UnsupportedProtocolVersion  byte = 0x84
+	ClientIdentifierNotValid    byte = 0x85
+	BadUsernameOrPassword       byte = 0x86
+	NotAuthorized               byte = 0x87
+	ServerUnavailable           byte = 0x88
+	ServerBusy                  byte = 0x89
+	Banned                      byte = 0x8A
+	ServerShuttingDown          byte = 0x8B
+	BadAuthenticationMethod     byte = 0x8C
+	KeepAliveTimeout            byte = 0x8D
+	SessionTakenOver            byte = 0x8E
+	TopicFilterInvalid          byte = 0x8F
+	TopicNameInvalid            byte = 0x90
+	PacketIdentifierInUse       byte = 0x91
+	PacketIdentifierNotFound    byte = 0x92
+	ReceiveMaximumExceeded      byte = 0x93
+	TopicAliasInvalid           byte = 0x94
+	PacketTooLarge              byte = 0x95
+	MessageRateTooHigh          byte = 0x96
+	QuotaExceeded               byte = 0x97
+	AdministrativeAction        byte = 0x98
+	PayloadFormatInvalid        byte = 0x99
+	RetainNotSupported          byte = 0x9A
+	QoSNotSupported             byte = 0x9B
+	UseAnotherServer            byte = 0x9C
+	ServerMoved                 byte = 0x9D
+	SharedSubNotSupported       byte = 0x9E
+	ConnectionRateExceeded      byte = 0x9F
+	MaximumConnectTime          byte = 0xA0
+	SubIDNotSupported           byte = 0xA1
+	WildcardSubNotSupported     byte = 0xA2
+)
+
+var reasonTexts = map[byte]string{
+	Success:                     "success",
+	DisconnectWithWill:          "disconnect with will message",
+	NoMatchingSubscribers:       "no matching subscribers",
+	NoSubscriptionExisted:       "no subscription existed",
+	ContinueAuthentication:      "continue authentication",
+	ReAuthenticate:              "re-authenticate",
+	UnspecifiedError:            "unspecified error",
+	MalformedPacket:             "malformed packet",
+	ProtocolError:               "protocol error",
+	ImplementationSpecificError: "implementation specific error",
+	UnsupportedProtocolVersion:  "unsupported protocol version",
+	ClientIdentifierNotValid:    "client identifier not valid",
+	BadUsernameOrPassword:       "bad user name or password",
+	NotAuthorized:               "not authorized",
+	ServerUnavailable:           "server unavailable",
+	ServerBusy:                  "server busy",
+	Banned:                      "banned",
+	ServerShuttingDown:          "server shutting down",
+	BadAuthenticationMethod:     "bad authentication method",
+	KeepAliveTimeout:            "keep alive timeout",
+	SessionTakenOver:            "session taken over",
+	TopicFilterInvalid:          "topic filter invalid",
+	TopicNameInvalid:            "topic name invalid",
+	PacketIdentifierInUse:       "packet identifier in use",
+	PacketIdentifierNotFound:    "packet identifier not found",
+	ReceiveMaximumExceeded:      "receive maximum exceeded",
+	TopicAliasInvalid:           "topic alias invalid",
+	PacketTooLarge:              "packet too large",
+	MessageRateTooHigh:          "message rate too high",
+	QuotaExceeded:               "quota exceeded",
+	AdministrativeAction:        "administrative action",
+	PayloadFormatInvalid:        "payload format invalid",
+	RetainNotSupported:          "retain not supported",
+	QoSNotSupported:             "QoS not supported",
+	UseAnotherServer:            "use another server",
+	ServerMoved:                 "server moved",
+	SharedSubNotSupported:       "shared subscriptions not supported",
+	ConnectionRateExceeded:      "connection rate exceeded",
+	MaximumConnectTime:          "maximum connect time",
+	SubIDNotSupported:           "subscription identifiers not supported",
+	WildcardSubNotSupported:     "wildcard subscriptions not supported",
+}
+
+// ReasonText returns the description of a reason code
+func ReasonText(code byte) string {
+	if text, ok := reasonTexts[code]; ok {
+		return text
+	}
+	return fmt.Sprintf("reason code 0x%02x", code)
+}
+
+// IsFailure indicates the reason code represents a failure
+func IsFailure(code byte) bool {
+	return code >= 0x80
+}
@@ -0,0 +1,155 @@
+package mqtt
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+// presenceTopic is the topic of the presence endpoint of a component
+func presenceTopic(compTopic string) string {
+	return EndpointTopic(compTopic, mqhub.PresenceEndpoint)
+}
+
+// topicLevelEscaper escapes the characters not allowed in a topic level
+var topicLevelEscaper = strings.NewReplacer("%", "%25", "/", "%2F", "+", "%2B", "#", "%23")
+
+// hostTopic is the topic of the connector as a component, the client ID is
+// escaped as a single topic level, and never starts with $ which is
+// reserved by brokers
+func hostTopic(clientID string) string {
+	topic := topicLevelEscaper.Replace(clientID)
+	if strings.HasPrefix(topic, "$") {
+		topic = "%24" + topic[1:]
+	}
+	return topic
+}
+
+// presenceRef is the presence of a published component
+type presenceRef struct {
+	conn  *Connector
+	topic string
+}
+
+// Watch implements Watchable
+func (r *presenceRef) Watch(sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	return r.WatchContext(context.Background(), sink)
+}
+
+// WatchContext implements ContextWatchable
+func (r *presenceRef) WatchContext(ctx context.Context, sink mqhub.MessageSink) (mqhub.Watcher, error) {
+	w := &presenceWatcher{ref: r, sink: sink, hostOnline: true}
+	w.handler = MakeHandlerRef(w.recvPresence)
+	w.hostHandler = MakeHandlerRef(w.recvHostPresence)
+	err := r.conn.sub(map[string]byte{presenceTopic(r.topic): 1}, w.handler).WaitContext(ctx)
+	if err != nil {
+		w.Close()
+	}
+	return w, err
+}
+
+// presenceWatcher watches the presence of the component as well as the
+// presence of its host connector, as the Last Will only covers the latter
+type presenceWatcher struct {
+	ref         *presenceRef
+	sink        mqhub.MessageSink
+	handler     *HandlerRef
+	hostHandler *HandlerRef
+
+	presence   mqhub.Presence
+	hostTopic  string
+	hostOnline bool
+	last       *mqhub.Presence
+	lock       sync.Mutex
+}
+
+// Close implements Watcher
+func (w *presenceWatcher) Close() error {
+	w.lock.Lock()
+	hostTopic := w.hostTopic
+	w.hostTopic = ""
+	w.lock.Unlock()
+	if hostTopic != "" {
+		w.ref.conn.unsub([]string{hostTopic}, w.hostHandler)
+	}
+	w.ref.conn.unsub([]string{presenceTopic(w.ref.topic)}, w.handler)
+	return nil
+}
+
+// Watched implements Watcher
+func (w *presenceWatcher) Watched() mqhub.Watchable {
+	return w.ref
+}
+
+func (w *presenceWatcher) recvPresence(_ paho.Client, msg paho.Message) {
+	var presence mqhub.Presence
+	if err := w.ref.conn.newMsg(msg).As(&presence); err != nil {
+		return
+	}
+	var hostTopic string
+	if presence.Host != "" {
+		hostTopic = presenceTopic(presence.Host)
+	}
+	if hostTopic == presenceTopic(w.ref.topic) {
+		// the Last Will is on the component itself
+		hostTopic = ""
+	}
+	w.lock.Lock()
+	w.presence = presence
+	prevHostTopic := w.hostTopic
+	if hostTopic != prevHostTopic {
+		w.hostTopic, w.hostOnline = hostTopic, true
+	}
+	update := w.update()
+	w.lock.Unlock()
+
+	if hostTopic != prevHostTopic {
+		if prevHostTopic != "" {
+			w.ref.conn.unsub([]string{prevHostTopic}, w.hostHandler)
+		}
+		if hostTopic != "" {
+			// not waiting inside the message handler
+			w.ref.conn.sub(map[string]byte{hostTopic: 1}, w.hostHandler)
+		}
+	}
+	if update != nil {
+		w.sink.ConsumeMessage(update)
+	}
+}
+
+func (w *presenceWatcher) recvHostPresence(_ paho.Client, msg paho.Message) {
+	var presence mqhub.Presence
+	m := w.ref.conn.newMsg(msg)
+	if err := m.As(&presence); err != nil {
+		return
+	}
+	topic := EndpointTopic(m.Component(), m.Endpoint())
+	w.lock.Lock()
+	var update *mqhub.Presence
+	if topic == w.hostTopic {
+		w.hostOnline = presence.Online
+		update = w.update()
+	}
+	w.lock.Unlock()
+	if update != nil {
+		w.sink.ConsumeMessage(update)
+	}
+}
+
+// update returns the presence to emit if changed
+func (w *presenceWatcher) update() *mqhub.Presence {
+	presence := mqhub.Presence{
+		ComponentID: w.ref.topic,
+		Online:      w.presence.Online && w.hostOnline,
+		Host:        w.presence.Host,
+	}
+	if w.last != nil && *w.last == presence {
+		return nil
+	}
+	w.last = &presence
+	emit := presence
+	return &emit
+}
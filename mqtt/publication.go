@@ -1,7 +1,9 @@
 package mqtt
 
 import (
+	"context"
 	"path"
+	"sync"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/robotalks/mqhub.go/mqhub"
@@ -9,12 +11,49 @@ import (
 
 // Publication implements mqhub.Publication
 type Publication struct {
+	endpointSet
 	conn    *Connector
 	comp    mqhub.Component
 	desc    Descriptor
-	emits   map[string]*DataEmitter
-	sinks   map[string]*DataSink
 	handler *HandlerRef
+	lock    sync.RWMutex
+	// share is the share group of reactors not specifying one
+	share string
+	// interceptor is applied inside the one of the connector
+	interceptor mqhub.Interceptor
+	// exported is set once the endpoints are attached by export
+	exported bool
+}
+
+// endpointSet is the endpoints of a publication, or the endpoints
+// added to or removed from it, by topics
+type endpointSet struct {
+	emits    map[string]*DataEmitter
+	sinks    map[string]*DataSink
+	dynamics map[string]mqhub.DynamicComponent
+}
+
+func newEndpointSet() endpointSet {
+	return endpointSet{
+		emits:    make(map[string]*DataEmitter),
+		sinks:    make(map[string]*DataSink),
+		dynamics: make(map[string]mqhub.DynamicComponent),
+	}
+}
+
+// copy returns a set with the same endpoints
+func (s endpointSet) copy() endpointSet {
+	c := newEndpointSet()
+	for topic, emit := range s.emits {
+		c.emits[topic] = emit
+	}
+	for topic, sink := range s.sinks {
+		c.sinks[topic] = sink
+	}
+	for topic, dynamic := range s.dynamics {
+		c.dynamics[topic] = dynamic
+	}
+	return c
 }
 
 // Component implements Publication
@@ -22,99 +61,379 @@ func (p *Publication) Component() mqhub.Component {
 	return p.comp
 }
 
-// Close implements Publication
+// Close implements Publication, the reactors are unsubscribed, the
+// retained states are cleared if enabled, the advertisement is removed
+// and the component goes offline, all the errors are reported
 func (p *Publication) Close() error {
-	p.unexport()
+	futures := mqhub.Futures{
+		p.unexport(),
+		p.conn.clearRetained(p.advTopic()),
+		p.announce(false),
+	}
 	p.conn.removePub(p)
-	return nil
+	return futures.Wait()
+}
+
+// Use appends the interceptors applied to the datapoints and reactors of
+// the publication, inside the ones of the connector
+func (p *Publication) Use(interceptors ...mqhub.Interceptor) *Publication {
+	p.lock.Lock()
+	p.interceptor = mqhub.ChainInterceptors(append([]mqhub.Interceptor{p.interceptor}, interceptors...)...)
+	p.lock.Unlock()
+	return p
 }
 
-func newPublication(conn *Connector, comp mqhub.Component) *Publication {
+// intercept passes the message to the sink through the interceptors of
+// the connector and the publication
+func (p *Publication) intercept(msg mqhub.Message, info *mqhub.SinkInfo, sink mqhub.MessageSink) mqhub.Future {
+	p.lock.RLock()
+	interceptor := p.interceptor
+	p.lock.RUnlock()
+	return mqhub.Intercept(sink, info, p.conn.interceptor, interceptor).ConsumeMessage(msg)
+}
+
+func newPublication(conn *Connector, comp mqhub.Component, share string) *Publication {
 	pub := &Publication{
-		conn: conn,
-		comp: comp,
+		endpointSet: newEndpointSet(),
+		conn:        conn,
+		comp:        comp,
 		desc: Descriptor{
 			ComponentID: comp.ID(),
 			SubTopic:    comp.ID(),
 			conn:        conn,
 		},
-		emits: make(map[string]*DataEmitter),
-		sinks: make(map[string]*DataSink),
+		share: share,
 	}
 	pub.handler = MakeHandlerRef(pub.handleMessage)
-	pub.populate(pub.desc.SubTopic, comp)
+	pub.populate(&pub.endpointSet, pub.desc.SubTopic, comp, true)
 	return pub
 }
 
-func (p *Publication) populate(topic string, comp mqhub.Component) {
+// populate collects the endpoints of the component and sub-components, with
+// observe, dynamic components are observed before enumerated, so no change
+// is missed, and the changes already enumerated are skipped by add and remove
+func (p *Publication) populate(set *endpointSet, topic string, comp mqhub.Component, observe bool) {
+	if dynamic, ok := comp.(mqhub.DynamicComponent); ok {
+		set.dynamics[topic] = dynamic
+		if observe {
+			dynamic.ObserveComposition(compObserver{pub: p, topic: topic})
+		}
+	}
 	endpoints := comp.Endpoints()
 	for _, endpoint := range endpoints {
-		if datapoint, ok := endpoint.(mqhub.MessageSource); ok {
-			endpointTopic := EndpointTopic(topic, endpoint.ID())
-			p.emits[endpointTopic] = &DataEmitter{
-				pub:    p,
-				topic:  endpointTopic,
-				source: datapoint,
-			}
-		}
-		if reactor, ok := endpoint.(mqhub.MessageSink); ok {
-			endpointTopic := EndpointTopic(topic, endpoint.ID())
-			p.sinks[endpointTopic] = &DataSink{
-				pub:   p,
-				topic: endpointTopic,
-				sink:  reactor,
-			}
-		}
+		p.populateEndpoint(set, topic, endpoint)
 	}
 	if composite, ok := comp.(mqhub.Composite); ok {
 		components := composite.Components()
 		for _, c := range components {
-			p.populate(path.Join(topic, c.ID()), c)
+			p.populate(set, path.Join(topic, c.ID()), c, observe)
 		}
 	}
 }
 
-func (p *Publication) export() error {
-	topics := make([]string, 0, len(p.sinks))
-	for topic := range p.sinks {
-		topics = append(topics, topic)
+func (p *Publication) populateEndpoint(set *endpointSet, topic string, endpoint mqhub.Endpoint) {
+	qos := p.conn.qos(mqhub.EndpointQoS(endpoint))
+	codec := mqhub.EndpointCodec(endpoint)
+	if datapoint, ok := endpoint.(mqhub.MessageSource); ok {
+		endpointTopic := EndpointTopic(topic, endpoint.ID())
+		set.emits[endpointTopic] = &DataEmitter{
+			pub:    p,
+			info:   mqhub.SinkInfo{Kind: mqhub.EmitterSink, Topic: endpointTopic},
+			topic:  endpointTopic,
+			qos:    qos,
+			codec:  codec,
+			retain: mqhub.EndpointRetain(endpoint),
+			source: datapoint,
+		}
 	}
-	err := p.conn.sub(topics, p.handler).Wait()
-	if err == nil {
-		for _, emit := range p.emits {
-			emit.bind()
+	if reactor, ok := endpoint.(mqhub.MessageSink); ok {
+		endpointTopic := EndpointTopic(topic, endpoint.ID())
+		share := mqhub.EndpointShareGroup(endpoint)
+		if share == "" {
+			share = p.share
 		}
+		set.sinks[endpointTopic] = &DataSink{
+			pub:    p,
+			info:   mqhub.SinkInfo{Kind: mqhub.ReactorSink, Topic: endpointTopic},
+			topic:  endpointTopic,
+			filter: SharedFilter(share, endpointTopic),
+			qos:    qos,
+			codec:  codec,
+			sink:   reactor,
+		}
+	}
+}
+
+func (p *Publication) export(ctx context.Context) error {
+	p.lock.Lock()
+	p.exported = true
+	set := p.endpointSet.copy()
+	p.lock.Unlock()
+	err := p.attach(set).WaitContext(ctx)
+	if err == nil {
+		err = mqhub.WaitContext(ctx, p.advertise())
+	}
+	if err == nil {
+		err = mqhub.WaitContext(ctx, p.announce(true))
 	}
 	return err
 }
 
-func (p *Publication) unexport() {
-	for _, emit := range p.emits {
+// attach subscribes the reactors and the topics of their wrapped requests
+// (MQTT 3.x only), and binds the datapoints in the set
+func (p *Publication) attach(set endpointSet) mqhub.ContextFuture {
+	_, v5 := p.conn.Client.(propertiesPublisher)
+	topics := make(map[string]byte)
+	for _, sink := range set.sinks {
+		topics[sink.filter] = sink.qos
+		if !v5 {
+			topics[sink.filter+callSuffix] = sink.qos
+		}
+	}
+	future := p.conn.sub(topics, p.handler)
+	for _, emit := range set.emits {
+		emit.bind()
+	}
+	return future
+}
+
+// detach reverts attach, and clears the retained states if enabled
+func (p *Publication) detach(set endpointSet) mqhub.Future {
+	for topic, dynamic := range set.dynamics {
+		dynamic.UnobserveComposition(compObserver{pub: p, topic: topic})
+	}
+	var futures mqhub.Futures
+	for _, emit := range set.emits {
 		emit.unbind()
+		if p.conn.clearStates && emit.retained() {
+			futures = append(futures, p.conn.clearRetained(emit.topic))
+		}
+	}
+	_, v5 := p.conn.Client.(propertiesPublisher)
+	topics := make([]string, 0, 2*len(set.sinks))
+	for _, sink := range set.sinks {
+		topics = append(topics, sink.filter)
+		if !v5 {
+			topics = append(topics, sink.filter+callSuffix)
+		}
 	}
-	topics := make([]string, 0, len(p.sinks))
-	p.conn.unsub(topics, p.handler)
+	return append(futures, p.conn.unsub(topics, p.handler))
+}
+
+// add applies the endpoints added after being observed, the ones already
+// in the publication are skipped, and before exported, they are attached
+// by export
+func (p *Publication) add(set endpointSet) mqhub.Future {
+	added := newEndpointSet()
+	p.lock.Lock()
+	for topic, emit := range set.emits {
+		if p.emits[topic] == nil {
+			p.emits[topic], added.emits[topic] = emit, emit
+		}
+	}
+	for topic, sink := range set.sinks {
+		if p.sinks[topic] == nil {
+			p.sinks[topic], added.sinks[topic] = sink, sink
+		}
+	}
+	for topic, dynamic := range set.dynamics {
+		if p.dynamics[topic] == nil {
+			p.dynamics[topic], added.dynamics[topic] = dynamic, dynamic
+		}
+	}
+	exported := p.exported
+	p.lock.Unlock()
+	if !exported {
+		return &Future{}
+	}
+	return mqhub.Futures{p.attach(added), p.advertise()}
+}
+
+// remove applies the endpoints removed after being observed, the ones not
+// in the publication are skipped
+func (p *Publication) remove(set endpointSet) mqhub.Future {
+	removed := newEndpointSet()
+	p.lock.Lock()
+	for topic := range set.emits {
+		if emit := p.emits[topic]; emit != nil {
+			delete(p.emits, topic)
+			removed.emits[topic] = emit
+		}
+	}
+	for topic := range set.sinks {
+		if sink := p.sinks[topic]; sink != nil {
+			delete(p.sinks, topic)
+			removed.sinks[topic] = sink
+		}
+	}
+	for topic := range set.dynamics {
+		if dynamic := p.dynamics[topic]; dynamic != nil {
+			delete(p.dynamics, topic)
+			removed.dynamics[topic] = dynamic
+		}
+	}
+	exported := p.exported
+	p.lock.Unlock()
+	if !exported {
+		for topic, dynamic := range removed.dynamics {
+			dynamic.UnobserveComposition(compObserver{pub: p, topic: topic})
+		}
+		return &Future{}
+	}
+	return mqhub.Futures{p.detach(removed), p.advertise()}
+}
+
+// reexport restores the publication after reconnected, the subscriptions
+// are already restored by Connector
+func (p *Publication) reexport(republishStates bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, emit := range p.emits {
+		emit.bind()
+		if republishStates {
+			emit.republish()
+		}
+	}
+	p.advertise()
+	p.announce(true)
+}
+
+func (p *Publication) advTopic() string {
+	return EndpointTopic(p.desc.SubTopic, mqhub.AdvertisementEndpoint)
+}
+
+// advertise publishes the Advertisement of the component
+func (p *Publication) advertise() mqhub.Future {
+	return p.conn.pub(p.advTopic(), 1, mqhub.JSON, mqhub.StateFrom(mqhub.AdvertisementOf(p.comp)))
+}
+
+// announce publishes the presence of the component if presence is enabled
+func (p *Publication) announce(online bool) mqhub.Future {
+	if p.conn.hostID == "" {
+		return &Future{}
+	}
+	return p.conn.pub(presenceTopic(p.desc.SubTopic), 1, mqhub.JSON, &mqhub.Presence{
+		ComponentID: p.comp.ID(),
+		Online:      online,
+		Host:        p.conn.hostID,
+	})
+}
+
+func (p *Publication) unexport() mqhub.Future {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.detach(p.endpointSet)
 }
 
 func (p *Publication) handleMessage(_ paho.Client, msg paho.Message) {
-	compID, endpoint := p.conn.parseTopic(msg.Topic())
+	topic, _ := trimCallTopic(msg.Topic())
+	compID, endpoint := p.conn.parseTopic(topic)
 	if endpoint != "" {
-		if sink := p.sinks[EndpointTopic(compID, endpoint)]; sink != nil {
-			sink.ConsumeMessage(p.conn.newMsg(msg))
+		p.lock.RLock()
+		sink := p.sinks[EndpointTopic(compID, endpoint)]
+		p.lock.RUnlock()
+		if sink != nil {
+			m := p.conn.newMsg(msg)
+			m.codec = sink.codec
+			future := sink.ConsumeMessage(m)
+			if m.call != nil || p.conn.reportsErrors() {
+				go p.complete(sink, m, future)
+			}
 		}
 	}
 }
 
+// complete replies the call and reports the failure once the message
+// is handled
+func (p *Publication) complete(sink *DataSink, msg *Message, future mqhub.Future) {
+	if future == nil {
+		future = &mqhub.ImmediateFuture{}
+	}
+	if msg.call != nil {
+		p.conn.reply(msg.call, msg.Codec(), future)
+	}
+	if err := future.Wait(); err != nil {
+		p.conn.reportError(sink.topic, err)
+	}
+}
+
+// compObserver applies the changes of a dynamic component to the publication,
+// it's compared by value to be removed
+type compObserver struct {
+	pub   *Publication
+	topic string
+}
+
+// EndpointAdded implements CompositionObserver
+func (o compObserver) EndpointAdded(endpoint mqhub.Endpoint) mqhub.Future {
+	set := newEndpointSet()
+	o.pub.populateEndpoint(&set, o.topic, endpoint)
+	return o.pub.add(set)
+}
+
+// EndpointRemoved implements CompositionObserver
+func (o compObserver) EndpointRemoved(endpoint mqhub.Endpoint) mqhub.Future {
+	set := newEndpointSet()
+	o.pub.populateEndpoint(&set, o.topic, endpoint)
+	return o.pub.remove(set)
+}
+
+// ComponentAdded implements CompositionObserver
+func (o compObserver) ComponentAdded(comp mqhub.Component) mqhub.Future {
+	set := newEndpointSet()
+	o.pub.populate(&set, path.Join(o.topic, comp.ID()), comp, true)
+	return o.pub.add(set)
+}
+
+// ComponentRemoved implements CompositionObserver
+func (o compObserver) ComponentRemoved(comp mqhub.Component) mqhub.Future {
+	set := newEndpointSet()
+	o.pub.populate(&set, path.Join(o.topic, comp.ID()), comp, false)
+	return o.pub.remove(set)
+}
+
 // DataEmitter is a consumer which publish the data to hub
 type DataEmitter struct {
 	pub    *Publication
+	info   mqhub.SinkInfo
 	topic  string
+	qos    byte
+	codec  mqhub.Codec
+	retain bool
 	source mqhub.MessageSource
+	state  mqhub.Message
+	lock   sync.Mutex
 }
 
-// ConsumeMessage emits the message
+// ConsumeMessage emits the message through the interceptors
 func (e *DataEmitter) ConsumeMessage(msg mqhub.Message) mqhub.Future {
-	return e.pub.conn.pub(e.topic, msg)
+	return e.pub.intercept(msg, &e.info, mqhub.MessageSinkFunc(e.emit))
+}
+
+func (e *DataEmitter) emit(msg mqhub.Message) mqhub.Future {
+	if msg.IsState() {
+		e.lock.Lock()
+		e.state = msg
+		e.lock.Unlock()
+	}
+	return e.pub.conn.pub(e.topic, e.qos, e.codec, msg)
+}
+
+// republish emits the last state again
+func (e *DataEmitter) republish() {
+	e.lock.Lock()
+	state := e.state
+	e.lock.Unlock()
+	if state != nil {
+		e.pub.conn.pub(e.topic, e.qos, e.codec, state)
+	}
+}
+
+// retained tells whether the datapoint may have a retained state
+func (e *DataEmitter) retained() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.retain || e.state != nil
 }
 
 func (e *DataEmitter) bind() {
@@ -127,12 +446,16 @@ func (e *DataEmitter) unbind() {
 
 // DataSink is a consumer receives messages from hub
 type DataSink struct {
-	pub   *Publication
-	topic string
-	sink  mqhub.MessageSink
+	pub    *Publication
+	info   mqhub.SinkInfo
+	topic  string
+	filter string
+	qos    byte
+	codec  mqhub.Codec
+	sink   mqhub.MessageSink
 }
 
 // ConsumeMessage implements MessageSink
 func (s *DataSink) ConsumeMessage(msg mqhub.Message) mqhub.Future {
-	return s.sink.ConsumeMessage(msg)
+	return s.pub.intercept(msg, &s.info, s.sink)
 }
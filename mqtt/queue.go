@@ -0,0 +1,360 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+)
+
+// QueuePolicy decides which message to discard when the offline queue is full
+type QueuePolicy int
+
+// Queue policies
+const (
+	// DropOldest discards the oldest message in the queue
+	DropOldest QueuePolicy = iota
+	// DropNewest rejects the message being published
+	DropNewest
+	// Coalesce keeps only the latest state per topic, and discards the
+	// oldest non-state message (or the oldest state if there's none)
+	// if the queue is still full
+	Coalesce
+	// Block blocks the publisher until the queue has space or the
+	// connector is closed
+	Block
+)
+
+func (p QueuePolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Coalesce:
+		return "coalesce"
+	case Block:
+		return "block"
+	}
+	return fmt.Sprintf("QueuePolicy(%d)", int(p))
+}
+
+// ParseQueuePolicy parses the policy from its name
+func ParseQueuePolicy(str string) (QueuePolicy, error) {
+	for _, p := range []QueuePolicy{DropOldest, DropNewest, Coalesce, Block} {
+		if p.String() == str {
+			return p, nil
+		}
+	}
+	return DropOldest, fmt.Errorf("invalid queue policy: %s", str)
+}
+
+var (
+	// ErrQueueFull is reported when a message is dropped by DropNewest
+	ErrQueueFull = fmt.Errorf("outbound queue full")
+	// ErrQueueClosed is reported when the connector is closed while blocking
+	ErrQueueClosed = fmt.Errorf("outbound queue closed")
+	// ErrQueueClientID is reported when the queue is persisted without
+	// an explicit client ID
+	ErrQueueClientID = fmt.Errorf("persisted outbound queue requires client ID")
+)
+
+// QueueOptions configures the queue buffering outgoing messages
+// while disconnected
+type QueueOptions struct {
+	// Size is the maximum number of queued messages, 0 disables the queue
+	Size int
+	// Policy is used when the queue is full
+	Policy QueuePolicy
+	// Dir is the directory to persist queued messages, so they survive
+	// a restart, the file is named by client ID which must be specified,
+	// otherwise the queue is not persisted
+	Dir string
+}
+
+// outboundMsg is an encoded message ready to publish
+type outboundMsg struct {
+	// ID identifies the message in the journal
+	ID       uint64              `json:"id"`
+	Topic    string              `json:"topic"`
+	QoS      byte                `json:"qos"`
+	Retained bool                `json:"retained,omitempty"`
+	Payload  []byte              `json:"payload"`
+	Props    *packets.Properties `json:"props,omitempty"`
+}
+
+// outboundQueue holds messages until connected, once flushed, messages
+// bypass the queue until the connection is lost
+type outboundQueue struct {
+	opts    QueueOptions
+	metrics mqhub.Metrics
+	msgs    []*outboundMsg
+	lastID  uint64
+	online  bool
+	closed  bool
+	lock    sync.Mutex
+	cond    *sync.Cond
+
+	// file is the journal appended with the queued and removed messages,
+	// it's compacted when the records outnumber the queue size
+	file    string
+	journal *os.File
+	records int
+}
+
+// journalRecord is a queued message, or the ID of a removed message
+type journalRecord struct {
+	Msg     *outboundMsg `json:"msg,omitempty"`
+	Removed uint64       `json:"removed,omitempty"`
+}
+
+// newOutboundQueue creates the queue and loads persisted messages,
+// the records following a corrupted one are discarded
+func newOutboundQueue(opts QueueOptions, clientID string, metrics mqhub.Metrics) *outboundQueue {
+	q := &outboundQueue{opts: opts, metrics: metrics}
+	q.cond = sync.NewCond(&q.lock)
+	if opts.Dir != "" {
+		q.file = filepath.Join(opts.Dir, "outbound-"+clientID+".log")
+		q.load()
+	}
+	return q
+}
+
+// load replays the journal
+func (q *outboundQueue) load() {
+	f, err := os.Open(q.file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec journalRecord
+		if dec.Decode(&rec) != nil {
+			break
+		}
+		q.records++
+		if rec.Msg != nil {
+			q.msgs = append(q.msgs, rec.Msg)
+			if rec.Msg.ID > q.lastID {
+				q.lastID = rec.Msg.ID
+			}
+		} else if i := q.indexOf(rec.Removed); i >= 0 {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+		}
+	}
+}
+
+func (q *outboundQueue) indexOf(id uint64) int {
+	for i, m := range q.msgs {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// offer queues the message if not online, or messages are still pending,
+// it returns false if the message should be published directly
+func (q *outboundQueue) offer(msg *outboundMsg) (bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.online && len(q.msgs) == 0 {
+		return false, nil
+	}
+	var err error
+	if q.opts.Policy == Coalesce && msg.Retained {
+		for i, m := range q.msgs {
+			if m.Retained && m.Topic == msg.Topic {
+				err = q.remove(i)
+				q.drop()
+				break
+			}
+		}
+	}
+	for len(q.msgs) >= q.opts.Size {
+		switch q.opts.Policy {
+		case DropNewest:
+			q.drop()
+			return true, ErrQueueFull
+		case Block:
+			if q.closed {
+				return true, ErrQueueClosed
+			}
+			q.cond.Wait()
+			if q.online && len(q.msgs) == 0 {
+				return false, nil
+			}
+		case Coalesce:
+			err = firstErr(err, q.dropOldest(true))
+		default:
+			err = firstErr(err, q.dropOldest(false))
+		}
+	}
+	q.lastID++
+	msg.ID = q.lastID
+	q.msgs = append(q.msgs, msg)
+	return true, firstErr(err, q.persist(&journalRecord{Msg: msg}))
+}
+
+// dropOldest removes the oldest message, with keepStates, the oldest
+// non-state message is preferred
+func (q *outboundQueue) dropOldest(keepStates bool) error {
+	q.drop()
+	for i, m := range q.msgs {
+		if !keepStates || !m.Retained {
+			return q.remove(i)
+		}
+	}
+	return q.remove(0)
+}
+
+// remove removes the message at index, must be called with lock held
+func (q *outboundQueue) remove(index int) error {
+	id := q.msgs[index].ID
+	q.msgs = append(q.msgs[:index], q.msgs[index+1:]...)
+	return q.persist(&journalRecord{Removed: id})
+}
+
+// drop counts the message discarded by the policy
+func (q *outboundQueue) drop() {
+	if q.metrics != nil {
+		q.metrics.Count(MetricQueueDropped, nil, 1)
+	}
+}
+
+func firstErr(err, next error) error {
+	if err != nil {
+		return err
+	}
+	return next
+}
+
+// flush publishes queued messages in order, it stops on the first failure
+// and the queue remains offline
+func (q *outboundQueue) flush(publish func(*outboundMsg) error) error {
+	for {
+		q.lock.Lock()
+		if len(q.msgs) == 0 {
+			q.online = true
+			q.cond.Broadcast()
+			q.lock.Unlock()
+			return nil
+		}
+		msg := q.msgs[0]
+		q.lock.Unlock()
+		if err := publish(msg); err != nil {
+			return err
+		}
+		q.lock.Lock()
+		var err error
+		// the message may already be dropped
+		if len(q.msgs) > 0 && q.msgs[0] == msg {
+			err = q.remove(0)
+		}
+		q.cond.Broadcast()
+		q.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// offline starts queuing messages
+func (q *outboundQueue) offline() {
+	q.lock.Lock()
+	q.online = false
+	q.lock.Unlock()
+}
+
+// close wakes up blocked publishers, and closes the journal
+func (q *outboundQueue) close() {
+	q.lock.Lock()
+	q.online = false
+	q.closed = true
+	q.cond.Broadcast()
+	if q.journal != nil {
+		q.journal.Close()
+		q.journal = nil
+	}
+	q.lock.Unlock()
+}
+
+// reopen allows blocking again after connecting again
+func (q *outboundQueue) reopen() {
+	q.lock.Lock()
+	q.closed = false
+	q.lock.Unlock()
+}
+
+func (q *outboundQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.msgs)
+}
+
+// persist appends the record to the journal, which is compacted to the
+// queued messages when the records outnumber twice the queue size, must be
+// called with lock held
+func (q *outboundQueue) persist(rec *journalRecord) error {
+	if q.file == "" {
+		return nil
+	}
+	if q.records >= 2*q.opts.Size {
+		return q.compact()
+	}
+	if q.journal == nil {
+		f, err := os.OpenFile(q.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		q.journal = f
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = q.journal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	q.records++
+	return nil
+}
+
+// compact rewrites the journal with the queued messages
+func (q *outboundQueue) compact() error {
+	if q.journal != nil {
+		q.journal.Close()
+		q.journal = nil
+	}
+	tmp := q.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, msg := range q.msgs {
+		if err = enc.Encode(&journalRecord{Msg: msg}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, q.file)
+	}
+	if err == nil {
+		q.records = len(q.msgs)
+	}
+	return err
+}
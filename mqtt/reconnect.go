@@ -0,0 +1,159 @@
+package mqtt
+
+import (
+	"math/rand"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqhub"
+)
+
+// Backoff defines the delays between reconnect attempts
+type Backoff struct {
+	// Initial is the delay before the first attempt
+	Initial time.Duration
+	// Max is the upper limit of the delay
+	Max time.Duration
+	// Multiplier grows the delay after each failed attempt
+	Multiplier float64
+	// Jitter randomizes the delay by the fraction (0 - 1)
+	Jitter float64
+}
+
+// DefaultBackoff is used when Backoff is not specified
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        2 * time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay calculates the delay before the attempt (starts from 0)
+func (b Backoff) Delay(attempt int) time.Duration {
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoff.Initial
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultBackoff.Max
+	}
+	if b.Multiplier == 0 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	} else if b.Multiplier < 1 {
+		b.Multiplier = 1
+	}
+	delay := float64(b.Initial)
+	for i := 0; i < attempt && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(delay)
+}
+
+// connectionLost is invoked by the client when the connection drops
+func (c *Connector) connectionLost(_ paho.Client, err error) {
+	c.lock.Lock()
+	closed := c.closed
+	start := c.autoReconnect && !closed && !c.reconnecting
+	if start {
+		c.reconnecting = true
+	}
+	stop := c.stop
+	c.lock.Unlock()
+	if closed {
+		return
+	}
+	if c.queue != nil {
+		c.queue.offline()
+	}
+	c.lifecycle.Emit(mqhub.ConnectionLost, err)
+	if start {
+		go c.reconnect(stop)
+	}
+}
+
+// reconnect keeps trying to connect and restore until succeeded or the
+// connector is closed, the failure of the previous attempt is reported
+// with Reconnecting
+func (c *Connector) reconnect(stop chan struct{}) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		c.lifecycle.Emit(mqhub.Reconnecting, err)
+		select {
+		case <-stop:
+			c.lock.Lock()
+			c.reconnecting = false
+			c.lock.Unlock()
+			return
+		case <-time.After(c.backoff.Delay(attempt)):
+		}
+		token := c.Client.Connect()
+		token.Wait()
+		if err = token.Error(); err != nil {
+			continue
+		}
+		// reset before restoring, so a new connection lost is able to
+		// start reconnecting again
+		c.lock.Lock()
+		c.reconnecting = false
+		closed := c.closed
+		c.lock.Unlock()
+		// closed while connecting
+		if closed {
+			c.Client.Disconnect(0)
+			return
+		}
+		if err = c.restore(); err == nil {
+			break
+		}
+		// retry unless the connection is lost and reconnecting again
+		c.lock.Lock()
+		retry := !c.reconnecting && !c.closed
+		if retry {
+			c.reconnecting = true
+		}
+		c.lock.Unlock()
+		if !retry {
+			return
+		}
+		c.Client.Disconnect(0)
+	}
+	c.connected()
+}
+
+// connected emits Connected unless the connector is closed meanwhile, the
+// event is emitted with the lock held, so it never follows Closed
+func (c *Connector) connected() {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if !c.closed {
+		c.lifecycle.Emit(mqhub.Connected, nil)
+	}
+}
+
+// restore re-establishes subscriptions and exported components
+// after reconnected, the connection is useless if subscribing or flushing
+// the queue fails
+func (c *Connector) restore() error {
+	if filters := c.handlers.resubscribe(); len(filters) > 0 {
+		if err := c.subscribe(filters).Wait(); err != nil {
+			return err
+		}
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.announce(true)
+	c.lock.RLock()
+	exports := make([]*Publication, len(c.exports))
+	copy(exports, c.exports)
+	c.lock.RUnlock()
+	for _, pub := range exports {
+		pub.reexport(c.republishStates)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/robotalks/mqhub.go/mqhub"
+	"github.com/robotalks/mqhub.go/mqtt/packets"
+	"github.com/robotalks/mqhub.go/utils"
+)
+
+// replyEndpoint is the endpoint of the connector receiving replies
+const replyEndpoint = "$reply"
+
+// callInfo tells where to send the reply of a request, Type is the
+// content type of the request not encoded as JSON with MQTT 3.x
+type callInfo struct {
+	Reply string `json:"reply"`
+	ID    string `json:"id"`
+	Type  string `json:"type,omitempty"`
+}
+
+// callEnvelope wraps the request for MQTT 3.x which has no
+// response topic and correlation data, the request not encoded
+// as JSON is carried in Data
+type callEnvelope struct {
+	Call   *callInfo       `json:"$call"`
+	Params json.RawMessage `json:"$params,omitempty"`
+	Data   []byte          `json:"$data,omitempty"`
+}
+
+// callSuffix is appended to the topic of the endpoint for the requests
+// wrapped in callEnvelope, so they are never mistaken for the payloads
+// of ordinary messages
+const callSuffix = "/$call"
+
+// trimCallTopic returns the topic of the endpoint, and whether the topic
+// is for the wrapped requests
+func trimCallTopic(topic string) (string, bool) {
+	if strings.HasSuffix(topic, callSuffix) {
+		return topic[:len(topic)-len(callSuffix)], true
+	}
+	return topic, false
+}
+
+// replyEnvelope is the payload of a reply, the result is encoded by the
+// codec of the request, carried in Data with the content type in Type
+// if it's not JSON
+type replyEnvelope struct {
+	ID     string          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Type   string          `json:"type,omitempty"`
+	Data   []byte          `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// decode decodes the result into out
+func (r *replyEnvelope) decode(out interface{}) error {
+	if r.Type == "" {
+		if len(r.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(r.Result, out)
+	}
+	codec := mqhub.LookupCodec(r.Type)
+	if codec == nil {
+		return fmt.Errorf("unknown codec %s", r.Type)
+	}
+	return codec.Decode(r.Data, out)
+}
+
+// decodeCall extracts the call info, the topic of the endpoint and the
+// actual payload, the request is identified by the response topic with
+// MQTT v5, or the topic for the wrapped requests
+func decodeCall(msg paho.Message) (*callInfo, string, []byte) {
+	payload := msg.Payload()
+	topic, wrapped := trimCallTopic(msg.Topic())
+	if props := rawProperties(msg); props != nil && props.ResponseTopic != "" {
+		return &callInfo{Reply: props.ResponseTopic, ID: string(props.CorrelationData)}, topic, payload
+	}
+	if wrapped {
+		var env callEnvelope
+		if json.Unmarshal(payload, &env) == nil && env.Call != nil {
+			if env.Data != nil {
+				return env.Call, topic, env.Data
+			}
+			return env.Call, topic, env.Params
+		}
+	}
+	return nil, topic, payload
+}
+
+func (c *Connector) replyTopic() string {
+	return EndpointTopic(hostTopic(c.clientID), replyEndpoint)
+}
+
+// subscribeReplies subscribes the reply topic on the first call
+func (c *Connector) subscribeReplies(ctx context.Context) error {
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+	if c.replyHandler != nil {
+		return nil
+	}
+	handler := MakeHandlerRef(c.handleReply)
+	if err := c.sub(map[string]byte{c.replyTopic(): 1}, handler).WaitContext(ctx); err != nil {
+		c.unsub([]string{c.replyTopic()}, handler)
+		return err
+	}
+	c.replyHandler = handler
+	c.calls = make(map[string]chan *replyEnvelope)
+	return nil
+}
+
+func (c *Connector) call(ctx context.Context, topic string, qos byte, codec mqhub.Codec, req interface{}, resp interface{}) error {
+	if err := c.subscribeReplies(ctx); err != nil {
+		return err
+	}
+	msg, ok := req.(mqhub.Message)
+	if !ok {
+		msg = mqhub.MsgFrom(req)
+	}
+	info := &callInfo{Reply: c.topicPrefix + c.replyTopic(), ID: utils.UniqueID()}
+	out := &outboundMsg{Topic: c.topicPrefix + topic, QoS: qos}
+	// the content type of the request is carried by the envelope instead
+	// of the mark with MQTT 3.x
+	contentType, err := c.encodeValue(out, msg, codec)
+	if err == nil {
+		err = c.encodeMetadata(out, msg)
+	}
+	if err != nil {
+		return err
+	}
+	info.Type = contentType
+	if _, ok := c.Client.(propertiesPublisher); ok {
+		if out.Props == nil {
+			out.Props = &packets.Properties{}
+		}
+		out.Props.ResponseTopic = info.Reply
+		out.Props.CorrelationData = []byte(info.ID)
+	} else {
+		out.Topic += callSuffix
+		env := &callEnvelope{Call: info}
+		if out.Payload == nil || json.Valid(out.Payload) {
+			env.Params = out.Payload
+		} else {
+			env.Data = out.Payload
+		}
+		if out.Payload, err = json.Marshal(env); err != nil {
+			return err
+		}
+	}
+
+	replyCh := make(chan *replyEnvelope, 1)
+	c.callsLock.Lock()
+	c.calls[info.ID] = replyCh
+	c.callsLock.Unlock()
+	defer func() {
+		c.callsLock.Lock()
+		delete(c.calls, info.ID)
+		c.callsLock.Unlock()
+	}()
+
+	if err = (&Future{token: c.publish(out)}).WaitContext(ctx); err != nil {
+		return err
+	}
+	select {
+	case reply := <-replyCh:
+		if reply.Error != "" {
+			return &mqhub.RemoteError{Message: reply.Error}
+		}
+		if resp != nil {
+			return reply.decode(resp)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Connector) handleReply(_ paho.Client, msg paho.Message) {
+	var reply replyEnvelope
+	if json.Unmarshal(msg.Payload(), &reply) != nil {
+		return
+	}
+	c.callsLock.Lock()
+	replyCh := c.calls[reply.ID]
+	c.callsLock.Unlock()
+	if replyCh != nil {
+		select {
+		case replyCh <- &reply:
+		default:
+		}
+	}
+}
+
+// reply waits for the result of the handler and sends it to the caller
+// encoded by the codec of the request
+func (c *Connector) reply(call *callInfo, codec mqhub.Codec, future mqhub.Future) {
+	reply := &replyEnvelope{ID: call.ID}
+	if err := future.Wait(); err != nil {
+		reply.Error = err.Error()
+	} else if r, ok := future.(*mqhub.Reply); ok && r.Value != nil {
+		result, err := codec.Encode(r.Value)
+		switch {
+		case err != nil:
+			reply.Error = err.Error()
+		case codec == mqhub.JSON:
+			reply.Result = result
+		default:
+			reply.Type, reply.Data = codec.ContentType(), result
+		}
+	}
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	out := &outboundMsg{Topic: call.Reply, QoS: 1, Payload: payload}
+	if _, ok := c.Client.(propertiesPublisher); ok {
+		out.Props = &packets.Properties{CorrelationData: []byte(call.ID)}
+	}
+	c.publish(out)
+}
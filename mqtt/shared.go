@@ -0,0 +1,33 @@
+package mqtt
+
+import "github.com/robotalks/mqhub.go/mqtt/topics"
+
+// SharePrefix starts the filter of a shared subscription
+//
+//	$share/<group>/<filter>
+//
+// each message matching the filter is delivered to only one of the
+// subscribers in the group
+const SharePrefix = topics.SharePrefix
+
+// SharedFilter creates the filter of a shared subscription, the filter
+// is not shared if group is empty
+func SharedFilter(group, filter string) string {
+	if group == "" {
+		return filter
+	}
+	return SharePrefix + group + "/" + filter
+}
+
+// SplitSharedFilter returns the group and the actual filter of a shared
+// subscription, the group is empty if the filter is not shared
+func SplitSharedFilter(filter string) (group, topicFilter string) {
+	group, topicFilter, _ = topics.SplitShared(filter)
+	return group, topicFilter
+}
+
+// prefixFilter inserts the prefix before the actual filter
+func prefixFilter(prefix, filter string) string {
+	group, topicFilter := SplitSharedFilter(filter)
+	return SharedFilter(group, prefix+topicFilter)
+}
@@ -0,0 +1,75 @@
+// Package topics validates and matches MQTT topic names and filters, shared
+// by the broker and the clients
+package topics
+
+import "strings"
+
+// ValidName checks the topic name used in PUBLISH
+func ValidName(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#\x00")
+}
+
+// SharePrefix starts the filter of a shared subscription
+//
+//	$share/<group>/<filter>
+const SharePrefix = "$share/"
+
+// SplitShared returns the group and the actual filter of a shared
+// subscription, ok is false if the filter is not shared
+func SplitShared(filter string) (group, topicFilter string, ok bool) {
+	if !strings.HasPrefix(filter, SharePrefix) {
+		return "", filter, false
+	}
+	group, topicFilter, _ = strings.Cut(filter[len(SharePrefix):], "/")
+	return group, topicFilter, true
+}
+
+// ValidFilter checks the topic filter used in SUBSCRIBE/UNSUBSCRIBE
+func ValidFilter(filter string) bool {
+	if filter == "" || strings.ContainsRune(filter, 0) {
+		return false
+	}
+	if group, topicFilter, shared := SplitShared(filter); shared {
+		if group == "" || strings.ContainsAny(group, "+#") || topicFilter == "" {
+			return false
+		}
+		filter = topicFilter
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#":
+			if i != len(levels)-1 {
+				return false
+			}
+		case level == "+":
+		case strings.ContainsAny(level, "+#"):
+			return false
+		}
+	}
+	return true
+}
+
+// Matches indicates the topic name matches the filter
+// topics starting with $ are not matched by filters starting with wildcards,
+// a shared subscription matches the same topics as its actual filter
+func Matches(filter, topic string) bool {
+	_, filter, _ = SplitShared(filter)
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
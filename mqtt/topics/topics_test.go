@@ -0,0 +1,28 @@
+package topics_test
+
+import (
+	"testing"
+
+	"github.com/robotalks/mqhub.go/mqtt/topics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatches(t *testing.T) {
+	a := assert.New(t)
+	a.True(topics.Matches("a/+/c", "a/b/c"))
+	a.True(topics.Matches("a/#", "a"))
+	a.True(topics.Matches("a/#", "a/b/c"))
+	a.True(topics.Matches("#", "a/b"))
+	a.False(topics.Matches("a/+", "a/b/c"))
+	a.False(topics.Matches("#", "$SYS/a"))
+	a.False(topics.Matches("+/a", "$SYS/a"))
+	a.True(topics.Matches("$SYS/#", "$SYS/a"))
+	a.False(topics.ValidFilter("a/#/b"))
+	a.False(topics.ValidFilter("a+/b"))
+	a.False(topics.ValidName("a/+"))
+	a.True(topics.Matches("$share/g/a/+", "a/b"))
+	a.True(topics.ValidFilter("$share/g/a/#"))
+	a.False(topics.ValidFilter("$share/g"))
+	a.False(topics.ValidFilter("$share//a"))
+	a.False(topics.ValidFilter("$share/g+/a"))
+}
@@ -0,0 +1,97 @@
+package mqtt
+
+import "strings"
+
+// TopicTrie indexes values by topic filters, the cost of matching a topic
+// depends on the levels of the topic rather than the number of filters.
+// Same as MQTT, topics starting with $ are not matched by filters starting
+// with wildcards
+type TopicTrie[V any] struct {
+	root trieNode[V]
+}
+
+type trieNode[V any] struct {
+	children map[string]*trieNode[V]
+	value    V
+	set      bool
+}
+
+// NewTopicTrie creates an empty TopicTrie
+func NewTopicTrie[V any]() *TopicTrie[V] {
+	return &TopicTrie[V]{}
+}
+
+// Put associates the value with the filter
+func (t *TopicTrie[V]) Put(filter string, value V) {
+	node := &t.root
+	for _, token := range TokenizeTopic(filter) {
+		child := node.children[token]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode[V])
+			}
+			child = &trieNode[V]{}
+			node.children[token] = child
+		}
+		node = child
+	}
+	node.value, node.set = value, true
+}
+
+// Get returns the value associated with the filter
+func (t *TopicTrie[V]) Get(filter string) (value V, ok bool) {
+	node := &t.root
+	for _, token := range TokenizeTopic(filter) {
+		if node = node.children[token]; node == nil {
+			return value, false
+		}
+	}
+	return node.value, node.set
+}
+
+// Delete removes the filter
+func (t *TopicTrie[V]) Delete(filter string) {
+	t.root.delete(TokenizeTopic(filter))
+}
+
+// delete returns true if the node becomes empty
+func (n *trieNode[V]) delete(tokens []string) bool {
+	if len(tokens) == 0 {
+		var zero V
+		n.value, n.set = zero, false
+	} else if child := n.children[tokens[0]]; child != nil && child.delete(tokens[1:]) {
+		delete(n.children, tokens[0])
+	}
+	return !n.set && len(n.children) == 0
+}
+
+// Match calls fn with the values of all filters matching the topic
+func (t *TopicTrie[V]) Match(topic string, fn func(V)) {
+	tokens := TokenizeTopic(topic)
+	t.root.match(tokens, !strings.HasPrefix(topic, "$"), fn)
+}
+
+// match visits the values matching tokens, wildcards are skipped
+// at the first level of a topic starting with $
+func (n *trieNode[V]) match(tokens []string, wildcards bool, fn func(V)) {
+	if wildcards {
+		// # also matches the parent level
+		if multi := n.children["#"]; multi != nil && multi.set {
+			fn(multi.value)
+		}
+	}
+	if len(tokens) == 0 {
+		if n.set {
+			fn(n.value)
+		}
+		return
+	}
+	if child := n.children[tokens[0]]; child != nil {
+		child.match(tokens[1:], true, fn)
+	}
+	if wildcards {
+		if child := n.children["+"]; child != nil {
+			child.match(tokens[1:], true, fn)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package mqtt
 
 import (
+	"context"
+	"strings"
+
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/robotalks/mqhub.go/mqhub"
 )
@@ -10,19 +13,25 @@ type topicWatcher struct {
 	target  mqhub.Watchable
 	topic   string
 	sink    mqhub.MessageSink
+	info    mqhub.SinkInfo
 	handler *HandlerRef
 }
 
-func watchTopic(conn *Connector, target mqhub.Watchable,
-	topic string, sink mqhub.MessageSink) (*topicWatcher, error) {
+func watchTopic(ctx context.Context, conn *Connector, target mqhub.Watchable,
+	topic string, qos byte, sink mqhub.MessageSink) (*topicWatcher, error) {
 	w := &topicWatcher{
 		conn:   conn,
 		target: target,
 		topic:  topic,
 		sink:   sink,
+		info:   mqhub.SinkInfo{Kind: mqhub.WatcherSink, Topic: topic},
 	}
 	w.handler = MakeHandlerRef(w.recvMessage)
-	return w, w.conn.sub([]string{topic}, w.handler).Wait()
+	err := w.conn.sub(map[string]byte{topic: qos}, w.handler).WaitContext(ctx)
+	if err != nil {
+		w.Close()
+	}
+	return w, err
 }
 
 // Close implements Watcher
@@ -38,7 +47,14 @@ func (w *topicWatcher) Watched() mqhub.Watchable {
 
 func (w *topicWatcher) recvMessage(_ paho.Client, msg paho.Message) {
 	_, endpoint := w.conn.parseTopic(msg.Topic())
-	if endpoint != "" {
-		w.sink.ConsumeMessage(w.conn.newMsg(msg))
+	if endpoint != "" && !w.skipReserved(endpoint) {
+		mqhub.Intercept(w.sink, &w.info, w.conn.interceptor).ConsumeMessage(w.conn.newMsg(msg))
 	}
 }
+
+// skipReserved drops the messages of reserved endpoints unless watched
+// explicitly, like advertisements, presence and replies, they are used by
+// the hub rather than carry data
+func (w *topicWatcher) skipReserved(endpoint string) bool {
+	return mqhub.IsReservedEndpoint(endpoint) && strings.ContainsAny(w.topic, "+#")
+}